@@ -0,0 +1,110 @@
+package stratago
+
+import (
+	"github.com/thomazdavis/stratago/kind"
+	"github.com/thomazdavis/stratago/wal"
+)
+
+// WriteBatch collects Puts, Deletes and Merges that are applied atomically by db.Write.
+// The whole batch is logged as a single WAL record with one fsync, so after a
+// crash either every operation in it is recovered or none is, whichever
+// column families the operations go to.
+type WriteBatch struct {
+	entries  []wal.Entry
+	families []*ColumnFamily // Family of each entry, nil for the default one
+}
+
+// NewWriteBatch returns an empty batch
+func NewWriteBatch() *WriteBatch {
+	return &WriteBatch{}
+}
+
+// Put queues a key-value write. Key and value are copied.
+func (b *WriteBatch) Put(key, value []byte) {
+	b.PutCF(nil, key, value)
+}
+
+// PutCF queues a key-value write to a column family, nil meaning the default one
+func (b *WriteBatch) PutCF(cf *ColumnFamily, key, value []byte) {
+	b.add(cf, wal.Entry{
+		Kind:  kind.Value,
+		Key:   append([]byte{}, key...),
+		Value: append([]byte{}, value...),
+	})
+}
+
+// Delete queues a tombstone for key
+func (b *WriteBatch) Delete(key []byte) {
+	b.DeleteCF(nil, key)
+}
+
+// DeleteCF queues a tombstone for key in a column family
+func (b *WriteBatch) DeleteCF(cf *ColumnFamily, key []byte) {
+	b.add(cf, wal.Entry{
+		Kind: kind.Delete,
+		Key:  append([]byte{}, key...),
+	})
+}
+
+// Merge queues a merge operand for key. Key and operand are copied.
+func (b *WriteBatch) Merge(key, operand []byte) {
+	b.MergeCF(nil, key, operand)
+}
+
+// MergeCF queues a merge operand for key in a column family
+func (b *WriteBatch) MergeCF(cf *ColumnFamily, key, operand []byte) {
+	b.add(cf, wal.Entry{
+		Kind:  kind.Merge,
+		Key:   append([]byte{}, key...),
+		Value: append([]byte{}, operand...),
+	})
+}
+
+func (b *WriteBatch) add(cf *ColumnFamily, e wal.Entry) {
+	if cf != nil {
+		e.Family = cf.id
+	}
+	b.entries = append(b.entries, e)
+	b.families = append(b.families, cf)
+}
+
+// Clear drops every queued operation so the batch can be reused
+func (b *WriteBatch) Clear() {
+	b.entries = b.entries[:0]
+	b.families = b.families[:0]
+}
+
+// Count returns the number of queued operations
+func (b *WriteBatch) Count() int {
+	return len(b.entries)
+}
+
+// Write atomically applies every operation in the batch.
+// Later operations on the same key win over earlier ones.
+func (db *StrataGo) Write(batch *WriteBatch) error {
+	return db.WriteWithOptions(batch, nil)
+}
+
+// WriteWithOptions atomically applies a batch with per-write durability options
+func (db *StrataGo) WriteWithOptions(batch *WriteBatch, wo *WriteOptions) error {
+	if batch == nil || batch.Count() == 0 {
+		return nil
+	}
+	db.mu.RLock()
+	for i, e := range batch.entries {
+		cf := batch.families[i]
+		if cf == nil {
+			cf = db.defaultCF
+		}
+		err := db.checkFamily(cf)
+		if err == nil && e.Kind == kind.Merge && cf.opts.MergeOperator == nil {
+			err = errNoMergeOperator
+		}
+		if err != nil {
+			db.mu.RUnlock()
+			return err
+		}
+	}
+	db.mu.RUnlock()
+	return db.apply(batch.entries, wo)
+}
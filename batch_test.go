@@ -0,0 +1,90 @@
+package stratago
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteBatch_Atomic(t *testing.T) {
+	dataDir := "test_batch"
+	defer os.RemoveAll(dataDir)
+
+	db, err := Open(dataDir)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	db.Put([]byte("stale"), []byte("x"))
+
+	batch := NewWriteBatch()
+	batch.Put([]byte("a"), []byte("1"))
+	batch.Put([]byte("b"), []byte("2"))
+	batch.Delete([]byte("stale"))
+	batch.Put([]byte("a"), []byte("3")) // Later operation on the same key wins
+	assert.Equal(t, 4, batch.Count())
+
+	seqBefore := db.lastSeq.Load()
+	assert.NoError(t, db.Write(batch))
+	assert.Equal(t, seqBefore+4, db.lastSeq.Load())
+
+	val, found := db.Get([]byte("a"))
+	assert.True(t, found)
+	assert.Equal(t, []byte("3"), val)
+
+	val, found = db.Get([]byte("b"))
+	assert.True(t, found)
+	assert.Equal(t, []byte("2"), val)
+
+	_, found = db.Get([]byte("stale"))
+	assert.False(t, found)
+
+	batch.Clear()
+	assert.Equal(t, 0, batch.Count())
+	assert.NoError(t, db.Write(batch), "Empty batch is a no-op")
+}
+
+func TestWriteBatch_Recovery(t *testing.T) {
+	dataDir := "test_batch_recovery"
+	defer os.RemoveAll(dataDir)
+
+	db, err := Open(dataDir)
+	assert.NoError(t, err)
+
+	db.Put([]byte("gone"), []byte("x"))
+
+	batch := NewWriteBatch()
+	batch.Put([]byte("k1"), []byte("v1"))
+	batch.Put([]byte("k2"), []byte("v2"))
+	batch.Delete([]byte("gone"))
+	assert.NoError(t, db.Write(batch))
+
+	// Simulate a crash: no final flush, the WAL is all we have
+	db.wal.Close()
+
+	db2, err := Open(dataDir)
+	assert.NoError(t, err)
+	defer db2.Close()
+
+	val, found := db2.Get([]byte("k1"))
+	assert.True(t, found)
+	assert.Equal(t, []byte("v1"), val)
+
+	val, found = db2.Get([]byte("k2"))
+	assert.True(t, found)
+	assert.Equal(t, []byte("v2"), val)
+
+	_, found = db2.Get([]byte("gone"))
+	assert.False(t, found)
+}
+
+func TestWriteBatch_CopiesInput(t *testing.T) {
+	batch := NewWriteBatch()
+	key := []byte("key")
+	val := []byte("val")
+	batch.Put(key, val)
+
+	key[0], val[0] = 'X', 'X'
+	assert.Equal(t, []byte("key"), batch.entries[0].Key)
+	assert.Equal(t, []byte("val"), batch.entries[0].Value)
+}
@@ -19,7 +19,7 @@ func main() {
 
 	scanner := bufio.NewScanner(os.Stdin)
 	fmt.Println("\nStrataGo Shell")
-	fmt.Println("Commands: SET <key> <val> | GET <key> | DELETE <key> | FLUSH | PURGE | LISTALL | EXIT")
+	fmt.Println("Commands: SET <key> <val> | GET <key> | DELETE <key> | FLUSH | COMPACT [start] [end] | PURGE | LISTALL | EXIT")
 
 	for {
 		fmt.Print("stratago> ")
@@ -48,8 +48,10 @@ func main() {
 				fmt.Println("Usage: GET <key>")
 				continue
 			}
-			val, found := db.Get([]byte(parts[1]))
-			if found {
+			val, found, err := db.GetWithOptions([]byte(parts[1]), nil)
+			if err != nil {
+				fmt.Printf("Error reading: %v\n", err)
+			} else if found {
 				fmt.Printf("\"%s\"\n", string(val))
 			} else {
 				fmt.Println("(nil)")
@@ -74,6 +76,22 @@ func main() {
 				fmt.Println("OK")
 			}
 
+		case "COMPACT":
+			// Without bounds the whole key space is compacted
+			var start, end []byte
+			if len(parts) > 1 {
+				start = []byte(parts[1])
+			}
+			if len(parts) > 2 {
+				end = []byte(parts[2])
+			}
+			fmt.Println("Compacting...")
+			if err := db.CompactRange(start, end); err != nil {
+				fmt.Printf("Error compacting: %v\n", err)
+			} else {
+				fmt.Println("OK")
+			}
+
 		case "LISTALL":
 			fmt.Println("\n----- STRATAGO FULL LAYER INSPECTION -----")
 
@@ -93,9 +111,12 @@ func main() {
 				}
 			}
 
-			// WAL (On-disk)
-			walData, _ := db.GetWAL().Recover()
-			fmt.Printf("\n[WAL: %s] (%d entries)\n", db.GetWAL().Path(), len(walData))
+			// WAL (On-disk), every live segment
+			walData, err := db.GetWALContents()
+			if err != nil {
+				fmt.Printf("\nError reading WAL: %v\n", err)
+			}
+			fmt.Printf("\n[WAL] (%d entries)\n", len(walData))
 			for k, v := range walData {
 				fmt.Printf("  %s: \"%s\"\n", k, string(v))
 			}
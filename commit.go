@@ -0,0 +1,160 @@
+package stratago
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/thomazdavis/stratago/wal"
+)
+
+// maxGroupBytes caps the key and value bytes a commit leader gathers into one WAL write
+const maxGroupBytes = 1024 * 1024 // 1MB
+
+// writer is a write waiting in the commit queue
+type writer struct {
+	entries []wal.Entry
+	opts    WriteOptions
+	cond    sync.Cond // Signalled when the write is done or its writer leads the queue
+	done    bool
+	err     error
+}
+
+// apply logs entries under consecutive sequence numbers and inserts them into the active memtable.
+//
+// Concurrent writes go through a leader/follower pipeline. Writers queue up, and the one at
+// the head becomes the leader: it gathers the writes queued behind it into a group, logs the
+// whole group as one WAL record with a single fsync, applies it to the memtable and then wakes
+// every follower with the outcome. N concurrent writers therefore share one fsync instead of
+// paying for N of them in a row.
+func (db *StrataGo) apply(entries []wal.Entry, wo *WriteOptions) error {
+	w := &writer{entries: entries}
+	if wo != nil {
+		w.opts = *wo
+	}
+	w.cond.L = &db.queueMu
+
+	db.queueMu.Lock()
+	db.writers = append(db.writers, w)
+	for !w.done && db.writers[0] != w {
+		w.cond.Wait()
+	}
+	if w.done {
+		// A leader committed this write as part of its group
+		db.queueMu.Unlock()
+		return w.err
+	}
+	db.queueMu.Unlock()
+
+	// Writers keep queueing while the leader waits for a WAL rotation to finish
+	db.writeMu.Lock()
+	group, groupEntries, sync := db.buildGroup()
+	err := db.commit(groupEntries, sync, w.opts.DisableWAL)
+	db.writeMu.Unlock()
+
+	db.queueMu.Lock()
+	for _, follower := range group[1:] {
+		follower.err = err
+		follower.done = true
+		follower.cond.Signal()
+	}
+	db.writers = db.writers[len(group):]
+	if len(db.writers) > 0 {
+		// Hand leadership to the next queued writer
+		db.writers[0].cond.Signal()
+	}
+	db.queueMu.Unlock()
+
+	return err
+}
+
+// buildGroup returns the writers at the head of the queue that the leader commits
+// together, their entries in queue order, and whether any of them asked for an fsync.
+// Writes that skip the WAL are never grouped with writes that use it.
+func (db *StrataGo) buildGroup() ([]*writer, []wal.Entry, bool) {
+	db.queueMu.Lock()
+	defer db.queueMu.Unlock()
+
+	leader := db.writers[0]
+	group := []*writer{leader}
+	entries := leader.entries
+	size := entriesSize(entries)
+	sync := leader.opts.Sync
+	for _, w := range db.writers[1:] {
+		if size+entriesSize(w.entries) > maxGroupBytes || w.opts.DisableWAL != leader.opts.DisableWAL {
+			break
+		}
+		if len(group) == 1 {
+			// Copy so the first writer's slice is not appended to
+			entries = append([]wal.Entry{}, entries...)
+		}
+		group = append(group, w)
+		entries = append(entries, w.entries...)
+		size += entriesSize(w.entries)
+		sync = sync || w.opts.Sync
+	}
+	return group, entries, sync
+}
+
+// commit logs a group with one WAL write, fsynced if sync is set or SyncMode is SyncAlways,
+// and applies it to the memtables of its families. A full WAL segment is rolled over first,
+// so a segment exceeds WALSegmentSize by at most one group, and so is a segment that failed
+// a write and may end in a torn record. Callers must hold db.writeMu.
+func (db *StrataGo) commit(entries []wal.Entry, sync, disableWAL bool) error {
+	db.mu.RLock()
+	closed := db.closed
+	db.mu.RUnlock()
+	if closed {
+		return fmt.Errorf("database is closed")
+	}
+
+	seq := db.lastSeq.Load() + 1
+	if !disableWAL {
+		if db.wal.Size() >= db.opts.WALSegmentSize || db.wal.Err() != nil {
+			db.mu.Lock()
+			_, err := db.switchLog()
+			db.mu.Unlock()
+			if err != nil {
+				return err
+			}
+		}
+		if err := db.wal.Append(seq, entries, sync || db.opts.SyncMode == SyncAlways); err != nil {
+			return err
+		}
+	}
+
+	db.mu.Lock()
+	needsFlush := false
+	for i, e := range entries {
+		cf := db.families[e.Family]
+		if cf == nil {
+			continue // The family was dropped after the write was accepted
+		}
+		cf.current.active.Add(e.Key, seq+uint64(i), e.Kind, e.Value)
+		needsFlush = needsFlush || cf.current.active.SizeBytes >= cf.opts.MemtableThreshold
+	}
+
+	if needsFlush {
+		select {
+		case db.flushChan <- struct{}{}:
+			// Signal to flush sent
+		default:
+			// Channel is full (Flush already pending/running)
+			// Ignoring request
+		}
+	}
+	db.mu.Unlock()
+
+	// Publish the writes to new snapshots and iterators
+	db.lastSeq.Store(seq + uint64(len(entries)) - 1)
+
+	return nil
+}
+
+// entriesSize returns the number of key and value bytes of entries
+func entriesSize(entries []wal.Entry) int {
+	size := 0
+	for _, e := range entries {
+		size += len(e.Key) + len(e.Value)
+	}
+	return size
+}
@@ -0,0 +1,282 @@
+package stratago
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/thomazdavis/stratago/kind"
+	"github.com/thomazdavis/stratago/wal"
+)
+
+func TestGroupCommit_CombinesQueuedWriters(t *testing.T) {
+	dataDir := "test_group_commit"
+	defer os.RemoveAll(dataDir)
+
+	db, err := Open(dataDir)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// Holding writeMu stalls the leader, so every other writer queues up behind it
+	const writers = 16
+	db.writeMu.Lock()
+	var wg sync.WaitGroup
+	for i := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, db.Put(fmt.Appendf(nil, "key%02d", i), []byte("value")))
+		}()
+	}
+	assert.Eventually(t, func() bool {
+		db.queueMu.Lock()
+		defer db.queueMu.Unlock()
+		return len(db.writers) == writers
+	}, time.Second, time.Millisecond)
+	db.writeMu.Unlock()
+	wg.Wait()
+
+	assert.Equal(t, uint64(writers), db.lastSeq.Load())
+	for i := range writers {
+		val, found := db.Get(fmt.Appendf(nil, "key%02d", i))
+		assert.True(t, found)
+		assert.Equal(t, []byte("value"), val)
+	}
+
+	// The leader logs the whole queue as a single batch record
+	refPath := filepath.Join(dataDir, "reference.log")
+	ref, err := wal.NewWAL(refPath)
+	assert.NoError(t, err)
+	var entries []wal.Entry
+	for i := range writers {
+		entries = append(entries, wal.Entry{Kind: kind.Value, Key: fmt.Appendf(nil, "key%02d", i), Value: []byte("value")})
+	}
+	assert.NoError(t, ref.Append(1, entries, true))
+	ref.Close()
+
+	logged, _ := os.Stat(db.GetWAL().Path())
+	expected, _ := os.Stat(refPath)
+	assert.Equal(t, expected.Size(), logged.Size())
+}
+
+func TestGroupCommit_ReadsHideUnpublishedWrites(t *testing.T) {
+	dataDir := "test_group_commit_unpublished"
+	defer os.RemoveAll(dataDir)
+
+	db, err := Open(dataDir)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// A group is applied to the memtable before its sequence numbers are published
+	seq := db.lastSeq.Load() + 1
+	db.defaultCF.current.active.Add([]byte("a"), seq, kind.Value, []byte("1"))
+	db.defaultCF.current.active.Add([]byte("b"), seq+1, kind.Value, []byte("2"))
+	_, found := db.Get([]byte("a"))
+	assert.False(t, found, "Half of a group must not be visible")
+
+	db.lastSeq.Store(seq + 1)
+	val, found := db.Get([]byte("a"))
+	assert.True(t, found)
+	assert.Equal(t, []byte("1"), val)
+	val, found = db.GetCF(db.defaultCF, []byte("b"))
+	assert.True(t, found)
+	assert.Equal(t, []byte("2"), val)
+}
+
+func TestGroupCommit_ConcurrentWritersSurviveReopen(t *testing.T) {
+	dataDir := "test_group_commit_reopen"
+	defer os.RemoveAll(dataDir)
+
+	db, err := Open(dataDir)
+	assert.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for g := range 64 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 20 {
+				key := fmt.Appendf(nil, "g%02d-%02d", g, i)
+				if i%5 == 4 {
+					batch := NewWriteBatch()
+					batch.Put(key, []byte("batched"))
+					batch.Delete(fmt.Appendf(nil, "g%02d-%02d", g, i-1))
+					assert.NoError(t, db.Write(batch))
+				} else {
+					assert.NoError(t, db.Put(key, []byte("value")))
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, uint64(64*(16+2*4)), db.lastSeq.Load())
+
+	// Crash without a final flush, so everything comes back from the WAL
+	db.wal.Close()
+
+	db, err = Open(dataDir)
+	assert.NoError(t, err)
+	defer db.Close()
+	for g := range 64 {
+		for i := range 20 {
+			val, found := db.Get(fmt.Appendf(nil, "g%02d-%02d", g, i))
+			switch i % 5 {
+			case 3:
+				assert.False(t, found)
+			case 4:
+				assert.Equal(t, []byte("batched"), val)
+			default:
+				assert.Equal(t, []byte("value"), val)
+			}
+		}
+	}
+}
+
+func TestGroupCommit_FailsQueuedWritersAfterClose(t *testing.T) {
+	dataDir := "test_group_commit_closed"
+	defer os.RemoveAll(dataDir)
+
+	db, err := Open(dataDir)
+	assert.NoError(t, err)
+	assert.NoError(t, db.Close())
+
+	assert.Error(t, db.Put([]byte("k"), []byte("v")))
+	db.queueMu.Lock()
+	assert.Empty(t, db.writers)
+	db.queueMu.Unlock()
+}
+
+func BenchmarkPut_Parallel(b *testing.B) {
+	dataDir := "bench_group_commit"
+	defer os.RemoveAll(dataDir)
+
+	db, _ := Open(dataDir)
+	defer db.Close()
+
+	// 64 writers share each fsync
+	value := make([]byte, 100)
+	var n atomic.Int64
+	b.SetParallelism(64)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			db.Put(fmt.Appendf(nil, "key%09d", n.Add(1)), value)
+		}
+	})
+}
+
+func TestWriteOptions_DisableWAL(t *testing.T) {
+	dataDir := "test_write_disable_wal"
+	defer os.RemoveAll(dataDir)
+
+	db, err := Open(dataDir)
+	assert.NoError(t, err)
+
+	assert.NoError(t, db.Put([]byte("logged"), []byte("1")))
+	assert.NoError(t, db.PutWithOptions([]byte("unlogged"), []byte("2"), &WriteOptions{DisableWAL: true}))
+	batch := NewWriteBatch()
+	batch.Put([]byte("batched"), []byte("3"))
+	assert.NoError(t, db.WriteWithOptions(batch, &WriteOptions{DisableWAL: true}))
+	assert.NoError(t, db.DeleteWithOptions([]byte("logged"), &WriteOptions{DisableWAL: true}))
+
+	// Readers see every write right away
+	_, found := db.Get([]byte("logged"))
+	assert.False(t, found)
+	val, _ := db.Get([]byte("unlogged"))
+	assert.Equal(t, []byte("2"), val)
+
+	// Only the logged write survives a crash before the memtable is flushed
+	db.wal.Close()
+	db, err = Open(dataDir)
+	assert.NoError(t, err)
+
+	val, found = db.Get([]byte("logged"))
+	assert.True(t, found)
+	assert.Equal(t, []byte("1"), val)
+	_, found = db.Get([]byte("unlogged"))
+	assert.False(t, found)
+	_, found = db.Get([]byte("batched"))
+	assert.False(t, found)
+
+	// Once flushed, writes that skipped the WAL are durable
+	assert.NoError(t, db.PutWithOptions([]byte("unlogged"), []byte("2"), &WriteOptions{DisableWAL: true}))
+	assert.NoError(t, db.Close())
+	db, err = Open(dataDir)
+	assert.NoError(t, err)
+	defer db.Close()
+	val, _ = db.Get([]byte("unlogged"))
+	assert.Equal(t, []byte("2"), val)
+}
+
+func TestGroupCommit_SeparatesUnloggedWriters(t *testing.T) {
+	dataDir := "test_group_commit_disable_wal"
+	defer os.RemoveAll(dataDir)
+
+	db, err := Open(dataDir)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// Queue logged and unlogged writers alternately behind a stalled leader
+	db.writeMu.Lock()
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			wo := &WriteOptions{DisableWAL: i%2 == 1}
+			assert.NoError(t, db.PutWithOptions(fmt.Appendf(nil, "key%d", i), []byte("value"), wo))
+		}()
+		assert.Eventually(t, func() bool {
+			db.queueMu.Lock()
+			defer db.queueMu.Unlock()
+			return len(db.writers) == i+1
+		}, time.Second, time.Millisecond)
+	}
+	db.writeMu.Unlock()
+	wg.Wait()
+
+	for i := range 8 {
+		_, found := db.Get(fmt.Appendf(nil, "key%d", i))
+		assert.True(t, found)
+	}
+
+	recovered, err := db.GetWALContents()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]byte{
+		"key0": []byte("value"), "key2": []byte("value"), "key4": []byte("value"), "key6": []byte("value"),
+	}, recovered)
+}
+
+func TestSyncMode_PeriodicAndNever(t *testing.T) {
+	for _, mode := range []SyncMode{SyncPeriodic, SyncNever} {
+		t.Run(mode.String(), func(t *testing.T) {
+			dataDir := "test_sync_mode_" + mode.String()
+			defer os.RemoveAll(dataDir)
+
+			db, err := OpenWithOptions(dataDir, &Options{SyncMode: mode, SyncInterval: time.Millisecond})
+			assert.NoError(t, err)
+			for i := range 20 {
+				assert.NoError(t, db.Put(fmt.Appendf(nil, "key%02d", i), []byte("value")))
+			}
+			assert.NoError(t, db.PutWithOptions([]byte("synced"), []byte("value"), &WriteOptions{Sync: true}))
+			assert.NoError(t, db.SyncWAL())
+
+			db.wal.Close()
+			db, err = OpenWithOptions(dataDir, &Options{SyncMode: mode})
+			assert.NoError(t, err)
+			defer db.Close()
+
+			for i := range 20 {
+				_, found := db.Get(fmt.Appendf(nil, "key%02d", i))
+				assert.True(t, found)
+			}
+			_, found := db.Get([]byte("synced"))
+			assert.True(t, found)
+		})
+	}
+}
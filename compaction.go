@@ -3,9 +3,9 @@ package stratago
 import (
 	"fmt"
 	"os"
-	"path/filepath"
 	"time"
 
+	"github.com/thomazdavis/stratago/manifest"
 	"github.com/thomazdavis/stratago/sstable"
 )
 
@@ -15,7 +15,7 @@ const CompactionThreshold = 4
 func (db *StrataGo) compactionWorker() {
 	defer db.wg.Done()
 
-	ticker := time.NewTicker(10 * time.Second)
+	ticker := time.NewTicker(db.opts.CompactionInterval)
 	defer ticker.Stop()
 
 	for {
@@ -30,25 +30,100 @@ func (db *StrataGo) compactionWorker() {
 	}
 }
 
-// getTier returns a bucket index based on file size
-func getTier(size int64) int {
-	mb := int64(1024 * 1024)
-	if size < 10*mb {
-		return 0 // Tier 0: < 10MB
-	} else if size < 50*mb {
-		return 1 // Tier 1: 10MB - 50MB
-	} else if size < 250*mb {
-		return 2 // Tier 2: 50MB - 250MB
-	} else if size < 1024*mb {
-		return 3 // Tier 3: 250MB - 1GB
-	}
-	return 4 // Tier 4: > 1GB
+// getTier returns a bucket index based on file size and the ascending tier bounds
+func getTier(size int64, bounds []int64) int {
+	for tier, bound := range bounds {
+		if size < bound {
+			return tier
+		}
+	}
+	return len(bounds)
 }
 
-// RunCompaction executes a Size-Tiered compaction job
+// RunCompaction executes one compaction job in every column family, each with its configured strategy
 func (db *StrataGo) RunCompaction() error {
+	db.compactMu.Lock()
+	defer db.compactMu.Unlock()
+
+	for _, cf := range db.liveFamilies() {
+		var err error
+		if cf.opts.CompactionStrategy == Leveled {
+			err = db.runLeveledCompaction(cf)
+		} else {
+			err = db.runTieredCompaction(cf)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CompactRange flushes the memtables, then synchronously merges every table of every column
+// family that may hold keys in [start, end] into fully compacted output. Shadowed versions are discarded, and
+// tombstones with nothing older left to hide are dropped. A nil start or end leaves that
+// side of the range unbounded, so CompactRange(nil, nil) compacts the whole database.
+func (db *StrataGo) CompactRange(start, end []byte) error {
+	db.mu.RLock()
+	closed := db.closed
+	db.mu.RUnlock()
+	if closed {
+		return fmt.Errorf("database is closed")
+	}
+
+	if err := db.Flush(); err != nil {
+		return fmt.Errorf("flush before compaction failed: %w", err)
+	}
+
+	db.compactMu.Lock()
+	defer db.compactMu.Unlock()
+
+	for _, cf := range db.liveFamilies() {
+		var err error
+		if cf.opts.CompactionStrategy == Leveled {
+			err = db.compactLevelRange(cf, start, end)
+		} else {
+			err = db.compactTierRange(cf, start, end)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compactTierRange merges the level 0 tables of cf overlapping [start, end] into one table.
+// Tables in between are merged too, so the output keeps its place in the age order.
+func (db *StrataGo) compactTierRange(cf *ColumnFamily, start, end []byte) error {
+	v := cf.currentVersion()
+	defer v.unref()
+
+	first, last := -1, -1
+	for i, r := range v.levels[0] {
+		if overlapsRange(db.opts.Comparator, r, start, end) {
+			if first < 0 {
+				first = i
+			}
+			last = i
+		}
+	}
+	if first < 0 {
+		return nil
+	}
+
+	filesToCompact := append([]*sstable.Reader{}, v.levels[0][first:last+1]...)
+	fmt.Printf("Starting manual compaction (Merging %d files)...\n", len(filesToCompact))
+	return db.compactTables(cf, v, filesToCompact, first)
+}
+
+// runTieredCompaction executes a Size-Tiered compaction job in cf
+func (db *StrataGo) runTieredCompaction(cf *ColumnFamily) error {
+	// The pinned version keeps the inputs open until the merge is done
+	v := cf.currentVersion()
+	defer v.unref()
+
 	// Select the files
-	filesToCompact, startIndex, currentTier := db.selectFilesForCompaction()
+	filesToCompact, startIndex, currentTier := selectFilesForCompaction(v, cf.opts)
 
 	// If we didn't find any valid group, abort gracefully
 	if len(filesToCompact) == 0 {
@@ -56,83 +131,121 @@ func (db *StrataGo) RunCompaction() error {
 	}
 
 	fmt.Printf("Starting compaction on Tier %d (Merging %d files)...\n", currentTier, len(filesToCompact))
+	return db.compactTables(cf, v, filesToCompact, startIndex)
+}
+
+// compactTables merges a contiguous run of level 0 tables of v, the pinned version of cf,
+// starting at startIndex, into one table that takes their place
+func (db *StrataGo) compactTables(cf *ColumnFamily, v *version, filesToCompact []*sstable.Reader, startIndex int) error {
 
 	// Iterators (Newest to Oldest)
 	var iters []*sstable.Iterator
+	defer func() {
+		for _, it := range iters {
+			it.Close()
+		}
+	}()
+	var sources []sstable.Source
 	for i := len(filesToCompact) - 1; i >= 0; i-- {
 		iter, err := filesToCompact[i].NewIterator()
 		if err != nil {
 			return fmt.Errorf("failed to create iterator: %w", err)
 		}
 		iters = append(iters, iter)
+		sources = append(sources, iter)
 	}
 
-	var newestTimestamp int64
-	fmt.Sscanf(filepath.Base(filesToCompact[len(filesToCompact)-1].Path()), "data_%d.sst", &newestTimestamp)
-	mergedSSTName := fmt.Sprintf("data_%d.sst", newestTimestamp)
-	mergedSSTPath := filepath.Join(db.dataDir, mergedSSTName)
+	// The output gets a new file number, so no input is overwritten before the swap
+	_, mergedSSTPath := db.newTable()
 
-	builder, err := sstable.NewBuilder(mergedSSTPath)
+	builder, err := sstable.NewBuilderWithOptions(mergedSSTPath, cf.opts.sstableOptions())
 	if err != nil {
 		return err
 	}
 
-	if err := sstable.Merge(iters, builder); err != nil {
+	// Without older tables below the group, its tombstones have nothing left to hide
+	smallest, largest := keyRange(db.opts.Comparator, filesToCompact)
+	older := append([][]*sstable.Reader{v.levels[0][:startIndex]}, v.levels[1:]...)
+
+	var stats sstable.MergeStats
+	mergeOpts := sstable.MergeOptions{
+		Snapshots:        db.liveSnapshots(),
+		Bottommost:       isBottommost(db.opts.Comparator, older, smallest, largest),
+		CompactionFilter: cf.opts.CompactionFilter,
+		MergeOperator:    cf.opts.MergeOperator,
+		Comparator:       db.opts.Comparator,
+		Now:              db.now(),
+		Stats:            &stats,
+	}
+	if err := sstable.MergeWithOptions(sources, builder, mergeOpts); err != nil {
+		os.Remove(mergedSSTPath)
 		return fmt.Errorf("merge failed: %w", err)
 	}
 
-	newReader, err := sstable.NewReader(mergedSSTPath)
+	newReader, err := db.openTable(mergedSSTPath)
 	if err != nil {
+		os.Remove(mergedSSTPath)
 		return err
 	}
 
-	db.mu.Lock()
-	newReaders := make([]*sstable.Reader, 0, len(db.sstReaders)-CompactionThreshold+1)
-	newReaders = append(newReaders, db.sstReaders[:startIndex]...)
-	newReaders = append(newReaders, newReader)
-	newReaders = append(newReaders, db.sstReaders[startIndex+CompactionThreshold:]...)
-	db.sstReaders = newReaders
-	db.mu.Unlock()
-
-	// Delete the old files from disk
-	for _, r := range filesToCompact {
-		oldPath := r.Path()
-		r.Close()
-		os.Remove(oldPath)
+	edit := &manifest.VersionEdit{DeletedFiles: deletedTables(0, filesToCompact)}
+	var output []*sstable.Reader
+	if newReader.Smallest() != nil {
+		edit.NewFiles = []manifest.FileMeta{tableMeta(cf.id, 0, newReader)}
+		output = []*sstable.Reader{newReader}
+	} else {
+		// Every entry was garbage collected
+		newReader.Close()
+		os.Remove(mergedSSTPath)
 	}
-
-	for _, it := range iters {
-		it.Close()
+	if err := db.manifest.Apply(edit); err != nil {
+		for _, r := range output {
+			r.Close()
+		}
+		os.Remove(mergedSSTPath)
+		return fmt.Errorf("failed to record compaction: %w", err)
 	}
 
-	fmt.Println("Compaction complete!")
+	// Flushes only append to level 0, so the inputs are still at startIndex
+	db.mu.Lock()
+	next := cf.current.clone()
+	level0 := next.levels[0]
+	newReaders := make([]*sstable.Reader, 0, len(level0)-len(filesToCompact)+1)
+	newReaders = append(newReaders, level0[:startIndex]...)
+	newReaders = append(newReaders, output...)
+	newReaders = append(newReaders, level0[startIndex+len(filesToCompact):]...)
+	next.levels[0] = newReaders
+
+	// The old files are deleted once no reader or iterator uses them
+	next.tables.markObsolete(filesToCompact)
+	cf.installVersion(next)
+	db.mergeStats.Add(stats)
+	db.mu.Unlock()
+
 	return nil
 }
 
-// selectFilesForCompaction scans the current SSTables and finds a contiguous group
+// selectFilesForCompaction scans the SSTables of v and finds a contiguous group
 // of files in the same size tier. Returns the files, their starting index, and the tier
-func (db *StrataGo) selectFilesForCompaction() ([]*sstable.Reader, int, int) {
-	db.mu.RLock()
-	defer db.mu.RUnlock()
-
+func selectFilesForCompaction(v *version, opts *Options) ([]*sstable.Reader, int, int) {
 	var filesToCompact []*sstable.Reader
 	var startIndex int
 	currentTier := -1
 	var currentGroup []*sstable.Reader
 	var groupStartIndex int
 
-	for i, r := range db.sstReaders {
+	for i, r := range v.levels[0] {
 		stat, err := os.Stat(r.Path())
 		if err != nil {
 			continue
 		}
 
-		tier := getTier(stat.Size())
+		tier := getTier(stat.Size(), opts.TierBounds)
 
 		if tier == currentTier {
 			currentGroup = append(currentGroup, r)
 			// If we hit our threshold of contiguous files in the same tier
-			if len(currentGroup) == CompactionThreshold {
+			if len(currentGroup) == opts.CompactionThreshold {
 				filesToCompact = currentGroup
 				startIndex = groupStartIndex
 				return filesToCompact, startIndex, currentTier
@@ -1,11 +1,16 @@
 package stratago
 
 import (
+	"bytes"
+	"fmt"
 	"os"
 	"testing"
 	"time"
 
+	"github.com/stretchr/testify/assert"
+	"github.com/thomazdavis/stratago/kind"
 	"github.com/thomazdavis/stratago/memtable"
+	"github.com/thomazdavis/stratago/sstable"
 )
 
 func TestGetTier(t *testing.T) {
@@ -23,7 +28,7 @@ func TestGetTier(t *testing.T) {
 	}
 
 	for _, tc := range tests {
-		if tier := getTier(tc.size); tier != tc.expected {
+		if tier := getTier(tc.size, DefaultTierBounds); tier != tc.expected {
 			t.Errorf("For size %d, expected tier %d, got %d", tc.size, tc.expected, tier)
 		}
 	}
@@ -56,7 +61,7 @@ func TestRunCompaction_Success(t *testing.T) {
 	flushActiveMemtableToDisk(db)
 
 	db.mu.RLock()
-	readerCount := len(db.sstReaders)
+	readerCount := len(db.defaultCF.current.levels[0])
 	db.mu.RUnlock()
 	if readerCount != CompactionThreshold {
 		t.Fatalf("Expected %d readers before compaction, got %d", CompactionThreshold, readerCount)
@@ -70,7 +75,7 @@ func TestRunCompaction_Success(t *testing.T) {
 
 	// Verify Atomic Swap (Should now be exactly 1 reader)
 	db.mu.RLock()
-	newReaderCount := len(db.sstReaders)
+	newReaderCount := len(db.defaultCF.current.levels[0])
 	db.mu.RUnlock()
 
 	if newReaderCount != 1 {
@@ -91,8 +96,10 @@ func TestRunCompaction_Success(t *testing.T) {
 // Helper to simulate a flush for the test
 func flushActiveMemtableToDisk(db *StrataGo) {
 	db.mu.Lock()
-	db.immutableMemtable = db.activeMemtable
-	db.activeMemtable = memtable.NewSkipList()
+	next := db.defaultCF.current.clone()
+	next.immutable = next.active
+	next.active = memtable.NewSkipList()
+	db.defaultCF.installVersion(next)
 	db.mu.Unlock()
 
 	// Force the flush worker to process it immediately
@@ -100,3 +107,279 @@ func flushActiveMemtableToDisk(db *StrataGo) {
 	// Sleep briefly to let the goroutine finish writing, instead of chan
 	time.Sleep(50 * time.Millisecond)
 }
+
+func TestRunCompaction_Recompresses(t *testing.T) {
+	dbDir := "test_compaction_recompress"
+	defer os.RemoveAll(dbDir)
+
+	value := bytes.Repeat([]byte(`{"id":1,"status":"active","tags":["a","b"]}`), 10)
+	opts := &Options{CompactionInterval: time.Hour}
+
+	db, err := OpenWithOptions(dbDir, opts)
+	assert.NoError(t, err)
+	var uncompressed int64
+	for i := range CompactionThreshold {
+		for j := range 50 {
+			db.Put(fmt.Appendf(nil, "key%d-%02d", i, j), value)
+		}
+		assert.NoError(t, db.Flush())
+	}
+	for _, r := range db.defaultCF.current.levels[0] {
+		uncompressed += r.Size()
+	}
+	assert.NoError(t, db.Close())
+
+	// Compaction rewrites the tables with the codec the DB is now opened with
+	opts.Compression = sstable.DeflateCompression
+	db, err = OpenWithOptions(dbDir, opts)
+	assert.NoError(t, err)
+	defer db.Close()
+	assert.NoError(t, db.RunCompaction())
+
+	db.mu.RLock()
+	assert.Len(t, db.defaultCF.current.levels[0], 1)
+	compressed := db.defaultCF.current.levels[0][0].Size()
+	db.mu.RUnlock()
+	assert.Less(t, compressed*5, uncompressed)
+
+	val, found := db.Get([]byte("key3-49"))
+	assert.True(t, found)
+	assert.Equal(t, value, val)
+}
+
+// countTombstones returns the number of tombstones stored in the tables of the DB
+func countTombstones(t *testing.T, db *StrataGo) int {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	count := 0
+	for _, r := range db.defaultCF.current.readers() {
+		it, err := r.NewIterator()
+		assert.NoError(t, err)
+		for it.Next() {
+			if it.Kind() == kind.Delete {
+				count++
+			}
+		}
+		it.Close()
+	}
+	return count
+}
+
+func TestRunCompaction_DropsTombstones(t *testing.T) {
+	dbDir := "test_compaction_tombstones"
+	defer os.RemoveAll(dbDir)
+
+	db, err := OpenWithOptions(dbDir, &Options{CompactionInterval: time.Hour})
+	assert.NoError(t, err)
+	defer db.Close()
+
+	for i := range 10 {
+		db.Put(fmt.Appendf(nil, "key%d", i), []byte("value"))
+	}
+	assert.NoError(t, db.Flush())
+
+	// The snapshot still sees key0, so its tombstone must survive the first compaction
+	snap := db.NewSnapshot()
+	for i := range CompactionThreshold - 1 {
+		db.Delete(fmt.Appendf(nil, "key%d", i))
+		assert.NoError(t, db.Flush())
+	}
+	assert.NoError(t, db.RunCompaction())
+	assert.Equal(t, CompactionThreshold-1, countTombstones(t, db))
+	assert.Equal(t, 0, db.CompactionStats().TombstonesDropped)
+
+	val, found := snap.Get([]byte("key0"))
+	assert.True(t, found)
+	assert.Equal(t, []byte("value"), val)
+	snap.Release()
+
+	// With the snapshot gone, the merge of the oldest tables drops tombstones and the values they hid
+	for i := range CompactionThreshold - 1 {
+		db.Put(fmt.Appendf(nil, "other%d", i), []byte("value"))
+		assert.NoError(t, db.Flush())
+	}
+	assert.NoError(t, db.RunCompaction())
+	assert.Equal(t, 0, countTombstones(t, db))
+	assert.Equal(t, CompactionThreshold-1, db.CompactionStats().TombstonesDropped)
+
+	all := db.GetSSTableContents()
+	assert.Len(t, all, 1)
+	for _, contents := range all {
+		// 7 surviving keys and the 3 new ones
+		assert.Len(t, contents, 10)
+	}
+	_, found = db.Get([]byte("key0"))
+	assert.False(t, found)
+	val, found = db.Get([]byte("key9"))
+	assert.True(t, found)
+	assert.Equal(t, []byte("value"), val)
+}
+
+func TestRunCompaction_KeepsTombstonesAboveOlderTables(t *testing.T) {
+	dbDir := "test_compaction_tombstones_newer"
+	defer os.RemoveAll(dbDir)
+
+	db, err := OpenWithOptions(dbDir, &Options{CompactionInterval: time.Hour, TierBounds: []int64{200, 1 << 30}})
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// A big table in its own tier stays older than the compacted group
+	for i := range 20 {
+		db.Put(fmt.Appendf(nil, "key%02d", i), bytes.Repeat([]byte("v"), 20))
+	}
+	assert.NoError(t, db.Flush())
+	for i := range CompactionThreshold {
+		db.Delete(fmt.Appendf(nil, "key%02d", i))
+		assert.NoError(t, db.Flush())
+	}
+	assert.NoError(t, db.RunCompaction())
+
+	db.mu.RLock()
+	assert.Len(t, db.defaultCF.current.levels[0], 2)
+	db.mu.RUnlock()
+	assert.Equal(t, CompactionThreshold, countTombstones(t, db))
+
+	_, found := db.Get([]byte("key00"))
+	assert.False(t, found)
+}
+
+// openFiles counts the file descriptors of the test process
+func openFiles(t *testing.T) int {
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skip("open files cannot be counted on this platform")
+	}
+	return len(entries)
+}
+
+func TestRunCompaction_FailureClosesInputs(t *testing.T) {
+	dbDir := "test_compaction_failure"
+	defer os.RemoveAll(dbDir)
+
+	// Without a block cache every read goes to the file
+	db, err := OpenWithOptions(dbDir, &Options{CompactionInterval: time.Hour, BlockCacheSize: -1})
+	assert.NoError(t, err)
+	defer db.Close()
+
+	for i := range CompactionThreshold {
+		db.Put(fmt.Appendf(nil, "key%d", i), []byte("value"))
+		assert.NoError(t, db.Flush())
+	}
+
+	// Damage the data block of the newest table, so the merge fails after every input is open
+	v := db.defaultCF.currentVersion()
+	defer v.unref()
+	path := v.levels[0][CompactionThreshold-1].Path()
+	data, _ := os.ReadFile(path)
+	data[0] ^= 0xFF
+	assert.NoError(t, os.WriteFile(path, data, 0644))
+
+	before := openFiles(t)
+	assert.Error(t, db.RunCompaction())
+	assert.Error(t, db.CompactRange(nil, nil))
+	assert.Equal(t, before, openFiles(t), "Failed merges close their input iterators")
+	assert.Len(t, db.defaultCF.current.levels[0], CompactionThreshold)
+}
+
+func TestRunCompaction_AppliesCompactionFilter(t *testing.T) {
+	dbDir := "test_compaction_filter"
+	defer os.RemoveAll(dbDir)
+
+	var bottommost []bool
+	filter := sstable.CompactionFilterFunc(func(ctx sstable.FilterContext, key, value []byte) (sstable.FilterDecision, []byte) {
+		bottommost = append(bottommost, ctx.Bottommost)
+		if bytes.HasPrefix(key, []byte("tenant1/")) {
+			return sstable.FilterRemove, nil
+		}
+		return sstable.FilterChange, append([]byte("v2:"), value...)
+	})
+	db, err := OpenWithOptions(dbDir, &Options{CompactionInterval: time.Hour, CompactionFilter: filter})
+	assert.NoError(t, err)
+	defer db.Close()
+
+	for i := range CompactionThreshold {
+		db.Put(fmt.Appendf(nil, "tenant%d/key", i), []byte("value"))
+		assert.NoError(t, db.Flush())
+	}
+	assert.Empty(t, bottommost, "Flushes are not filtered")
+	assert.NoError(t, db.RunCompaction())
+	assert.Equal(t, []bool{true, true, true, true}, bottommost)
+
+	_, found := db.Get([]byte("tenant1/key"))
+	assert.False(t, found)
+	val, found := db.Get([]byte("tenant2/key"))
+	assert.True(t, found)
+	assert.Equal(t, []byte("v2:value"), val)
+	assert.Equal(t, 0, countTombstones(t, db), "Removed keys leave no tombstone in a bottommost compaction")
+	stats := db.CompactionStats()
+	assert.Equal(t, 1, stats.FilterRemoved)
+	assert.Equal(t, CompactionThreshold-1, stats.FilterChanged)
+}
+
+func TestCompactRange_SizeTiered(t *testing.T) {
+	dbDir := "test_compact_range_tiered"
+	defer os.RemoveAll(dbDir)
+
+	db, err := OpenWithOptions(dbDir, &Options{CompactionInterval: time.Hour})
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// Three tables: a-c, m-o, x-z, from oldest to newest
+	for _, prefix := range []string{"a", "m", "x"} {
+		for i := range 3 {
+			db.Put([]byte(prefix+fmt.Sprint(i)), []byte("value"))
+		}
+		assert.NoError(t, db.Flush())
+	}
+	db.Delete([]byte("a0"))
+	db.Delete([]byte("a1"))
+
+	// The memtable is flushed first, then the span from the a table to the newest one is merged
+	assert.NoError(t, db.CompactRange([]byte("a"), []byte("b")))
+	db.mu.RLock()
+	assert.Len(t, db.defaultCF.current.levels[0], 1)
+	db.mu.RUnlock()
+	assert.Equal(t, 0, countTombstones(t, db))
+
+	_, found := db.Get([]byte("a0"))
+	assert.False(t, found)
+	val, found := db.Get([]byte("x2"))
+	assert.True(t, found)
+	assert.Equal(t, []byte("value"), val)
+
+	// A range no table overlaps is a no-op
+	assert.NoError(t, db.CompactRange([]byte("q"), []byte("r")))
+	db.mu.RLock()
+	assert.Len(t, db.defaultCF.current.levels[0], 1)
+	db.mu.RUnlock()
+}
+
+func TestCompactRange_KeepsOlderTablesOutOfRange(t *testing.T) {
+	dbDir := "test_compact_range_partial"
+	defer os.RemoveAll(dbDir)
+
+	db, err := OpenWithOptions(dbDir, &Options{CompactionInterval: time.Hour})
+	assert.NoError(t, err)
+	defer db.Close()
+
+	db.Put([]byte("a"), []byte("old"))
+	assert.NoError(t, db.Flush())
+	db.Put([]byte("m"), []byte("value"))
+	db.Delete([]byte("a"))
+	assert.NoError(t, db.Flush())
+	db.Put([]byte("m"), []byte("newer"))
+	assert.NoError(t, db.Flush())
+
+	// The first table is older than the range, so the tombstone of a must stay
+	assert.NoError(t, db.CompactRange([]byte("m"), nil))
+	db.mu.RLock()
+	assert.Len(t, db.defaultCF.current.levels[0], 2)
+	db.mu.RUnlock()
+	assert.Equal(t, 1, countTombstones(t, db))
+
+	_, found := db.Get([]byte("a"))
+	assert.False(t, found)
+	val, _ := db.Get([]byte("m"))
+	assert.Equal(t, []byte("newer"), val)
+}
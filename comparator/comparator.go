@@ -0,0 +1,124 @@
+// Package comparator defines the key orderings shared by the memtable, the SSTables and the DB.
+package comparator
+
+import (
+	"bytes"
+	"errors"
+)
+
+// Comparator orders keys. The order is baked into every table written with it,
+// so a DB must always be reopened with a comparator of the same name.
+type Comparator interface {
+	// Compare returns a negative number, zero or a positive number as a sorts
+	// before, the same as or after b. Zero must mean the keys are byte-for-byte equal.
+	Compare(a, b []byte) int
+
+	// Name identifies the order. A comparator that orders keys differently must have another name.
+	Name() string
+}
+
+// ErrMismatch is returned when data written with one comparator is opened with another
+var ErrMismatch = errors.New("comparator mismatch")
+
+// Bytewise orders keys lexicographically by their bytes. It is the default.
+var Bytewise Comparator = bytewise{}
+
+type bytewise struct{}
+
+func (bytewise) Compare(a, b []byte) int {
+	return bytes.Compare(a, b)
+}
+
+func (bytewise) Name() string {
+	return "stratago.Bytewise"
+}
+
+// Reverse returns the opposite order of c
+func Reverse(c Comparator) Comparator {
+	return reverse{c}
+}
+
+type reverse struct {
+	c Comparator
+}
+
+func (r reverse) Compare(a, b []byte) int {
+	return r.c.Compare(b, a)
+}
+
+func (r reverse) Name() string {
+	return "stratago.Reverse(" + r.c.Name() + ")"
+}
+
+// timestampSize is the length of the big-endian timestamp that ends a ReverseTimestamp key
+const timestampSize = 8
+
+// ReverseTimestamp orders keys made of a series prefix followed by an 8-byte big-endian
+// timestamp: prefixes ascending, and the timestamps of one prefix newest first, so a scan
+// of a series starts at its latest point. Keys shorter than 8 bytes are all prefix.
+var ReverseTimestamp Comparator = reverseTimestamp{}
+
+type reverseTimestamp struct{}
+
+func (reverseTimestamp) Compare(a, b []byte) int {
+	prefixA, tsA := splitTimestamp(a)
+	prefixB, tsB := splitTimestamp(b)
+	if cmp := bytes.Compare(prefixA, prefixB); cmp != 0 {
+		return cmp
+	}
+	return bytes.Compare(tsB, tsA)
+}
+
+func (reverseTimestamp) Name() string {
+	return "stratago.ReverseTimestamp"
+}
+
+// splitTimestamp splits a key into its prefix and its timestamp, which is nil for short keys
+func splitTimestamp(key []byte) ([]byte, []byte) {
+	if len(key) < timestampSize {
+		return key, nil
+	}
+	return key[:len(key)-timestampSize], key[len(key)-timestampSize:]
+}
+
+// Numeric orders keys that start with a decimal number by its value, so "9" sorts
+// before "10". Keys with the same value are ordered by whatever follows the digits,
+// and then by their bytes, which only differ in leading zeros.
+var Numeric Comparator = numeric{}
+
+type numeric struct{}
+
+func (numeric) Compare(a, b []byte) int {
+	digitsA, restA := splitNumber(a)
+	digitsB, restB := splitNumber(b)
+
+	// Without leading zeros, a longer number is a larger one
+	valueA := bytes.TrimLeft(digitsA, "0")
+	valueB := bytes.TrimLeft(digitsB, "0")
+	if len(valueA) != len(valueB) {
+		if len(valueA) < len(valueB) {
+			return -1
+		}
+		return 1
+	}
+	if cmp := bytes.Compare(valueA, valueB); cmp != 0 {
+		return cmp
+	}
+	if cmp := bytes.Compare(restA, restB); cmp != 0 {
+		return cmp
+	}
+	return bytes.Compare(a, b)
+}
+
+func (numeric) Name() string {
+	return "stratago.Numeric"
+}
+
+// splitNumber splits a key into its leading decimal digits and the rest
+func splitNumber(key []byte) ([]byte, []byte) {
+	i := 0
+	for i < len(key) && key[i] >= '0' && key[i] <= '9' {
+		i++
+	}
+	return key[:i], key[i:]
+}
@@ -0,0 +1,52 @@
+package comparator
+
+import (
+	"encoding/binary"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// sorted returns keys in the order of c
+func sorted(c Comparator, keys ...string) []string {
+	res := append([]string{}, keys...)
+	sort.Slice(res, func(i, j int) bool { return c.Compare([]byte(res[i]), []byte(res[j])) < 0 })
+	return res
+}
+
+func TestBytewise(t *testing.T) {
+	assert.Equal(t, []string{"", "a", "ab", "b"}, sorted(Bytewise, "b", "ab", "", "a"))
+	assert.Equal(t, 0, Bytewise.Compare([]byte("a"), []byte("a")))
+}
+
+func TestReverse(t *testing.T) {
+	c := Reverse(Bytewise)
+	assert.Equal(t, []string{"b", "ab", "a", ""}, sorted(c, "b", "ab", "", "a"))
+	assert.Equal(t, "stratago.Reverse(stratago.Bytewise)", c.Name())
+}
+
+func TestReverseTimestamp(t *testing.T) {
+	key := func(series string, ts uint64) string {
+		return string(binary.BigEndian.AppendUint64([]byte(series), ts))
+	}
+	cpu1, cpu2, cpu3 := key("cpu", 1), key("cpu", 2), key("cpu", 300)
+	mem1, mem2 := key("mem", 1), key("mem", 2)
+
+	// Series ascending, each one newest first
+	assert.Equal(t, []string{cpu3, cpu2, cpu1, mem2, mem1}, sorted(ReverseTimestamp, mem1, cpu1, cpu3, mem2, cpu2))
+	assert.Equal(t, 0, ReverseTimestamp.Compare([]byte(cpu2), []byte(cpu2)))
+
+	// Keys too short for a timestamp are ordered as prefixes
+	assert.Equal(t, []string{"a", "b"}, sorted(ReverseTimestamp, "b", "a"))
+}
+
+func TestNumeric(t *testing.T) {
+	assert.Equal(t,
+		[]string{"", "x", "2", "09", "9", "10", "10a", "10b", "100"},
+		sorted(Numeric, "100", "10b", "9", "x", "10", "2", "", "10a", "09"))
+
+	// Only byte-equal keys compare equal
+	assert.Equal(t, 0, Numeric.Compare([]byte("42"), []byte("42")))
+	assert.NotEqual(t, 0, Numeric.Compare([]byte("9"), []byte("09")))
+}
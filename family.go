@@ -0,0 +1,242 @@
+package stratago
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"sort"
+
+	"github.com/thomazdavis/stratago/kind"
+	"github.com/thomazdavis/stratago/manifest"
+	"github.com/thomazdavis/stratago/memtable"
+	"github.com/thomazdavis/stratago/sstable"
+	"github.com/thomazdavis/stratago/wal"
+)
+
+// DefaultColumnFamilyName names the family that Put, Get, Delete and NewIterator use
+const DefaultColumnFamilyName = "default"
+
+// ColumnFamily is a handle on a keyspace of the DB. Every family has its own memtables,
+// tables and options, while all of them share the WAL and the sequence numbers, so a
+// batch spanning several families is applied atomically and snapshots cover them all.
+type ColumnFamily struct {
+	db        *StrataGo
+	id        uint32
+	name      string
+	opts      *Options
+	current   *version // Memtables and tables, replaced as a whole by flushes and compactions
+	logNumber uint64   // First WAL segment written since the active memtable was created
+	keepLog   uint64   // Oldest WAL segment the manifest still replays for the family
+	dropped   bool
+}
+
+// Name returns the name the family was created with
+func (cf *ColumnFamily) Name() string {
+	return cf.name
+}
+
+// newColumnFamily returns a family whose tables are levels and whose active memtable is mem
+func (db *StrataGo) newColumnFamily(id uint32, name string, opts *Options, mem *memtable.SkipList, levels [][]*sstable.Reader) *ColumnFamily {
+	cf := &ColumnFamily{db: db, id: id, name: name, opts: opts}
+	cf.installVersion(&version{active: mem, levels: levels, tables: newTableRefs()})
+	return cf
+}
+
+// DefaultColumnFamily returns the family the methods without a family argument use
+func (db *StrataGo) DefaultColumnFamily() *ColumnFamily {
+	return db.defaultCF
+}
+
+// ColumnFamily returns the live family with the given name
+func (db *StrataGo) ColumnFamily(name string) (*ColumnFamily, bool) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	for _, cf := range db.families {
+		if cf.name == name {
+			return cf, true
+		}
+	}
+	return nil, false
+}
+
+// liveFamilies returns every family that has not been dropped, in creation order
+func (db *StrataGo) liveFamilies() []*ColumnFamily {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	res := make([]*ColumnFamily, 0, len(db.families))
+	for _, cf := range db.families {
+		res = append(res, cf)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].id < res[j].id })
+	return res
+}
+
+// CreateColumnFamily adds an empty family. Its memtable and tables follow opts, with unset
+// fields taken from the defaults, or the DB's options if opts is nil. Options that belong to
+// the whole DB, such as the WAL, sync, block cache and comparator settings, are taken from the DB.
+// Open reopens the family with Options.ColumnFamilies[name], or the DB's options.
+func (db *StrataGo) CreateColumnFamily(name string, opts *Options) (*ColumnFamily, error) {
+	if name == "" {
+		return nil, fmt.Errorf("column family name must not be empty")
+	}
+	cfOpts := db.opts
+	if opts != nil {
+		cfOpts = opts.withDefaults()
+		if err := cfOpts.validate(); err != nil {
+			return nil, err
+		}
+		cfOpts.Comparator = db.opts.Comparator
+	}
+
+	// No segment switch can happen while the family is being recorded
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.closed {
+		return nil, fmt.Errorf("database is closed")
+	}
+	for _, cf := range db.families {
+		if cf.name == name {
+			return nil, fmt.Errorf("column family %q already exists", name)
+		}
+	}
+
+	// Writes of the new family can only land in the current segment or later ones
+	id := db.manifest.NewFamilyID()
+	logNumber, _ := parseLogFileName(filepath.Base(db.wal.Path()))
+	edit := &manifest.VersionEdit{
+		Families:     []manifest.FamilyMeta{{ID: id, Name: name, LogNumber: logNumber}},
+		NextFamilyID: id + 1,
+	}
+	if err := db.manifest.Apply(edit); err != nil {
+		return nil, fmt.Errorf("failed to record column family: %w", err)
+	}
+
+	cf := db.newColumnFamily(id, name, cfOpts, db.newMemtable(), make([][]*sstable.Reader, cfOpts.MaxLevels))
+	cf.logNumber, cf.keepLog = logNumber, logNumber
+	db.families[id] = cf
+	return cf, nil
+}
+
+// DropColumnFamily removes a family and all of its data. One manifest edit drops every
+// table of the family at once, whatever their number, and the files are deleted as soon
+// as no iterator reads them. The handle is unusable afterwards.
+func (db *StrataGo) DropColumnFamily(cf *ColumnFamily) error {
+	if cf == db.defaultCF {
+		return fmt.Errorf("cannot drop the default column family")
+	}
+
+	// No compaction may be rewriting the tables of the family, nor a write landing in it
+	db.compactMu.Lock()
+	defer db.compactMu.Unlock()
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if err := db.checkFamily(cf); err != nil {
+		return err
+	}
+	if err := db.manifest.Apply(&manifest.VersionEdit{DroppedFamilies: []uint32{cf.id}}); err != nil {
+		return fmt.Errorf("failed to drop column family: %w", err)
+	}
+
+	cf.dropped = true
+	delete(db.families, cf.id)
+	cf.current.tables.markObsolete(cf.current.readers())
+	cf.installVersion(&version{
+		active: db.newMemtable(),
+		levels: make([][]*sstable.Reader, len(cf.current.levels)),
+		tables: cf.current.tables,
+	})
+
+	// The family may have been the last to need the oldest segments
+	removeLogs(db.dataDir, db.minLogNumber())
+	return nil
+}
+
+// checkFamily fails if cf is not a live family of db. Callers must hold db.mu.
+func (db *StrataGo) checkFamily(cf *ColumnFamily) error {
+	if cf == nil || cf.db != db {
+		return fmt.Errorf("column family does not belong to this database")
+	}
+	if cf.dropped {
+		return fmt.Errorf("column family %q was dropped", cf.name)
+	}
+	return nil
+}
+
+// familyVersion returns the current version of cf with a reference the caller must
+// release, or an error if cf is not a live family of db
+func (db *StrataGo) familyVersion(cf *ColumnFamily) (*version, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if err := db.checkFamily(cf); err != nil {
+		return nil, err
+	}
+	cf.current.ref()
+	return cf.current, nil
+}
+
+// minLogNumber returns the oldest WAL segment a family may still need. Only log numbers
+// the manifest recorded count, as a flush still writing its table needs the segments
+// before the one it rotated to. Callers must hold db.mu.
+func (db *StrataGo) minLogNumber() uint64 {
+	res := uint64(math.MaxUint64)
+	for _, cf := range db.families {
+		res = min(res, cf.keepLog)
+	}
+	return res
+}
+
+// applyCF checks that cf is live, then applies entries to it
+func (db *StrataGo) applyCF(cf *ColumnFamily, entries []wal.Entry, wo *WriteOptions) error {
+	db.mu.RLock()
+	err := db.checkFamily(cf)
+	db.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	for i := range entries {
+		entries[i].Family = cf.id
+	}
+	return db.apply(entries, wo)
+}
+
+// PutCF writes a key-value pair to a column family
+func (db *StrataGo) PutCF(cf *ColumnFamily, key, value []byte) error {
+	return db.applyCF(cf, []wal.Entry{{Kind: kind.Value, Key: key, Value: value}}, nil)
+}
+
+// DeleteCF deletes a key from a column family
+func (db *StrataGo) DeleteCF(cf *ColumnFamily, key []byte) error {
+	return db.applyCF(cf, []wal.Entry{{Kind: kind.Delete, Key: key}}, nil)
+}
+
+// MergeCF merges an operand into a key of a column family with the family's MergeOperator
+func (db *StrataGo) MergeCF(cf *ColumnFamily, key, operand []byte) error {
+	if cf != nil && cf.opts.MergeOperator == nil {
+		return errNoMergeOperator
+	}
+	return db.applyCF(cf, []wal.Entry{{Kind: kind.Merge, Key: key, Value: operand}}, nil)
+}
+
+// GetCF returns the value of key in a column family. A nil, foreign or dropped family holds nothing.
+func (db *StrataGo) GetCF(cf *ColumnFamily, key []byte) ([]byte, bool) {
+	val, found, _ := db.GetCFWithOptions(cf, key, nil)
+	return val, found
+}
+
+// GetCFWithOptions returns the value of key in a column family, or the error that kept
+// it from being read. It fails if the family is not a live family of the DB.
+func (db *StrataGo) GetCFWithOptions(cf *ColumnFamily, key []byte, ro *ReadOptions) ([]byte, bool, error) {
+	return db.get(cf, key, db.readSequence(ro))
+}
+
+// NewIteratorCF returns an iterator over the keys of a column family in [lower, upper).
+// It fails if the family is not a live family of the DB.
+func (db *StrataGo) NewIteratorCF(cf *ColumnFamily, lower, upper []byte) (*Iterator, error) {
+	return db.newIterator(cf, lower, upper, db.lastSeq.Load())
+}
@@ -0,0 +1,286 @@
+package stratago
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func assertFamilyValue(t *testing.T, db *StrataGo, cf *ColumnFamily, key, expected string) {
+	t.Helper()
+	val, found := db.GetCF(cf, []byte(key))
+	assert.True(t, found, key)
+	assert.Equal(t, expected, string(val), key)
+}
+
+func TestColumnFamily_Isolation(t *testing.T) {
+	dataDir := "test_cf_isolation"
+	defer os.RemoveAll(dataDir)
+
+	db, err := Open(dataDir)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	logs, err := db.CreateColumnFamily("logs", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "logs", logs.Name())
+	_, err = db.CreateColumnFamily("logs", nil)
+	assert.Error(t, err, "Names are unique")
+	found, ok := db.ColumnFamily("logs")
+	assert.True(t, ok)
+	assert.Equal(t, logs, found)
+	found, ok = db.ColumnFamily(DefaultColumnFamilyName)
+	assert.True(t, ok)
+	assert.Equal(t, db.DefaultColumnFamily(), found)
+
+	// The same key lives independently in each family
+	assert.NoError(t, db.Put([]byte("k"), []byte("default")))
+	assert.NoError(t, db.PutCF(logs, []byte("k"), []byte("logs")))
+	assert.NoError(t, db.PutCF(logs, []byte("only-logs"), []byte("x")))
+	assertValue(t, db, "k", "default")
+	assertFamilyValue(t, db, logs, "k", "logs")
+	_, exists := db.Get([]byte("only-logs"))
+	assert.False(t, exists)
+
+	snap := db.NewSnapshot()
+	defer snap.Release()
+	assert.NoError(t, db.DeleteCF(logs, []byte("k")))
+	_, exists = db.GetCF(logs, []byte("k"))
+	assert.False(t, exists)
+	assertValue(t, db, "k", "default")
+	val, exists := snap.GetCF(logs, []byte("k"))
+	assert.True(t, exists, "Snapshots cover every family")
+	assert.Equal(t, "logs", string(val))
+
+	// Flushes and compactions keep the families apart as well
+	assert.NoError(t, db.CompactRange(nil, nil))
+	iter, err := db.NewIteratorCF(logs, nil, nil)
+	assert.NoError(t, err)
+	var keys []string
+	for iter.First(); iter.Valid(); iter.Next() {
+		keys = append(keys, string(iter.Key()))
+	}
+	assert.NoError(t, iter.Close())
+	assert.Equal(t, []string{"only-logs"}, keys)
+	assertValue(t, db, "k", "default")
+
+	iter, err = snap.NewIteratorCF(logs, nil, nil)
+	assert.NoError(t, err)
+	keys = nil
+	for iter.First(); iter.Valid(); iter.Next() {
+		keys = append(keys, string(iter.Key()))
+	}
+	assert.NoError(t, iter.Close())
+	assert.Equal(t, []string{"k", "only-logs"}, keys)
+}
+
+func TestColumnFamily_AtomicBatchRecovery(t *testing.T) {
+	dataDir := "test_cf_recovery"
+	defer os.RemoveAll(dataDir)
+
+	db, err := Open(dataDir)
+	assert.NoError(t, err)
+	logs, err := db.CreateColumnFamily("logs", nil)
+	assert.NoError(t, err)
+	_, err = db.CreateColumnFamily("idle", nil)
+	assert.NoError(t, err)
+
+	// One WAL record carries both families
+	batch := NewWriteBatch()
+	batch.Put([]byte("user"), []byte("alice"))
+	batch.PutCF(logs, []byte("event"), []byte("login"))
+	assert.NoError(t, db.Write(batch))
+
+	// Only logs is flushed, so default still needs the segment holding the batch
+	assert.NoError(t, db.flushFamily(logs))
+	assert.NoError(t, db.PutCF(logs, []byte("event2"), []byte("logout")))
+	segments, _ := listLogs(dataDir)
+	assert.Len(t, segments, 2)
+
+	// Crash before the other memtables are flushed
+	db.wal.Close()
+	db, err = Open(dataDir)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	logs, ok := db.ColumnFamily("logs")
+	assert.True(t, ok)
+	assertValue(t, db, "user", "alice")
+	assertFamilyValue(t, db, logs, "event", "login")
+	assertFamilyValue(t, db, logs, "event2", "logout")
+	assert.Equal(t, 1, logs.current.active.Size, "The flushed write is not replayed again")
+
+	// A family that is never written to does not keep old segments alive
+	assert.NoError(t, db.Flush())
+	segments, _ = listLogs(dataDir)
+	assert.Len(t, segments, 1)
+}
+
+func TestDropColumnFamily(t *testing.T) {
+	dataDir := "test_cf_drop"
+	defer os.RemoveAll(dataDir)
+
+	db, err := Open(dataDir)
+	assert.NoError(t, err)
+
+	tmp, err := db.CreateColumnFamily("tmp", nil)
+	assert.NoError(t, err)
+	for i := range 3 {
+		assert.NoError(t, db.PutCF(tmp, []byte{byte('a' + i)}, []byte("v")))
+		assert.NoError(t, db.Flush())
+	}
+	assert.NoError(t, db.PutCF(tmp, []byte("unflushed"), []byte("v")))
+	assert.NoError(t, db.Put([]byte("keep"), []byte("v")))
+
+	var paths []string
+	for _, r := range tmp.current.readers() {
+		paths = append(paths, r.Path())
+	}
+	assert.Len(t, paths, 3)
+
+	// An open iterator keeps the dropped tables until it is closed
+	iter, err := db.NewIteratorCF(tmp, nil, nil)
+	assert.NoError(t, err)
+
+	assert.Error(t, db.DropColumnFamily(db.DefaultColumnFamily()))
+	assert.NoError(t, db.DropColumnFamily(tmp))
+	assert.Error(t, db.DropColumnFamily(tmp))
+	assert.Error(t, db.PutCF(tmp, []byte("a"), []byte("v")))
+	_, ok := db.ColumnFamily("tmp")
+	assert.False(t, ok)
+	_, found := db.GetCF(tmp, []byte("a"))
+	assert.False(t, found)
+
+	iter.First()
+	assert.True(t, iter.Valid())
+	assert.NoError(t, iter.Close())
+	for _, path := range paths {
+		_, err := os.Stat(path)
+		assert.True(t, os.IsNotExist(err), filepath.Base(path))
+	}
+
+	// Writes of the dropped family left in the WAL are not replayed into a new one
+	db.wal.Close()
+	db, err = Open(dataDir)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	_, ok = db.ColumnFamily("tmp")
+	assert.False(t, ok)
+	tmp, err = db.CreateColumnFamily("tmp", nil)
+	assert.NoError(t, err)
+	_, found = db.GetCF(tmp, []byte("unflushed"))
+	assert.False(t, found)
+	assertValue(t, db, "keep", "v")
+}
+
+func TestColumnFamily_Options(t *testing.T) {
+	dataDir := "test_cf_options"
+	defer os.RemoveAll(dataDir)
+
+	db, err := Open(dataDir)
+	assert.NoError(t, err)
+
+	_, err = db.CreateColumnFamily("bad", &Options{LevelSizeRatio: 1})
+	assert.Error(t, err)
+
+	counters, err := db.CreateColumnFamily("counters", &Options{MergeOperator: counterOperator{}, CompactionStrategy: Leveled})
+	assert.NoError(t, err)
+	assert.NoError(t, db.MergeCF(counters, []byte("hits"), []byte("2")))
+	assert.NoError(t, db.MergeCF(counters, []byte("hits"), []byte("3")))
+	assertFamilyValue(t, db, counters, "hits", "5")
+
+	// The default family has no merge operator
+	assert.ErrorIs(t, db.Merge([]byte("hits"), []byte("1")), errNoMergeOperator)
+	batch := NewWriteBatch()
+	batch.MergeCF(counters, []byte("hits"), []byte("1"))
+	batch.Merge([]byte("hits"), []byte("1"))
+	assert.ErrorIs(t, db.Write(batch), errNoMergeOperator)
+	assert.NoError(t, db.Close())
+
+	// Families are reopened with the options given for them
+	opts := &Options{ColumnFamilies: map[string]*Options{"counters": {MergeOperator: counterOperator{}}}}
+	db, err = OpenWithOptions(dataDir, opts)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	counters, _ = db.ColumnFamily("counters")
+	assert.NoError(t, db.MergeCF(counters, []byte("hits"), []byte("1")))
+	assertFamilyValue(t, db, counters, "hits", "6")
+}
+
+func TestColumnFamily_UnrecordedFlushKeepsLogs(t *testing.T) {
+	dataDir := "test_cf_pending_flush"
+	defer os.RemoveAll(dataDir)
+
+	db, err := Open(dataDir)
+	assert.NoError(t, err)
+	a, err := db.CreateColumnFamily("a", nil)
+	assert.NoError(t, err)
+	b, err := db.CreateColumnFamily("b", nil)
+	assert.NoError(t, err)
+	assert.NoError(t, db.PutCF(a, []byte("pending"), []byte("v")))
+	assert.NoError(t, db.PutCF(b, []byte("flushed"), []byte("v")))
+
+	// A flush of a has rotated the WAL, but its table is not in the manifest yet
+	db.writeMu.Lock()
+	db.mu.Lock()
+	number, err := db.switchLog()
+	assert.NoError(t, err)
+	a.logNumber = number
+	next := a.current.clone()
+	next.immutable = next.active
+	next.active = db.newMemtable()
+	a.installVersion(next)
+	db.mu.Unlock()
+	db.writeMu.Unlock()
+
+	// Neither another flush nor a drop may delete the segment holding the write to a
+	assert.NoError(t, db.flushFamily(b))
+	assert.NoError(t, db.DropColumnFamily(b))
+
+	db.wal.Close()
+	db, err = Open(dataDir)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	a, _ = db.ColumnFamily("a")
+	assertFamilyValue(t, db, a, "pending", "v")
+}
+
+func TestColumnFamily_ReadsRejectInvalidHandles(t *testing.T) {
+	dataDir := "test_cf_invalid"
+	defer os.RemoveAll(dataDir)
+	otherDir := "test_cf_invalid_other"
+	defer os.RemoveAll(otherDir)
+
+	db, err := Open(dataDir)
+	assert.NoError(t, err)
+	defer db.Close()
+	other, err := Open(otherDir)
+	assert.NoError(t, err)
+	defer other.Close()
+
+	dropped, err := db.CreateColumnFamily("dropped", nil)
+	assert.NoError(t, err)
+	assert.NoError(t, db.PutCF(dropped, []byte("a"), []byte("v")))
+	assert.NoError(t, db.DropColumnFamily(dropped))
+	foreign := other.DefaultColumnFamily()
+
+	snap := db.NewSnapshot()
+	defer snap.Release()
+
+	for name, cf := range map[string]*ColumnFamily{"nil": nil, "dropped": dropped, "foreign": foreign} {
+		_, found := db.GetCF(cf, []byte("a"))
+		assert.False(t, found, name)
+		_, found = snap.GetCF(cf, []byte("a"))
+		assert.False(t, found, name)
+
+		_, err := db.NewIteratorCF(cf, nil, nil)
+		assert.Error(t, err, name)
+		_, err = snap.NewIteratorCF(cf, nil, nil)
+		assert.Error(t, err, name)
+	}
+}
@@ -1,73 +1,105 @@
 package stratago
 
 import (
+	"bytes"
 	"fmt"
 	"os"
-	"path/filepath"
-	"time"
 
+	"github.com/thomazdavis/stratago/manifest"
 	"github.com/thomazdavis/stratago/memtable"
 	"github.com/thomazdavis/stratago/sstable"
 	"github.com/thomazdavis/stratago/wal"
 )
 
+// Flush writes the memtables of every column family out to SSTables
 func (db *StrataGo) Flush() error {
-	db.mu.Lock()
-
-	if db.activeMemtable.Size == 0 && db.immutableMemtable == nil {
-		db.mu.Unlock()
-		return nil
+	for _, cf := range db.liveFamilies() {
+		if err := db.flushFamily(cf); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	// Only rotate if we don't have pending data
-	if db.immutableMemtable == nil {
-		// Rotate Memtable and WAL
-		db.immutableMemtable = db.activeMemtable
-		db.activeMemtable = memtable.NewSkipList()
-
-		oldWAL := db.wal
-		if err := oldWAL.Close(); err != nil {
-			db.activeMemtable = db.immutableMemtable
-			db.immutableMemtable = nil
-			db.mu.Unlock()
-			return err
+// flushFull flushes the families whose active memtable reached its threshold,
+// along with those left with an immutable memtable by a failed flush
+func (db *StrataGo) flushFull() error {
+	var full []*ColumnFamily
+	db.mu.RLock()
+	for _, cf := range db.families {
+		if cf.current.immutable != nil || cf.current.active.SizeBytes >= cf.opts.MemtableThreshold {
+			full = append(full, cf)
 		}
+	}
+	db.mu.RUnlock()
 
-		flushingWALPath := filepath.Join(db.dataDir, "wal.log.flushing")
-		if err := os.Rename(filepath.Join(db.dataDir, "wal.log"), flushingWALPath); err != nil {
-			db.activeMemtable = db.immutableMemtable
-			db.immutableMemtable = nil
-			db.mu.Unlock()
+	for _, cf := range full {
+		if err := db.flushFamily(cf); err != nil {
 			return err
 		}
+	}
+	return nil
+}
 
-		newWal, err := wal.NewWAL(filepath.Join(db.dataDir, "wal.log"))
+// flushFamily writes the memtables of one column family out to an SSTable
+func (db *StrataGo) flushFamily(cf *ColumnFamily) error {
+	// Block writers while the WAL is rotated so no entry lands in a closed log
+	db.writeMu.Lock()
+	db.mu.Lock()
+
+	if cf.dropped || (cf.current.active.Size == 0 && cf.current.immutable == nil) {
+		db.mu.Unlock()
+		db.writeMu.Unlock()
+		return nil
+	}
+
+	// Only rotate if we don't have pending data
+	logNumbers := map[uint32]uint64{cf.id: cf.logNumber}
+	if cf.current.immutable == nil {
+		// Rotate WAL, then Memtable. The segments before the new one hold
+		// exactly the writes of the memtable being flushed.
+		number, err := db.switchLog()
 		if err != nil {
-			os.Rename(flushingWALPath, filepath.Join(db.dataDir, "wal.log"))
-			db.activeMemtable = db.immutableMemtable
-			db.immutableMemtable = nil
 			db.mu.Unlock()
+			db.writeMu.Unlock()
 			return err
 		}
+		cf.logNumber = number
+		logNumbers[cf.id] = number
+
+		// Empty families have nothing left in the older segments either
+		for _, other := range db.families {
+			if other.current.active.Size == 0 && other.current.immutable == nil {
+				other.logNumber = number
+				logNumbers[other.id] = number
+			}
+		}
 
-		db.wal = newWal
+		next := cf.current.clone()
+		next.immutable = next.active
+		next.active = db.newMemtable()
+		cf.installVersion(next)
 	}
 
+	immutable := cf.current.immutable
 	db.mu.Unlock()
+	db.writeMu.Unlock()
 
-	sstName := fmt.Sprintf("data_%d.sst", time.Now().UnixNano())
-	sstPath := filepath.Join(db.dataDir, sstName)
+	_, sstPath := db.newTable()
 
-	builder, err := sstable.NewBuilder(sstPath)
+	builder, err := sstable.NewBuilderWithOptions(sstPath, cf.opts.sstableOptions())
 	if err != nil {
-		return db.recoverFromFlushFailure(err)
+		return db.recoverFromFlushFailure(cf, err)
 	}
 
-	if err := builder.Flush(db.immutableMemtable); err != nil {
-		return db.recoverFromFlushFailure(err)
+	// Only the versions still visible to a live snapshot are written out
+	source := []sstable.Source{memIterator{immutable.NewIterator()}}
+	mergeOpts := sstable.MergeOptions{Snapshots: db.liveSnapshots(), Comparator: db.opts.Comparator, Now: db.now()}
+	if err := sstable.MergeWithOptions(source, builder, mergeOpts); err != nil {
+		return db.recoverFromFlushFailure(cf, err)
 	}
 
-	reader, err := sstable.NewReader(sstPath)
+	reader, err := db.openTable(sstPath)
 	if err != nil {
 		return err
 	}
@@ -76,40 +108,101 @@ func (db *StrataGo) Flush() error {
 	if err != nil {
 		reader.Close()
 		os.Remove(sstPath)
-		return db.recoverFromFlushFailure(fmt.Errorf("SSTable verification failed: %w", err))
+		return db.recoverFromFlushFailure(cf, fmt.Errorf("SSTable verification failed: %w", err))
 	}
 
-	expectedSize := db.immutableMemtable.Size
+	expectedSize := countKeys(immutable)
 	if len(verifyData) != expectedSize {
 		reader.Close()
 		os.Remove(sstPath)
-		return db.recoverFromFlushFailure(fmt.Errorf("SSTable size mismatch: expected %d, got %d", expectedSize, len(verifyData)))
+		return db.recoverFromFlushFailure(cf, fmt.Errorf("SSTable size mismatch: expected %d, got %d", expectedSize, len(verifyData)))
+	}
+
+	// The table is live once the manifest says so, and the WAL segments it replaces are obsolete
+	edit := &manifest.VersionEdit{
+		NewFiles:     []manifest.FileMeta{tableMeta(cf.id, 0, reader)},
+		LastSequence: reader.MaxSequence(),
+	}
+	for id, logNumber := range logNumbers {
+		if id == 0 {
+			edit.LogNumber = logNumber
+			continue
+		}
+		if edit.FamilyLogNumbers == nil {
+			edit.FamilyLogNumbers = make(map[uint32]uint64)
+		}
+		edit.FamilyLogNumbers[id] = logNumber
+	}
+	if err := db.manifest.Apply(edit); err != nil {
+		reader.Close()
+		os.Remove(sstPath)
+		return db.recoverFromFlushFailure(cf, fmt.Errorf("failed to record flushed table: %w", err))
 	}
 
 	db.mu.Lock()
-	db.sstReaders = append(db.sstReaders, reader)
-	db.immutableMemtable = nil
+	for id, logNumber := range logNumbers {
+		if f, ok := db.families[id]; ok {
+			f.keepLog = max(f.keepLog, logNumber)
+		}
+	}
+	if cf.dropped {
+		// The manifest ignored the table, as the family was dropped while it was written
+		reader.Close()
+		os.Remove(sstPath)
+	} else {
+		next := cf.current.clone()
+		next.levels[0] = append(next.levels[0], reader)
+		next.immutable = nil
+		cf.installVersion(next)
+	}
+	minLogNumber := db.minLogNumber()
 	db.mu.Unlock()
 
-	os.Remove(filepath.Join(db.dataDir, "wal.log.flushing"))
+	removeLogs(db.dataDir, minLogNumber)
 	return nil
 }
 
-func (db *StrataGo) recoverFromFlushFailure(originalErr error) error {
-	db.mu.RLock()
-	iter := db.immutableMemtable.NewIterator()
-	db.mu.RUnlock()
+// countKeys returns the number of distinct keys in a memtable, ignoring older versions
+func countKeys(mem *memtable.SkipList) int {
+	count := 0
+	var lastKey []byte
+	iter := mem.NewIterator()
+	for iter.Next() {
+		if count == 0 || !bytes.Equal(lastKey, iter.Key()) {
+			count++
+			lastKey = iter.Key()
+		}
+	}
+	return count
+}
+
+func (db *StrataGo) recoverFromFlushFailure(cf *ColumnFamily, originalErr error) error {
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
 
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if cf.dropped {
+		return fmt.Errorf("flush failed: %w", originalErr)
+	}
+
+	iter := cf.current.immutable.NewIterator()
 	for iter.Next() {
-		if err := db.wal.WriteEntry(iter.Key(), iter.Value()); err != nil {
+		entry := wal.Entry{Family: cf.id, Kind: iter.Kind(), Key: iter.Key(), Value: iter.Value()}
+		if err := db.wal.Append(iter.Seq(), []wal.Entry{entry}, true); err != nil {
 			fmt.Printf("CRITICAL: Failed to persist to WAL: %v\n", err)
 		}
+
+		// Versions are tagged with their sequence numbers, so folding the
+		// older entries back in never shadows newer writes
+		cf.current.active.Add(iter.Key(), iter.Seq(), iter.Kind(), iter.Value())
 	}
 
 	// Clear immutable so we can flush again later
-	db.mu.Lock()
-	db.immutableMemtable = nil
-	db.mu.Unlock()
+	next := cf.current.clone()
+	next.immutable = nil
+	cf.installVersion(next)
 
 	return fmt.Errorf("flush failed, data preserved: %w", originalErr)
 }
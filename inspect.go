@@ -1,6 +1,14 @@
 package stratago
 
-import "github.com/thomazdavis/stratago/wal"
+import (
+	"errors"
+	"io/fs"
+	"path/filepath"
+
+	"github.com/thomazdavis/stratago/kind"
+	"github.com/thomazdavis/stratago/memtable"
+	"github.com/thomazdavis/stratago/wal"
+)
 
 func (db *StrataGo) GetWAL() *wal.WAL {
 	db.mu.RLock()
@@ -8,37 +16,89 @@ func (db *StrataGo) GetWAL() *wal.WAL {
 	return db.wal
 }
 
-func (db *StrataGo) GetActiveContents() map[string][]byte {
+// GetWALContents returns the newest logged version of every key of the default column
+// family across all live WAL segments, with a nil value for a deleted key
+func (db *StrataGo) GetWALContents() (map[string][]byte, error) {
 	db.mu.RLock()
-	defer db.mu.RUnlock()
+	minLog := db.manifest.MinLogNumber()
+	db.mu.RUnlock()
+
+	numbers, err := listLogs(db.dataDir)
+	if err != nil {
+		return nil, err
+	}
+
 	res := make(map[string][]byte)
-	iter := db.activeMemtable.NewIterator()
-	for iter.Next() {
-		res[string(iter.Key())] = iter.Value()
+	seqs := make(map[string]uint64)
+	for _, number := range numbers {
+		if number < minLog {
+			continue // Flushed, waiting to be deleted
+		}
+		r, err := wal.NewReader(filepath.Join(db.dataDir, logFileName(number)))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue // Deleted by a flush in the meantime
+		}
+		if err != nil {
+			return nil, err
+		}
+		for r.Next() {
+			key := string(r.Key())
+			if r.Family() != 0 || seqs[key] > r.Seq() {
+				continue
+			}
+			seqs[key] = r.Seq()
+			res[key] = r.Value()
+			if r.Kind() == kind.Delete {
+				res[key] = nil
+			}
+		}
+		err = r.Error()
+		r.Close()
+		if err != nil {
+			return nil, err
+		}
 	}
-	return res
+	return res, nil
+}
+
+func (db *StrataGo) GetActiveContents() map[string][]byte {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return newestVersions(db.defaultCF.current.active)
 }
 
 func (db *StrataGo) GetImmutableContents() map[string][]byte {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
-	if db.immutableMemtable == nil {
+	if db.defaultCF.current.immutable == nil {
 		return nil
 	}
-	res := make(map[string][]byte)
-	iter := db.immutableMemtable.NewIterator()
-	for iter.Next() {
-		res[string(iter.Key())] = iter.Value()
-	}
-	return res
+	return newestVersions(db.defaultCF.current.immutable)
 }
 
 func (db *StrataGo) GetSSTableContents() map[string]map[string][]byte {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
 	res := make(map[string]map[string][]byte)
-	for _, r := range db.sstReaders {
+	for _, r := range db.defaultCF.current.readers() {
 		res[r.Path()], _ = r.ReadAll()
 	}
 	return res
 }
+
+// newestVersions returns the newest version of every key in a memtable
+func newestVersions(mem *memtable.SkipList) map[string][]byte {
+	res := make(map[string][]byte)
+	iter := mem.NewIterator()
+	for iter.Next() {
+		if _, exists := res[string(iter.Key())]; !exists {
+			res[string(iter.Key())] = iter.Value()
+		}
+	}
+	return res
+}
+
+// WALRecoveryReport returns what Open discarded as damaged while replaying the WAL
+func (db *StrataGo) WALRecoveryReport() wal.RecoveryReport {
+	return db.recovery
+}
@@ -0,0 +1,278 @@
+package stratago
+
+import (
+	"bytes"
+	"container/heap"
+	"time"
+
+	"github.com/thomazdavis/stratago/comparator"
+	"github.com/thomazdavis/stratago/kind"
+	"github.com/thomazdavis/stratago/memtable"
+	"github.com/thomazdavis/stratago/sstable"
+)
+
+// internalIterator is implemented by every layer the DB iterator merges
+type internalIterator interface {
+	First() bool
+	SeekGE(key []byte) bool
+	Next() bool
+	Key() []byte
+	Seq() uint64
+	Kind() kind.Kind
+	Value() []byte
+	Error() error
+	Close() error
+}
+
+// memIterator adapts a memtable iterator, which never fails and holds no files
+type memIterator struct {
+	*memtable.Iterator
+}
+
+func (it memIterator) Error() error { return nil }
+func (it memIterator) Close() error { return nil }
+
+type iterItem struct {
+	iter     internalIterator
+	priority int // Lower is newer
+}
+
+type iterHeap struct {
+	items []*iterItem
+	cmp   comparator.Comparator
+}
+
+func (h *iterHeap) Len() int {
+	return len(h.items)
+}
+
+func (h *iterHeap) Less(i, j int) bool {
+	a, b := h.items[i], h.items[j]
+	cmp := h.cmp.Compare(a.iter.Key(), b.iter.Key())
+	if cmp == 0 {
+		// Newer versions first, then the newest layer wins
+		if si, sj := a.iter.Seq(), b.iter.Seq(); si != sj {
+			return si > sj
+		}
+		return a.priority < b.priority
+	}
+	return cmp < 0
+}
+
+func (h *iterHeap) Swap(i, j int) {
+	h.items[i], h.items[j] = h.items[j], h.items[i]
+}
+
+func (h *iterHeap) Push(x any) {
+	h.items = append(h.items, x.(*iterItem))
+}
+
+func (h *iterHeap) Pop() any {
+	n := len(h.items)
+	item := h.items[n-1]
+	h.items = h.items[:n-1]
+	return item
+}
+
+// Iterator walks the live keys of the whole LSM in sorted order.
+// It merges the active memtable, the immutable memtable and every SSTable,
+// returning only the newest version of each key visible at its sequence
+// number, with merge operands applied, and hiding tombstones and expired values.
+type Iterator struct {
+	version *version           // Pinned until Close so compactions cannot drop its tables
+	sources []internalIterator // Ordered from newest to oldest
+	heap    iterHeap
+	seq     uint64 // Versions newer than this are invisible
+	merge   sstable.MergeOperator
+	now     time.Time // Expiring values are checked against the creation time
+	lower   []byte    // Inclusive, nil means unbounded
+	upper   []byte    // Exclusive, nil means unbounded
+	key     []byte
+	value   []byte
+	valid   bool
+	err     error
+}
+
+// NewIterator returns an iterator over keys in [lower, upper).
+// A nil bound leaves that side of the range open. The iterator reads the
+// database as of its creation and does not see later writes.
+// The iterator is unpositioned; call First or SeekGE before reading.
+func (db *StrataGo) NewIterator(lower, upper []byte) (*Iterator, error) {
+	return db.newIterator(db.defaultCF, lower, upper, db.lastSeq.Load())
+}
+
+// newIterator returns an iterator over the keys of cf in [lower, upper) as of seq
+func (db *StrataGo) newIterator(cf *ColumnFamily, lower, upper []byte, seq uint64) (*Iterator, error) {
+	v, err := db.familyVersion(cf)
+	if err != nil {
+		return nil, err
+	}
+
+	var sources []internalIterator
+	sources = append(sources, memIterator{v.active.NewIterator()})
+	if v.immutable != nil {
+		sources = append(sources, memIterator{v.immutable.NewIterator()})
+	}
+
+	// Newest level 0 table first, then every deeper level
+	var readers []*sstable.Reader
+	for i := len(v.levels[0]) - 1; i >= 0; i-- {
+		readers = append(readers, v.levels[0][i])
+	}
+	for level := 1; level < len(v.levels); level++ {
+		readers = append(readers, v.levels[level]...)
+	}
+
+	for _, r := range readers {
+		it, err := r.NewIterator()
+		if err != nil {
+			for _, s := range sources {
+				s.Close()
+			}
+			v.unref()
+			return nil, err
+		}
+		sources = append(sources, it)
+	}
+
+	return &Iterator{
+		version: v,
+		sources: sources,
+		heap:    iterHeap{cmp: db.opts.Comparator},
+		seq:     seq,
+		merge:   cf.opts.MergeOperator,
+		now:     db.now(),
+		lower:   lower,
+		upper:   upper,
+	}, nil
+}
+
+// First positions the iterator at the smallest live key in range
+func (it *Iterator) First() bool {
+	if it.lower != nil {
+		return it.SeekGE(it.lower)
+	}
+	return it.reset(func(src internalIterator) bool { return src.First() })
+}
+
+// SeekGE positions the iterator at the first live key >= key
+func (it *Iterator) SeekGE(key []byte) bool {
+	if it.lower != nil && it.heap.cmp.Compare(key, it.lower) < 0 {
+		key = it.lower
+	}
+	return it.reset(func(src internalIterator) bool { return src.SeekGE(key) })
+}
+
+// Next advances to the following live key
+func (it *Iterator) Next() bool {
+	if !it.valid {
+		return false
+	}
+	return it.findNext()
+}
+
+// Valid reports whether the iterator is positioned at a key
+func (it *Iterator) Valid() bool {
+	return it.valid
+}
+
+func (it *Iterator) Key() []byte {
+	return it.key
+}
+
+func (it *Iterator) Value() []byte {
+	return it.value
+}
+
+// Error returns the first I/O error hit while iterating
+func (it *Iterator) Error() error {
+	return it.err
+}
+
+// Close releases the file handles held by the SSTable layers and the version they belong to
+func (it *Iterator) Close() error {
+	var firstErr error
+	for _, src := range it.sources {
+		if err := src.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if it.version != nil {
+		it.version.unref()
+		it.version = nil
+	}
+	it.sources = nil
+	it.heap.items = nil
+	it.valid = false
+	return firstErr
+}
+
+// reset repositions every layer with seek and rebuilds the heap
+func (it *Iterator) reset(seek func(internalIterator) bool) bool {
+	it.heap.items = it.heap.items[:0]
+	it.err = nil
+
+	for i, src := range it.sources {
+		if seek(src) {
+			it.heap.items = append(it.heap.items, &iterItem{iter: src, priority: i})
+		} else if err := src.Error(); err != nil {
+			it.err = err
+			it.valid = false
+			return false
+		}
+	}
+	heap.Init(&it.heap)
+
+	return it.findNext()
+}
+
+// findNext pops the smallest key off the heap, skipping invisible or shadowed versions and tombstones
+func (it *Iterator) findNext() bool {
+	for it.heap.Len() > 0 {
+		key := append([]byte{}, it.heap.items[0].iter.Key()...)
+
+		if it.upper != nil && it.heap.cmp.Compare(key, it.upper) >= 0 {
+			break
+		}
+
+		// Versions of the key pop newest first, the first visible one wins
+		// along with the merge operands above it
+		lookup := newKeyLookup(key, it.now)
+
+		for it.heap.Len() > 0 && bytes.Equal(it.heap.items[0].iter.Key(), key) {
+			item := it.heap.items[0]
+			if !lookup.done && item.iter.Seq() <= it.seq {
+				lookup.add(item.iter.Seq(), item.iter.Kind(), item.iter.Value())
+			}
+
+			if item.iter.Next() {
+				heap.Fix(&it.heap, 0)
+			} else {
+				heap.Pop(&it.heap)
+				if err := item.iter.Error(); err != nil {
+					it.err = err
+					it.valid = false
+					return false
+				}
+			}
+		}
+
+		value, found, err := lookup.result(it.merge)
+		if err != nil {
+			it.err = err
+			it.valid = false
+			return false
+		}
+		if found {
+			it.key = key
+			it.value = value
+			it.valid = true
+			return true
+		}
+	}
+
+	it.key = nil
+	it.value = nil
+	it.valid = false
+	return false
+}
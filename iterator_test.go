@@ -0,0 +1,114 @@
+package stratago
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func collectKeys(t *testing.T, it *Iterator) []string {
+	var keys []string
+	for ok := it.First(); ok; ok = it.Next() {
+		keys = append(keys, string(it.Key()))
+	}
+	assert.NoError(t, it.Error())
+	return keys
+}
+
+func TestIterator_MergesAllLayers(t *testing.T) {
+	dataDir := "test_iterator"
+	defer os.RemoveAll(dataDir)
+
+	db, err := Open(dataDir)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// Oldest SSTable
+	db.Put([]byte("a"), []byte("1"))
+	db.Put([]byte("c"), []byte("1"))
+	db.Put([]byte("e"), []byte("1"))
+	assert.NoError(t, db.Flush())
+
+	// Newer SSTable overrides c and deletes e
+	db.Put([]byte("c"), []byte("2"))
+	db.Delete([]byte("e"))
+	assert.NoError(t, db.Flush())
+
+	// Memtable overrides a and adds b, d
+	db.Put([]byte("a"), []byte("3"))
+	db.Put([]byte("b"), []byte("3"))
+	db.Put([]byte("d"), []byte("3"))
+
+	it, err := db.NewIterator(nil, nil)
+	assert.NoError(t, err)
+	defer it.Close()
+
+	expected := []struct{ key, val string }{
+		{"a", "3"}, {"b", "3"}, {"c", "2"}, {"d", "3"},
+	}
+
+	i := 0
+	for ok := it.First(); ok; ok = it.Next() {
+		assert.Less(t, i, len(expected))
+		assert.Equal(t, expected[i].key, string(it.Key()))
+		assert.Equal(t, expected[i].val, string(it.Value()))
+		i++
+	}
+	assert.Equal(t, len(expected), i)
+	assert.False(t, it.Valid())
+}
+
+func TestIterator_BoundsAndSeek(t *testing.T) {
+	dataDir := "test_iterator_bounds"
+	defer os.RemoveAll(dataDir)
+
+	db, err := Open(dataDir)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	for i := range 50 {
+		db.Put([]byte(fmt.Sprintf("key-%02d", i)), []byte("v"))
+		if i == 25 {
+			assert.NoError(t, db.Flush())
+		}
+	}
+
+	it, err := db.NewIterator([]byte("key-10"), []byte("key-20"))
+	assert.NoError(t, err)
+	defer it.Close()
+
+	keys := collectKeys(t, it)
+	assert.Equal(t, 10, len(keys))
+	assert.Equal(t, "key-10", keys[0])
+	assert.Equal(t, "key-19", keys[len(keys)-1])
+
+	// Seeking below the lower bound clamps to it
+	assert.True(t, it.SeekGE([]byte("a")))
+	assert.Equal(t, "key-10", string(it.Key()))
+
+	// Seeking between keys lands on the next one
+	assert.True(t, it.SeekGE([]byte("key-15x")))
+	assert.Equal(t, "key-16", string(it.Key()))
+
+	// Seeking past the upper bound is invalid
+	assert.False(t, it.SeekGE([]byte("key-30")))
+	assert.False(t, it.Valid())
+}
+
+func TestIterator_EmptyDB(t *testing.T) {
+	dataDir := "test_iterator_empty"
+	defer os.RemoveAll(dataDir)
+
+	db, err := Open(dataDir)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	it, err := db.NewIterator(nil, nil)
+	assert.NoError(t, err)
+	defer it.Close()
+
+	assert.False(t, it.First())
+	assert.False(t, it.Valid())
+}
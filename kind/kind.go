@@ -0,0 +1,56 @@
+// Package kind defines the entry kinds shared by the WAL, memtable and SSTable formats.
+package kind
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Kind tags what a stored entry means. It is persisted as a single byte.
+type Kind uint8
+
+const (
+	Delete   Kind = 0 // Tombstone, the key is deleted
+	Value    Kind = 1 // Regular value, possibly empty
+	Merge    Kind = 2 // Merge operand, combined with older versions by a merge operator
+	Expiring Kind = 3 // Value with an expiry time, read as a tombstone once it has passed
+)
+
+// Valid reports whether k is a kind this version understands
+func (k Kind) Valid() bool {
+	return k == Delete || k == Value || k == Merge || k == Expiring
+}
+
+func (k Kind) String() string {
+	switch k {
+	case Delete:
+		return "delete"
+	case Value:
+		return "value"
+	case Merge:
+		return "merge"
+	case Expiring:
+		return "expiring"
+	}
+	return fmt.Sprintf("kind(%d)", uint8(k))
+}
+
+// expirySize is the length of the expiry prefix of an Expiring value
+const expirySize = 8
+
+// EncodeExpiring returns the stored value of an Expiring entry:
+// [Expiry (8B, Unix nanoseconds)] [Value Bytes]
+func EncodeExpiring(expiry int64, value []byte) []byte {
+	buf := make([]byte, expirySize, expirySize+len(value))
+	binary.LittleEndian.PutUint64(buf, uint64(expiry))
+	return append(buf, value...)
+}
+
+// DecodeExpiring splits the stored value of an Expiring entry into its expiry and value.
+// A value too short to hold an expiry reads as already expired.
+func DecodeExpiring(stored []byte) (int64, []byte) {
+	if len(stored) < expirySize {
+		return 0, nil
+	}
+	return int64(binary.LittleEndian.Uint64(stored)), stored[expirySize:]
+}
@@ -0,0 +1,292 @@
+package stratago
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/thomazdavis/stratago/comparator"
+	"github.com/thomazdavis/stratago/manifest"
+	"github.com/thomazdavis/stratago/sstable"
+)
+
+// levelCompaction is one leveled compaction job: the tables picked from level
+// and the overlapping tables of the output level they are merged with
+type levelCompaction struct {
+	level      int
+	output     int               // Level the merged tables are written to, level+1
+	inputs     []*sstable.Reader // Newest first
+	overlap    []*sstable.Reader
+	bottommost bool // No deeper level overlaps the output
+}
+
+// runLeveledCompaction merges the level of cf most over its target into the next one
+func (db *StrataGo) runLeveledCompaction(cf *ColumnFamily) error {
+	// The pinned version keeps the inputs open until the merge is done
+	v := cf.currentVersion()
+	defer v.unref()
+
+	c := db.pickLevelCompaction(cf, v)
+	if c == nil {
+		return nil
+	}
+	return db.compactLevel(cf, c)
+}
+
+// compactLevel runs a leveled compaction job in cf. The caller pins a version holding its tables.
+func (db *StrataGo) compactLevel(cf *ColumnFamily, c *levelCompaction) error {
+	fmt.Printf("Starting compaction of L%d into L%d (Merging %d files)...\n", c.level, c.output, len(c.inputs)+len(c.overlap))
+
+	// Sources (Newest to Oldest), the output level is always older than level
+	var iters []*sstable.Iterator
+	defer func() {
+		for _, it := range iters {
+			it.Close()
+		}
+	}()
+	var sources []sstable.Source
+	for _, r := range append(append([]*sstable.Reader{}, c.inputs...), c.overlap...) {
+		iter, err := r.NewIterator()
+		if err != nil {
+			return fmt.Errorf("failed to create iterator: %w", err)
+		}
+		iters = append(iters, iter)
+		sources = append(sources, iter)
+	}
+
+	var outputs []string
+	nextBuilder := func() (*sstable.Builder, error) {
+		_, path := db.newTable()
+		b, err := sstable.NewBuilderWithOptions(path, cf.opts.sstableOptions())
+		if err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, b.Path())
+		return b, nil
+	}
+	removeOutputs := func() {
+		for _, path := range outputs {
+			os.Remove(path)
+		}
+	}
+
+	builder, err := nextBuilder()
+	if err != nil {
+		return err
+	}
+	var stats sstable.MergeStats
+	mergeOpts := sstable.MergeOptions{
+		Snapshots:        db.liveSnapshots(),
+		TargetFileSize:   cf.opts.TargetFileSize,
+		NextBuilder:      nextBuilder,
+		Bottommost:       c.bottommost,
+		CompactionFilter: cf.opts.CompactionFilter,
+		MergeOperator:    cf.opts.MergeOperator,
+		Comparator:       db.opts.Comparator,
+		Now:              db.now(),
+		OutputLevel:      c.output,
+		Stats:            &stats,
+	}
+	if err := sstable.MergeWithOptions(sources, builder, mergeOpts); err != nil {
+		removeOutputs()
+		return fmt.Errorf("merge failed: %w", err)
+	}
+
+	var newReaders []*sstable.Reader
+	for _, path := range outputs {
+		r, err := db.openTable(path)
+		if err != nil {
+			for _, nr := range newReaders {
+				nr.Close()
+			}
+			removeOutputs()
+			return err
+		}
+		if r.Smallest() == nil {
+			// Nothing survived the merge into this table
+			r.Close()
+			os.Remove(path)
+			continue
+		}
+		newReaders = append(newReaders, r)
+	}
+
+	edit := &manifest.VersionEdit{
+		DeletedFiles: append(deletedTables(c.level, c.inputs), deletedTables(c.output, c.overlap)...),
+	}
+	for _, r := range newReaders {
+		edit.NewFiles = append(edit.NewFiles, tableMeta(cf.id, c.output, r))
+	}
+	if err := db.manifest.Apply(edit); err != nil {
+		for _, r := range newReaders {
+			r.Close()
+		}
+		removeOutputs()
+		return fmt.Errorf("failed to record compaction: %w", err)
+	}
+
+	db.mu.Lock()
+	next := cf.current.clone()
+	next.levels[c.level] = without(next.levels[c.level], c.inputs)
+	output := append(without(next.levels[c.output], c.overlap), newReaders...)
+	sortByKey(db.opts.Comparator, output)
+	next.levels[c.output] = output
+
+	// The old files are deleted once no reader or iterator uses them
+	next.tables.markObsolete(append(c.inputs, c.overlap...))
+	cf.installVersion(next)
+	db.mergeStats.Add(stats)
+	db.mu.Unlock()
+
+	return nil
+}
+
+// pickLevelCompaction scores every level of v, a version of cf, against its target and
+// returns a job for the highest scoring one, or nil if no level needs compaction.
+// Level 0 is scored by table count, deeper levels by total size.
+func (db *StrataGo) pickLevelCompaction(cf *ColumnFamily, v *version) *levelCompaction {
+	cmp := db.opts.Comparator
+	best, bestScore := -1, 1.0
+	target := float64(cf.opts.BaseLevelSize)
+	for level := 0; level < len(v.levels)-1; level++ {
+		var score float64
+		if level == 0 {
+			score = float64(len(v.levels[0])) / float64(cf.opts.L0CompactionTrigger)
+		} else {
+			score = float64(levelSize(v.levels[level])) / target
+			target *= float64(cf.opts.LevelSizeRatio)
+		}
+		if score >= bestScore {
+			best, bestScore = level, score
+		}
+	}
+	if best < 0 {
+		return nil
+	}
+
+	c := &levelCompaction{level: best, output: best + 1}
+	if best == 0 {
+		// Level 0 tables overlap each other, so all of them move down together
+		for i := len(v.levels[0]) - 1; i >= 0; i-- {
+			c.inputs = append(c.inputs, v.levels[0][i])
+		}
+	} else {
+		// Push down the table overlapping the fewest bytes below it
+		var bestCost int64 = -1
+		for _, r := range v.levels[best] {
+			cost := levelSize(overlapping(cmp, v.levels[best+1], r.Smallest(), r.Largest()))
+			if bestCost < 0 || cost < bestCost {
+				c.inputs, bestCost = []*sstable.Reader{r}, cost
+			}
+		}
+	}
+
+	smallest, largest := keyRange(cmp, c.inputs)
+	c.overlap = overlapping(cmp, v.levels[best+1], smallest, largest)
+
+	// Tombstones can be dropped if nothing below the output level may hold their keys
+	smallest, largest = keyRange(cmp, append(append([]*sstable.Reader{}, c.inputs...), c.overlap...))
+	c.bottommost = isBottommost(cmp, v.levels[best+2:], smallest, largest)
+	return c
+}
+
+// levelSize returns the total file size of a set of tables
+func levelSize(readers []*sstable.Reader) int64 {
+	var size int64
+	for _, r := range readers {
+		size += r.Size()
+	}
+	return size
+}
+
+// keyRange returns the smallest and largest keys covered by a set of tables
+func keyRange(cmp comparator.Comparator, readers []*sstable.Reader) ([]byte, []byte) {
+	var smallest, largest []byte
+	for i, r := range readers {
+		if i == 0 || cmp.Compare(r.Smallest(), smallest) < 0 {
+			smallest = r.Smallest()
+		}
+		if i == 0 || cmp.Compare(r.Largest(), largest) > 0 {
+			largest = r.Largest()
+		}
+	}
+	return smallest, largest
+}
+
+// without returns the tables of level that are not in drop, preserving order
+func without(level, drop []*sstable.Reader) []*sstable.Reader {
+	res := make([]*sstable.Reader, 0, len(level))
+	for _, r := range level {
+		keep := true
+		for _, d := range drop {
+			if r == d {
+				keep = false
+				break
+			}
+		}
+		if keep {
+			res = append(res, r)
+		}
+	}
+	return res
+}
+
+// compactLevelRange pushes the tables of cf overlapping [start, end] down one level at a
+// time until they reach the deepest level holding the range, whose tables in the range
+// are rewritten along with them
+func (db *StrataGo) compactLevelRange(cf *ColumnFamily, start, end []byte) error {
+	v := cf.currentVersion()
+	bottom := 1
+	for level := range v.levels {
+		if len(overlappingRange(db.opts.Comparator, v.levels[level], start, end)) > 0 {
+			bottom = max(bottom, level)
+		}
+	}
+	v.unref()
+
+	for level := 0; level < bottom; level++ {
+		if err := db.compactLevelRangeStep(cf, level, bottom, start, end); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compactLevelRangeStep merges the tables of level overlapping [start, end] into level+1.
+// The step into bottom also takes every table of bottom in the range.
+func (db *StrataGo) compactLevelRangeStep(cf *ColumnFamily, level, bottom int, start, end []byte) error {
+	v := cf.currentVersion()
+	defer v.unref()
+
+	cmp := db.opts.Comparator
+	c := &levelCompaction{level: level, output: level + 1}
+	inputs := overlappingRange(cmp, v.levels[level], start, end)
+	if level == 0 && len(inputs) > 0 {
+		// Level 0 tables overlap each other, so all of them move down together
+		inputs = v.levels[0]
+	}
+	for i := len(inputs) - 1; i >= 0; i-- {
+		c.inputs = append(c.inputs, inputs[i])
+	}
+
+	// The output must not overlap the tables left in its level, so take
+	// everything between the edges of the inputs and of the range
+	lo, hi := keyRange(cmp, c.inputs)
+	if c.output == bottom {
+		if len(c.inputs) == 0 || start == nil || cmp.Compare(start, lo) < 0 {
+			lo = start
+		}
+		if len(c.inputs) == 0 || end == nil || cmp.Compare(end, hi) > 0 {
+			hi = end
+		}
+	} else if len(c.inputs) == 0 {
+		return nil
+	}
+	c.overlap = overlappingRange(cmp, v.levels[c.output], lo, hi)
+	if len(c.inputs)+len(c.overlap) == 0 {
+		return nil
+	}
+
+	smallest, largest := keyRange(cmp, append(append([]*sstable.Reader{}, c.inputs...), c.overlap...))
+	c.bottommost = isBottommost(cmp, v.levels[c.output+1:], smallest, largest)
+	return db.compactLevel(cf, c)
+}
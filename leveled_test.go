@@ -0,0 +1,159 @@
+package stratago
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func leveledTestOptions() *Options {
+	return &Options{
+		CompactionStrategy:  Leveled,
+		CompactionInterval:  time.Hour, // Compactions are run by hand
+		L0CompactionTrigger: 2,
+		BaseLevelSize:       4 * 1024,
+		LevelSizeRatio:      2,
+		TargetFileSize:      1024,
+		MaxLevels:           4,
+	}
+}
+
+// assertLevelsSorted checks that every level >= 1 holds sorted, non-overlapping tables
+func assertLevelsSorted(t *testing.T, db *StrataGo) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	for level := 1; level < len(db.defaultCF.current.levels); level++ {
+		tables := db.defaultCF.current.levels[level]
+		for i := 1; i < len(tables); i++ {
+			assert.Negative(t, bytes.Compare(tables[i-1].Largest(), tables[i].Smallest()),
+				"L%d tables %d and %d overlap", level, i-1, i)
+		}
+	}
+}
+
+func TestLeveledCompaction_PushesDownLevels(t *testing.T) {
+	dataDir := "test_leveled_compaction"
+	defer os.RemoveAll(dataDir)
+
+	db, err := OpenWithOptions(dataDir, leveledTestOptions())
+	assert.NoError(t, err)
+
+	value := bytes.Repeat([]byte("v"), 100)
+	expected := make(map[string]string)
+	for round := range 8 {
+		for i := range 40 {
+			key := fmt.Sprintf("key%03d", (i*7+round)%120)
+			val := fmt.Sprintf("%d-%s", round, value)
+			db.Put([]byte(key), []byte(val))
+			expected[key] = val
+		}
+		deleted := fmt.Sprintf("key%03d", round)
+		db.Delete([]byte(deleted))
+		delete(expected, deleted)
+		assert.NoError(t, db.Flush())
+
+		// Compact until no level is over its target
+		for range 10 {
+			assert.NoError(t, db.RunCompaction())
+		}
+		assertLevelsSorted(t, db)
+	}
+
+	db.mu.RLock()
+	l0, deeper := len(db.defaultCF.current.levels[0]), 0
+	for _, tables := range db.defaultCF.current.levels[1:] {
+		deeper += len(tables)
+	}
+	db.mu.RUnlock()
+	assert.Less(t, l0, 2, "Level 0 should be drained below its trigger")
+	assert.Greater(t, deeper, 1, "Output should be split across several tables")
+
+	check := func(db *StrataGo) {
+		for i := range 120 {
+			key := fmt.Sprintf("key%03d", i)
+			val, found := db.Get([]byte(key))
+			want, exists := expected[key]
+			assert.Equal(t, exists, found, "Get(%s)", key)
+			assert.Equal(t, want, string(val), "Get(%s)", key)
+		}
+
+		it, err := db.NewIterator(nil, nil)
+		assert.NoError(t, err)
+		defer it.Close()
+		assert.Len(t, collectKeys(t, it), len(expected))
+	}
+	check(db)
+	assert.NoError(t, db.Close())
+
+	// Levels are restored from the manifest
+	db, err = OpenWithOptions(dataDir, leveledTestOptions())
+	assert.NoError(t, err)
+	defer db.Close()
+	assertLevelsSorted(t, db)
+	check(db)
+}
+
+func TestCompactRange_Leveled(t *testing.T) {
+	dataDir := "test_compact_range_leveled"
+	defer os.RemoveAll(dataDir)
+
+	db, err := OpenWithOptions(dataDir, leveledTestOptions())
+	assert.NoError(t, err)
+	defer db.Close()
+
+	value := bytes.Repeat([]byte("v"), 100)
+	for round := range 6 {
+		for i := range 40 {
+			db.Put(fmt.Appendf(nil, "key%03d", i*6+round), value)
+		}
+		assert.NoError(t, db.Flush())
+		for {
+			db.mu.RLock()
+			c := db.pickLevelCompaction(db.defaultCF, db.defaultCF.current)
+			db.mu.RUnlock()
+			if c == nil {
+				break
+			}
+			assert.NoError(t, db.RunCompaction())
+		}
+	}
+
+	// Bulk delete a range, then compact it away
+	for i := 50; i < 100; i++ {
+		db.Delete(fmt.Appendf(nil, "key%03d", i))
+	}
+	assert.NoError(t, db.CompactRange([]byte("key050"), []byte("key099")))
+	assertLevelsSorted(t, db)
+	assert.Equal(t, 0, countTombstones(t, db))
+
+	db.mu.RLock()
+	assert.Empty(t, db.defaultCF.current.levels[0])
+	db.mu.RUnlock()
+
+	it, err := db.NewIterator(nil, nil)
+	assert.NoError(t, err)
+	defer it.Close()
+	count := 0
+	for ok := it.First(); ok; ok = it.Next() {
+		count++
+	}
+	assert.Equal(t, 240-50, count)
+
+	// Compacting everything leaves the whole key space in the bottom level
+	assert.NoError(t, db.CompactRange(nil, nil))
+	assertLevelsSorted(t, db)
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	bottom := len(db.defaultCF.current.levels) - 1
+	for bottom > 0 && len(db.defaultCF.current.levels[bottom]) == 0 {
+		bottom--
+	}
+	for level := range bottom {
+		assert.Empty(t, db.defaultCF.current.levels[level], "L%d should be empty", level)
+	}
+}
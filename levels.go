@@ -0,0 +1,80 @@
+package stratago
+
+import (
+	"sort"
+
+	"github.com/thomazdavis/stratago/comparator"
+	"github.com/thomazdavis/stratago/sstable"
+)
+
+// sortByKey orders the tables of a level >= 1 by their smallest key
+func sortByKey(cmp comparator.Comparator, readers []*sstable.Reader) {
+	sort.Slice(readers, func(i, j int) bool {
+		return cmp.Compare(readers[i].Smallest(), readers[j].Smallest()) < 0
+	})
+}
+
+// sortBySequence orders level 0 tables oldest first. A compaction output takes
+// the place of its inputs, so file numbers alone do not give the age of a table.
+func sortBySequence(readers []*sstable.Reader) {
+	sort.SliceStable(readers, func(i, j int) bool {
+		if readers[i].MaxSequence() != readers[j].MaxSequence() {
+			return readers[i].MaxSequence() < readers[j].MaxSequence()
+		}
+		return tableNumber(readers[i]) < tableNumber(readers[j])
+	})
+}
+
+// findTable returns the table of a sorted, non-overlapping level that may hold key
+func findTable(cmp comparator.Comparator, readers []*sstable.Reader, key []byte) *sstable.Reader {
+	i := sort.Search(len(readers), func(i int) bool {
+		return cmp.Compare(readers[i].Largest(), key) >= 0
+	})
+	if i == len(readers) || cmp.Compare(readers[i].Smallest(), key) > 0 {
+		return nil
+	}
+	return readers[i]
+}
+
+// overlapping returns the tables whose key range intersects [smallest, largest]
+func overlapping(cmp comparator.Comparator, readers []*sstable.Reader, smallest, largest []byte) []*sstable.Reader {
+	var res []*sstable.Reader
+	for _, r := range readers {
+		if cmp.Compare(r.Largest(), smallest) < 0 || cmp.Compare(r.Smallest(), largest) > 0 {
+			continue
+		}
+		res = append(res, r)
+	}
+	return res
+}
+
+// isBottommost reports whether none of the older tables may hold a key in [smallest, largest],
+// so a merge covering that range writes the oldest data there is
+func isBottommost(cmp comparator.Comparator, older [][]*sstable.Reader, smallest, largest []byte) bool {
+	for _, level := range older {
+		if len(overlapping(cmp, level, smallest, largest)) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// overlapsRange reports whether a table may hold keys in [start, end].
+// A nil start or end leaves that side of the range unbounded.
+func overlapsRange(cmp comparator.Comparator, r *sstable.Reader, start, end []byte) bool {
+	if start != nil && cmp.Compare(r.Largest(), start) < 0 {
+		return false
+	}
+	return end == nil || cmp.Compare(r.Smallest(), end) <= 0
+}
+
+// overlappingRange returns the tables that may hold keys in [start, end], where nil bounds are open
+func overlappingRange(cmp comparator.Comparator, readers []*sstable.Reader, start, end []byte) []*sstable.Reader {
+	var res []*sstable.Reader
+	for _, r := range readers {
+		if overlapsRange(cmp, r, start, end) {
+			res = append(res, r)
+		}
+	}
+	return res
+}
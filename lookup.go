@@ -0,0 +1,69 @@
+package stratago
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/thomazdavis/stratago/kind"
+	"github.com/thomazdavis/stratago/sstable"
+)
+
+var errNoMergeOperator = errors.New("no MergeOperator configured")
+
+// keyLookup collects the versions of one key, newest first, down to the
+// first value or tombstone, and resolves them into the value of the key
+type keyLookup struct {
+	key      []byte
+	now      int64    // Unix nanoseconds, expiring values older than this read as tombstones
+	operands [][]byte // Merge operands, newest first
+	base     []byte   // The value below the operands, if any
+	exists   bool     // base holds a value rather than a tombstone or nothing
+	done     bool     // A value or tombstone ended the lookup
+}
+
+// newKeyLookup starts a lookup of key that judges expiry at now
+func newKeyLookup(key []byte, now time.Time) keyLookup {
+	return keyLookup{key: key, now: now.UnixNano()}
+}
+
+// add takes the next older version of the key and reports whether even older ones are still needed
+func (l *keyLookup) add(_ uint64, k kind.Kind, value []byte) bool {
+	switch k {
+	case kind.Merge:
+		l.operands = append(l.operands, append([]byte{}, value...))
+		return true
+	case kind.Value:
+		l.base, l.exists = append([]byte{}, value...), true
+	case kind.Expiring:
+		if expiry, v := kind.DecodeExpiring(value); expiry > l.now {
+			l.base, l.exists = append([]byte{}, v...), true
+		}
+	}
+	l.done = true
+	return false
+}
+
+// result returns the value of the key, merging the operands into the base value with op
+func (l *keyLookup) result(op sstable.MergeOperator) ([]byte, bool, error) {
+	if len(l.operands) == 0 {
+		return l.base, l.exists, nil
+	}
+	if op == nil {
+		return nil, false, errNoMergeOperator
+	}
+
+	operands := make([][]byte, len(l.operands))
+	for i, operand := range l.operands {
+		operands[len(operands)-1-i] = operand
+	}
+	var existing []byte
+	if l.exists {
+		existing = l.base
+	}
+	merged, err := op.FullMerge(l.key, existing, operands)
+	if err != nil {
+		return nil, false, fmt.Errorf("merge operator: %w", err)
+	}
+	return merged, true, nil
+}
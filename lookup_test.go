@@ -0,0 +1,238 @@
+package stratago
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// counterOperator adds up decimal operands
+type counterOperator struct{}
+
+func (counterOperator) FullMerge(_, existing []byte, operands [][]byte) ([]byte, error) {
+	total := 0
+	if existing != nil {
+		n, err := strconv.Atoi(string(existing))
+		if err != nil {
+			return nil, err
+		}
+		total = n
+	}
+	for _, operand := range operands {
+		n, err := strconv.Atoi(string(operand))
+		if err != nil {
+			return nil, err
+		}
+		total += n
+	}
+	return []byte(strconv.Itoa(total)), nil
+}
+
+func (counterOperator) PartialMerge(_ []byte, operands [][]byte) ([]byte, bool) {
+	merged, err := counterOperator{}.FullMerge(nil, nil, operands)
+	return merged, err == nil
+}
+
+func assertValue(t *testing.T, db *StrataGo, key, expected string) {
+	t.Helper()
+	val, found := db.Get([]byte(key))
+	assert.True(t, found, key)
+	assert.Equal(t, expected, string(val), key)
+}
+
+func TestMerge_Counter(t *testing.T) {
+	for _, strategy := range []CompactionStrategy{SizeTiered, Leveled} {
+		t.Run(strategy.String(), func(t *testing.T) {
+			dataDir := "test_merge_counter"
+			defer os.RemoveAll(dataDir)
+
+			db, err := OpenWithOptions(dataDir, &Options{MergeOperator: counterOperator{}, CompactionStrategy: strategy})
+			assert.NoError(t, err)
+			defer db.Close()
+
+			// A key with no value starts from nothing, a value or tombstone is the base
+			for range 3 {
+				assert.NoError(t, db.Merge([]byte("hits"), []byte("1")))
+			}
+			db.Put([]byte("total"), []byte("10"))
+			db.Merge([]byte("total"), []byte("5"))
+			db.Put([]byte("reset"), []byte("7"))
+			db.Delete([]byte("reset"))
+			db.Merge([]byte("reset"), []byte("2"))
+			assertValue(t, db, "hits", "3")
+			assertValue(t, db, "total", "15")
+			assertValue(t, db, "reset", "2")
+
+			// Operands spread over memtables and tables are combined on read
+			assert.NoError(t, db.Flush())
+			snap := db.NewSnapshot()
+			defer snap.Release()
+			db.Merge([]byte("hits"), []byte("4"))
+			db.Merge([]byte("total"), []byte("-20"))
+			assertValue(t, db, "hits", "7")
+			assertValue(t, db, "total", "-5")
+
+			val, _ := snap.Get([]byte("hits"))
+			assert.Equal(t, []byte("3"), val, "Snapshots do not see later operands")
+
+			it, err := db.NewIterator(nil, nil)
+			assert.NoError(t, err)
+			var values []string
+			for ok := it.First(); ok; ok = it.Next() {
+				values = append(values, string(it.Key())+"="+string(it.Value()))
+			}
+			assert.NoError(t, it.Error())
+			it.Close()
+			assert.Equal(t, []string{"hits=7", "reset=2", "total=-5"}, values)
+
+			// Compaction folds the operands into plain values
+			assert.NoError(t, db.CompactRange(nil, nil))
+			assertValue(t, db, "hits", "7")
+			assertValue(t, db, "total", "-5")
+			val, _ = snap.Get([]byte("total"))
+			assert.Equal(t, []byte("15"), val)
+		})
+	}
+}
+
+func TestMerge_Recovery(t *testing.T) {
+	dataDir := "test_merge_recovery"
+	defer os.RemoveAll(dataDir)
+
+	opts := &Options{MergeOperator: counterOperator{}}
+	db, err := OpenWithOptions(dataDir, opts)
+	assert.NoError(t, err)
+
+	db.Put([]byte("k"), []byte("1"))
+	assert.NoError(t, db.Flush())
+	db.Merge([]byte("k"), []byte("2"))
+
+	batch := NewWriteBatch()
+	batch.Merge([]byte("k"), []byte("3"))
+	batch.Merge([]byte("empty"), []byte("0"))
+	assert.NoError(t, db.Write(batch))
+
+	// Operands are replayed from the WAL and still merge into the flushed value
+	db.wal.Close()
+	db, err = OpenWithOptions(dataDir, opts)
+	assert.NoError(t, err)
+	defer db.Close()
+	assertValue(t, db, "k", "6")
+	assertValue(t, db, "empty", "0")
+}
+
+func TestMerge_RequiresOperator(t *testing.T) {
+	dataDir := "test_merge_no_operator"
+	defer os.RemoveAll(dataDir)
+
+	db, err := Open(dataDir)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	assert.ErrorIs(t, db.Merge([]byte("k"), []byte("1")), errNoMergeOperator)
+
+	batch := NewWriteBatch()
+	batch.Put([]byte("a"), []byte("1"))
+	batch.Merge([]byte("k"), []byte("1"))
+	assert.ErrorIs(t, db.Write(batch), errNoMergeOperator)
+	_, found := db.Get([]byte("a"))
+	assert.False(t, found, "A rejected batch applies nothing")
+}
+
+func TestMerge_OperatorError(t *testing.T) {
+	dataDir := "test_merge_operator_error"
+	defer os.RemoveAll(dataDir)
+
+	db, err := OpenWithOptions(dataDir, &Options{MergeOperator: counterOperator{}})
+	assert.NoError(t, err)
+	defer db.Close()
+
+	db.Put([]byte("bad"), []byte("not a number"))
+	db.Merge([]byte("bad"), []byte("1"))
+	db.Put([]byte("good"), []byte("1"))
+
+	// A failed merge reads as a missing key and stops iteration
+	_, found := db.Get([]byte("bad"))
+	assert.False(t, found)
+	assertValue(t, db, "good", "1")
+
+	it, err := db.NewIterator(nil, nil)
+	assert.NoError(t, err)
+	defer it.Close()
+	assert.False(t, it.First())
+	var numErr *strconv.NumError
+	assert.True(t, errors.As(it.Error(), &numErr))
+
+	// Flushes keep the operands, and compaction refuses to drop data it cannot merge
+	assert.NoError(t, db.Flush())
+	assert.ErrorContains(t, db.CompactRange(nil, nil), "merge operator")
+	assertValue(t, db, "good", "1")
+}
+
+func TestPutWithTTL(t *testing.T) {
+	dataDir := "test_ttl"
+	defer os.RemoveAll(dataDir)
+
+	db, err := Open(dataDir)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	now := time.Now()
+	db.now = func() time.Time { return now }
+
+	db.Put([]byte("session"), []byte("old"))
+	assert.NoError(t, db.PutWithTTL([]byte("session"), []byte("token"), time.Minute))
+	assert.NoError(t, db.PutWithTTL([]byte("bucket"), []byte("5"), time.Hour))
+	db.Put([]byte("user"), []byte("alice"))
+	assert.Error(t, db.PutWithTTL([]byte("k"), []byte("v"), 0))
+
+	assertValue(t, db, "session", "token")
+	assert.NoError(t, db.Flush())
+	assertValue(t, db, "session", "token")
+
+	// Once expired, the key reads as deleted instead of exposing the older value
+	now = now.Add(2 * time.Minute)
+	_, found := db.Get([]byte("session"))
+	assert.False(t, found)
+	assertValue(t, db, "bucket", "5")
+
+	it, err := db.NewIterator(nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"bucket", "user"}, collectKeys(t, it))
+	it.Close()
+
+	// Compaction physically removes the expired key and what it shadowed
+	assert.NoError(t, db.CompactRange(nil, nil))
+	tables := db.GetSSTableContents()
+	assert.NotEmpty(t, tables)
+	for path, contents := range tables {
+		assert.NotContains(t, contents, "session", path)
+		assert.Contains(t, contents, "bucket", path)
+	}
+	_, found = db.Get([]byte("session"))
+	assert.False(t, found)
+}
+
+func TestPutWithTTL_Recovery(t *testing.T) {
+	dataDir := "test_ttl_recovery"
+	defer os.RemoveAll(dataDir)
+
+	db, err := Open(dataDir)
+	assert.NoError(t, err)
+	assert.NoError(t, db.PutWithTTL([]byte("k"), []byte("v"), time.Hour))
+
+	// The expiry is replayed from the WAL along with the value
+	db.wal.Close()
+	db, err = Open(dataDir)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	assertValue(t, db, "k", "v")
+	db.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	_, found := db.Get([]byte("k"))
+	assert.False(t, found)
+}
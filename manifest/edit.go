@@ -0,0 +1,216 @@
+package manifest
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// FileMeta describes a live SSTable
+type FileMeta struct {
+	Family   uint32 // Column family, 0 for the default one
+	Number   uint64
+	Level    int
+	Size     int64
+	Smallest []byte
+	Largest  []byte
+}
+
+// DeletedFile names a table removed from a level
+type DeletedFile struct {
+	Level  int
+	Number uint64
+}
+
+// FamilyMeta describes a column family other than the default one
+type FamilyMeta struct {
+	ID        uint32
+	Name      string
+	LogNumber uint64 // WAL segments numbered below it hold no unflushed writes of the family
+}
+
+// VersionEdit is one atomic change to the set of live tables and column families.
+// Zero NextFileNumber, LastSequence, LogNumber, NextFamilyID and Comparator leave the recorded values unchanged.
+type VersionEdit struct {
+	NewFiles         []FileMeta
+	DeletedFiles     []DeletedFile
+	NextFileNumber   uint64
+	LastSequence     uint64
+	LogNumber        uint64            // WAL segments numbered below it hold no unflushed writes of the default family
+	FamilyLogNumbers map[uint32]uint64 // The same for other families, by id
+	Families         []FamilyMeta      // Created families
+	DroppedFamilies  []uint32          // Dropped along with all their tables
+	NextFamilyID     uint32
+	Comparator       string // Name of the key order, recorded when the DB is created
+}
+
+// Field tags of an encoded edit
+const (
+	tagNextFileNumber = 1
+	tagLastSequence   = 2
+	tagDeletedFile    = 3 // [Level][Number]
+	tagNewFile        = 4 // [Level][Number][Size][SmallestLen][Smallest][LargestLen][Largest]
+	tagLogNumber      = 5
+	tagFamily         = 6 // [ID][NameLen][Name][LogNumber]
+	tagDroppedFamily  = 7 // [ID]
+	tagNextFamilyID   = 8
+	tagFamilyFile     = 9  // [Family] followed by the fields of tagNewFile
+	tagFamilyLog      = 10 // [ID][LogNumber]
+	tagComparator     = 11 // [NameLen][Name]
+)
+
+var errMalformedEdit = errors.New("malformed version edit")
+
+// encode returns the edit as a sequence of tagged uvarint fields
+func (e *VersionEdit) encode() []byte {
+	var buf []byte
+	if e.NextFileNumber != 0 {
+		buf = binary.AppendUvarint(buf, tagNextFileNumber)
+		buf = binary.AppendUvarint(buf, e.NextFileNumber)
+	}
+	if e.LastSequence != 0 {
+		buf = binary.AppendUvarint(buf, tagLastSequence)
+		buf = binary.AppendUvarint(buf, e.LastSequence)
+	}
+	if e.LogNumber != 0 {
+		buf = binary.AppendUvarint(buf, tagLogNumber)
+		buf = binary.AppendUvarint(buf, e.LogNumber)
+	}
+	if e.Comparator != "" {
+		buf = binary.AppendUvarint(buf, tagComparator)
+		buf = binary.AppendUvarint(buf, uint64(len(e.Comparator)))
+		buf = append(buf, e.Comparator...)
+	}
+	for id, logNumber := range e.FamilyLogNumbers {
+		buf = binary.AppendUvarint(buf, tagFamilyLog)
+		buf = binary.AppendUvarint(buf, uint64(id))
+		buf = binary.AppendUvarint(buf, logNumber)
+	}
+	if e.NextFamilyID != 0 {
+		buf = binary.AppendUvarint(buf, tagNextFamilyID)
+		buf = binary.AppendUvarint(buf, uint64(e.NextFamilyID))
+	}
+	for _, f := range e.Families {
+		buf = binary.AppendUvarint(buf, tagFamily)
+		buf = binary.AppendUvarint(buf, uint64(f.ID))
+		buf = binary.AppendUvarint(buf, uint64(len(f.Name)))
+		buf = append(buf, f.Name...)
+		buf = binary.AppendUvarint(buf, f.LogNumber)
+	}
+	for _, id := range e.DroppedFamilies {
+		buf = binary.AppendUvarint(buf, tagDroppedFamily)
+		buf = binary.AppendUvarint(buf, uint64(id))
+	}
+	for _, d := range e.DeletedFiles {
+		buf = binary.AppendUvarint(buf, tagDeletedFile)
+		buf = binary.AppendUvarint(buf, uint64(d.Level))
+		buf = binary.AppendUvarint(buf, d.Number)
+	}
+	for _, f := range e.NewFiles {
+		// Tables of the default family keep the tag older manifests know
+		if f.Family != 0 {
+			buf = binary.AppendUvarint(buf, tagFamilyFile)
+			buf = binary.AppendUvarint(buf, uint64(f.Family))
+		} else {
+			buf = binary.AppendUvarint(buf, tagNewFile)
+		}
+		buf = binary.AppendUvarint(buf, uint64(f.Level))
+		buf = binary.AppendUvarint(buf, f.Number)
+		buf = binary.AppendUvarint(buf, uint64(f.Size))
+		buf = binary.AppendUvarint(buf, uint64(len(f.Smallest)))
+		buf = append(buf, f.Smallest...)
+		buf = binary.AppendUvarint(buf, uint64(len(f.Largest)))
+		buf = append(buf, f.Largest...)
+	}
+	return buf
+}
+
+// decodeEdit parses an edit written by encode
+func decodeEdit(buf []byte) (*VersionEdit, error) {
+	e := &VersionEdit{}
+	d := decoder{buf: buf}
+	for len(d.buf) > 0 && d.err == nil {
+		switch tag := d.uvarint(); tag {
+		case tagNextFileNumber:
+			e.NextFileNumber = d.uvarint()
+		case tagLastSequence:
+			e.LastSequence = d.uvarint()
+		case tagLogNumber:
+			e.LogNumber = d.uvarint()
+		case tagDeletedFile:
+			e.DeletedFiles = append(e.DeletedFiles, DeletedFile{Level: int(d.uvarint()), Number: d.uvarint()})
+		case tagNewFile, tagFamilyFile:
+			var family uint32
+			if tag == tagFamilyFile {
+				family = uint32(d.uvarint())
+			}
+			e.NewFiles = append(e.NewFiles, FileMeta{
+				Family:   family,
+				Level:    int(d.uvarint()),
+				Number:   d.uvarint(),
+				Size:     int64(d.uvarint()),
+				Smallest: d.bytes(),
+				Largest:  d.bytes(),
+			})
+		case tagFamily:
+			e.Families = append(e.Families, FamilyMeta{
+				ID:        uint32(d.uvarint()),
+				Name:      string(d.bytes()),
+				LogNumber: d.uvarint(),
+			})
+		case tagDroppedFamily:
+			e.DroppedFamilies = append(e.DroppedFamilies, uint32(d.uvarint()))
+		case tagFamilyLog:
+			if e.FamilyLogNumbers == nil {
+				e.FamilyLogNumbers = make(map[uint32]uint64)
+			}
+			id := uint32(d.uvarint())
+			e.FamilyLogNumbers[id] = d.uvarint()
+		case tagNextFamilyID:
+			e.NextFamilyID = uint32(d.uvarint())
+		case tagComparator:
+			e.Comparator = string(d.bytes())
+		default:
+			if d.err == nil {
+				return nil, fmt.Errorf("%w: unknown tag %d", errMalformedEdit, tag)
+			}
+		}
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	return e, nil
+}
+
+// decoder reads uvarint fields, remembering the first error
+type decoder struct {
+	buf []byte
+	err error
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		d.err = errMalformedEdit
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *decoder) bytes() []byte {
+	n := d.uvarint()
+	if d.err != nil {
+		return nil
+	}
+	if n > uint64(len(d.buf)) {
+		d.err = errMalformedEdit
+		return nil
+	}
+	b := append([]byte{}, d.buf[:n]...)
+	d.buf = d.buf[n:]
+	return b
+}
@@ -0,0 +1,423 @@
+package manifest
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// CurrentFile names the file that points at the live manifest
+const CurrentFile = "CURRENT"
+
+// Records are [CRC32C(4B)][Length(4B)][Edit], the checksum covering the edit
+const recordHeaderSize = 8
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Manifest is the log of version edits that defines which tables are live.
+// Every change is appended and synced before it is acknowledged, so the
+// data directory can be rebuilt exactly as of the last applied edit.
+type Manifest struct {
+	mu             sync.Mutex
+	dir            string
+	file           *os.File
+	number         uint64 // File number of the manifest itself
+	files          map[uint64]FileMeta
+	nextFileNumber uint64
+	lastSequence   uint64
+	logNumber      uint64
+	families       map[uint32]FamilyMeta
+	nextFamilyID   uint32
+	comparator     string
+}
+
+func newManifest(dir string) *Manifest {
+	return &Manifest{
+		dir:            dir,
+		files:          make(map[uint64]FileMeta),
+		nextFileNumber: 1,
+		families:       make(map[uint32]FamilyMeta),
+		nextFamilyID:   1,
+	}
+}
+
+// FileName returns the name of the manifest with the given file number
+func FileName(number uint64) string {
+	return fmt.Sprintf("MANIFEST-%06d", number)
+}
+
+// Exists reports whether dir holds a manifest
+func Exists(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, CurrentFile))
+	return err == nil
+}
+
+// Create starts a new manifest in dir whose initial state is the given edit,
+// then points CURRENT at it. Nothing in dir is live until CURRENT is written.
+func Create(dir string, initial *VersionEdit) (*Manifest, error) {
+	m := newManifest(dir)
+	m.apply(initial)
+	if err := m.rotate(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// ErrCorrupt is returned when the manifest is damaged somewhere other than its last record
+var ErrCorrupt = errors.New("manifest: corrupt")
+
+// Load replays the manifest named by CURRENT in dir. A torn or corrupt last record
+// ends the replay, as the edit it held was never acknowledged, but damage followed
+// by intact records fails with ErrCorrupt, since replaying around it would forget
+// the tables of later edits. The state is then written to a fresh manifest so the
+// log does not grow across restarts.
+func Load(dir string) (*Manifest, error) {
+	current, err := os.ReadFile(filepath.Join(dir, CurrentFile))
+	if err != nil {
+		return nil, err
+	}
+	name := strings.TrimSpace(string(current))
+	if !strings.HasPrefix(name, "MANIFEST-") {
+		return nil, fmt.Errorf("invalid CURRENT file: %q", name)
+	}
+
+	// Every open rewrites the manifest as a single snapshot, so it stays small
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open manifest: %w", err)
+	}
+
+	m := newManifest(dir)
+	fmt.Sscanf(name, "MANIFEST-%d", &m.number)
+	for offset := 0; offset < len(data); {
+		edit, size, err := decodeRecord(data[offset:])
+		if err != nil {
+			if intactRecordAfter(data, offset) {
+				return nil, fmt.Errorf("%w: %s is damaged at offset %d before later edits: %v", ErrCorrupt, name, offset, err)
+			}
+			break // Torn tail
+		}
+		m.apply(edit)
+		offset += size
+	}
+
+	if err := m.rotate(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// decodeRecord decodes the edit at the start of buf and returns the size of its record
+func decodeRecord(buf []byte) (*VersionEdit, int, error) {
+	if len(buf) < recordHeaderSize {
+		return nil, 0, errors.New("truncated record header")
+	}
+	length := int64(binary.LittleEndian.Uint32(buf[4:8]))
+	if int64(len(buf)-recordHeaderSize) < length {
+		return nil, 0, errors.New("truncated record")
+	}
+	payload := buf[recordHeaderSize : recordHeaderSize+length]
+	if crc32.Checksum(payload, crcTable) != binary.LittleEndian.Uint32(buf[0:4]) {
+		return nil, 0, errors.New("record checksum mismatch")
+	}
+	edit, err := decodeEdit(payload)
+	if err != nil {
+		return nil, 0, err
+	}
+	return edit, recordHeaderSize + int(length), nil
+}
+
+// intactRecordAfter reports whether a record that passes its checksum starts anywhere
+// after the damaged one at offset. Empty records are skipped, as zeroed space left by
+// a crash would pass for them.
+func intactRecordAfter(data []byte, offset int) bool {
+	for i := offset + 1; i+recordHeaderSize < len(data); i++ {
+		if binary.LittleEndian.Uint32(data[i+4:i+8]) == 0 {
+			continue
+		}
+		if _, _, err := decodeRecord(data[i:]); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// encodeRecord frames an edit for the log
+func encodeRecord(edit *VersionEdit) []byte {
+	payload := edit.encode()
+	buf := make([]byte, recordHeaderSize, recordHeaderSize+len(payload))
+	binary.LittleEndian.PutUint32(buf[0:4], crc32.Checksum(payload, crcTable))
+	binary.LittleEndian.PutUint32(buf[4:8], uint32(len(payload)))
+	return append(buf, payload...)
+}
+
+// rotate writes the whole state to a new manifest, switches CURRENT to it
+// and removes the previous one
+func (m *Manifest) rotate() error {
+	number := m.nextFileNumber
+	m.nextFileNumber++
+	path := filepath.Join(m.dir, FileName(number))
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(encodeRecord(m.snapshot())); err != nil {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+	if err := setCurrent(m.dir, FileName(number)); err != nil {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+
+	if m.file != nil {
+		m.file.Close()
+	}
+	if m.number != 0 {
+		os.Remove(filepath.Join(m.dir, FileName(m.number)))
+	}
+	m.file = f
+	m.number = number
+	return nil
+}
+
+// setCurrent atomically points CURRENT at the named manifest
+func setCurrent(dir, name string) error {
+	tmpPath := filepath.Join(dir, CurrentFile+".tmp")
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(name + "\n"); err != nil {
+		f.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, filepath.Join(dir, CurrentFile)); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return syncDir(dir)
+}
+
+// syncDir makes renames and new files in dir durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// snapshot returns a single edit that recreates the current state
+func (m *Manifest) snapshot() *VersionEdit {
+	return &VersionEdit{
+		NewFiles:       m.liveFiles(),
+		NextFileNumber: m.nextFileNumber,
+		LastSequence:   m.lastSequence,
+		LogNumber:      m.logNumber,
+		Families:       m.liveFamilies(),
+		NextFamilyID:   m.nextFamilyID,
+		Comparator:     m.comparator,
+	}
+}
+
+// apply folds an edit into the in-memory state
+func (m *Manifest) apply(edit *VersionEdit) {
+	for _, f := range edit.Families {
+		m.families[f.ID] = f
+		m.nextFamilyID = max(m.nextFamilyID, f.ID+1)
+	}
+	m.nextFamilyID = max(m.nextFamilyID, edit.NextFamilyID)
+	if edit.Comparator != "" {
+		m.comparator = edit.Comparator
+	}
+	for _, id := range edit.DroppedFamilies {
+		delete(m.families, id)
+		for number, f := range m.files {
+			if f.Family == id {
+				delete(m.files, number)
+			}
+		}
+	}
+
+	for _, d := range edit.DeletedFiles {
+		delete(m.files, d.Number)
+	}
+	for _, f := range edit.NewFiles {
+		m.nextFileNumber = max(m.nextFileNumber, f.Number+1)
+		if _, ok := m.families[f.Family]; !ok && f.Family != 0 {
+			continue // Written while its family was dropped
+		}
+		m.files[f.Number] = f
+	}
+	m.nextFileNumber = max(m.nextFileNumber, edit.NextFileNumber)
+	m.lastSequence = max(m.lastSequence, edit.LastSequence)
+	m.logNumber = max(m.logNumber, edit.LogNumber)
+	for id, logNumber := range edit.FamilyLogNumbers {
+		if f, ok := m.families[id]; ok { // Families dropped in the meantime are ignored
+			f.LogNumber = max(f.LogNumber, logNumber)
+			m.families[id] = f
+		}
+	}
+}
+
+// Apply durably logs an edit and then folds it into the state. The edit
+// takes effect atomically: after a crash either all of it or none of it is seen.
+func (m *Manifest) Apply(edit *VersionEdit) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	edit.NextFileNumber = max(edit.NextFileNumber, m.nextFileNumber)
+	_, err := m.file.Write(encodeRecord(edit))
+	if err == nil {
+		err = m.file.Sync()
+	}
+	if err != nil {
+		// A torn record would hide every later edit, so move to a clean log
+		if rotateErr := m.rotate(); rotateErr != nil {
+			return fmt.Errorf("%w (and failed to rotate manifest: %v)", err, rotateErr)
+		}
+		return err
+	}
+	m.apply(edit)
+	return nil
+}
+
+// NewFileNumber reserves a file number. Numbers are never reused, and a crash
+// can only lose numbers that no applied edit refers to.
+func (m *Manifest) NewFileNumber() uint64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	number := m.nextFileNumber
+	m.nextFileNumber++
+	return number
+}
+
+// MarkFileNumberUsed makes sure number is never handed out by NewFileNumber.
+// It covers files created under a reserved number that no edit recorded.
+func (m *Manifest) MarkFileNumberUsed(number uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.nextFileNumber = max(m.nextFileNumber, number+1)
+}
+
+// Files returns the live tables ordered by level and then by file number
+func (m *Manifest) Files() []FileMeta {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.liveFiles()
+}
+
+func (m *Manifest) liveFiles() []FileMeta {
+	files := make([]FileMeta, 0, len(m.files))
+	for _, f := range m.files {
+		files = append(files, f)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].Level != files[j].Level {
+			return files[i].Level < files[j].Level
+		}
+		return files[i].Number < files[j].Number
+	})
+	return files
+}
+
+// LastSequence returns the highest sequence number recorded by an edit
+func (m *Manifest) LastSequence() uint64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.lastSequence
+}
+
+// LogNumber returns the number of the oldest WAL segment that may hold unflushed writes of the default family
+func (m *Manifest) LogNumber() uint64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.logNumber
+}
+
+// MinLogNumber returns the number of the oldest WAL segment that may hold unflushed writes of any family
+func (m *Manifest) MinLogNumber() uint64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	res := m.logNumber
+	for _, f := range m.families {
+		res = min(res, f.LogNumber)
+	}
+	return res
+}
+
+// Families returns the column families other than the default one, ordered by id
+func (m *Manifest) Families() []FamilyMeta {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.liveFamilies()
+}
+
+func (m *Manifest) liveFamilies() []FamilyMeta {
+	families := make([]FamilyMeta, 0, len(m.families))
+	for _, f := range m.families {
+		families = append(families, f)
+	}
+	sort.Slice(families, func(i, j int) bool { return families[i].ID < families[j].ID })
+	return families
+}
+
+// NewFamilyID reserves the id of a new column family. Like file numbers, ids are never
+// reused, so writes of a dropped family left in the WAL cannot reach a new one.
+func (m *Manifest) NewFamilyID() uint32 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	id := m.nextFamilyID
+	m.nextFamilyID++
+	return id
+}
+
+// Comparator returns the name of the key order the tables are sorted in,
+// or "" for manifests written before it was recorded
+func (m *Manifest) Comparator() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.comparator
+}
+
+// FileNumber returns the file number of the manifest itself
+func (m *Manifest) FileNumber() uint64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.number
+}
+
+// Close closes the manifest file
+func (m *Manifest) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.file.Close()
+}
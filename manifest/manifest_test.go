@@ -0,0 +1,182 @@
+package manifest
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVersionEdit_RoundTrip(t *testing.T) {
+	edit := &VersionEdit{
+		NewFiles: []FileMeta{
+			{Number: 7, Level: 0, Size: 4096, Smallest: []byte("a"), Largest: []byte("m")},
+			{Number: 8, Level: 2, Size: 1, Smallest: []byte{}, Largest: []byte("z")},
+		},
+		DeletedFiles:   []DeletedFile{{Level: 1, Number: 3}},
+		NextFileNumber: 9,
+		LastSequence:   1234,
+		LogNumber:      5,
+		Comparator:     "stratago.Numeric",
+	}
+
+	decoded, err := decodeEdit(edit.encode())
+	assert.NoError(t, err)
+	assert.Equal(t, edit.NewFiles[0], decoded.NewFiles[0])
+	assert.Equal(t, edit.DeletedFiles, decoded.DeletedFiles)
+	assert.Equal(t, uint64(9), decoded.NextFileNumber)
+	assert.Equal(t, uint64(1234), decoded.LastSequence)
+	assert.Equal(t, uint64(5), decoded.LogNumber)
+	assert.Equal(t, "stratago.Numeric", decoded.Comparator)
+
+	_, err = decodeEdit([]byte{tagNewFile, 1})
+	assert.Error(t, err)
+}
+
+func TestManifest_ReloadsState(t *testing.T) {
+	dir := t.TempDir()
+	assert.False(t, Exists(dir))
+
+	m, err := Create(dir, &VersionEdit{NewFiles: []FileMeta{{Number: 1, Level: 0}}, NextFileNumber: 2, Comparator: "stratago.Numeric"})
+	assert.NoError(t, err)
+	assert.True(t, Exists(dir))
+
+	n := m.NewFileNumber()
+	assert.Greater(t, n, uint64(1))
+	assert.NoError(t, m.Apply(&VersionEdit{
+		NewFiles:     []FileMeta{{Number: n, Level: 1, Smallest: []byte("a"), Largest: []byte("b")}},
+		DeletedFiles: []DeletedFile{{Level: 0, Number: 1}},
+		LastSequence: 42,
+		LogNumber:    n,
+	}))
+	first := m.FileNumber()
+	assert.NoError(t, m.Close())
+
+	m, err = Load(dir)
+	assert.NoError(t, err)
+	defer m.Close()
+
+	files := m.Files()
+	assert.Len(t, files, 1)
+	assert.Equal(t, n, files[0].Number)
+	assert.Equal(t, 1, files[0].Level)
+	assert.Equal(t, uint64(42), m.LastSequence())
+	assert.Equal(t, n, m.LogNumber())
+	assert.Equal(t, "stratago.Numeric", m.Comparator(), "Later edits keep the comparator")
+	assert.Greater(t, m.NewFileNumber(), n, "File numbers are never reused")
+	m.MarkFileNumberUsed(100)
+	assert.Equal(t, uint64(101), m.NewFileNumber())
+
+	// Loading rewrites the state into a new manifest and drops the old one
+	_, err = os.Stat(filepath.Join(dir, FileName(first)))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestManifest_IgnoresTornTail(t *testing.T) {
+	dir := t.TempDir()
+
+	m, err := Create(dir, &VersionEdit{})
+	assert.NoError(t, err)
+	assert.NoError(t, m.Apply(&VersionEdit{NewFiles: []FileMeta{{Number: 5}}}))
+	path := filepath.Join(dir, FileName(m.FileNumber()))
+	assert.NoError(t, m.Close())
+
+	// A crash halfway through appending the next edit
+	torn := encodeRecord(&VersionEdit{NewFiles: []FileMeta{{Number: 6}}})
+	f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	f.Write(torn[:len(torn)-3])
+	f.Close()
+
+	m, err = Load(dir)
+	assert.NoError(t, err)
+	defer m.Close()
+
+	files := m.Files()
+	assert.Len(t, files, 1)
+	assert.Equal(t, uint64(5), files[0].Number)
+}
+
+func TestManifest_FailsOnDamageBeforeIntactEdits(t *testing.T) {
+	dir := t.TempDir()
+
+	m, err := Create(dir, &VersionEdit{})
+	assert.NoError(t, err)
+	path := filepath.Join(dir, FileName(m.FileNumber()))
+	stat, _ := os.Stat(path)
+	damaged := stat.Size() + recordHeaderSize // Payload of the first applied edit
+	assert.NoError(t, m.Apply(&VersionEdit{NewFiles: []FileMeta{{Number: 5}}}))
+	assert.NoError(t, m.Apply(&VersionEdit{NewFiles: []FileMeta{{Number: 6}}}))
+	assert.NoError(t, m.Close())
+
+	data, _ := os.ReadFile(path)
+	data[damaged] ^= 0xFF
+	assert.NoError(t, os.WriteFile(path, data, 0644))
+
+	// Replaying up to the damage would forget table 6
+	_, err = Load(dir)
+	assert.ErrorIs(t, err, ErrCorrupt)
+	_, err = os.Stat(path)
+	assert.NoError(t, err, "The damaged manifest is left for inspection")
+}
+
+func TestManifest_IgnoresZeroedTail(t *testing.T) {
+	dir := t.TempDir()
+
+	m, err := Create(dir, &VersionEdit{})
+	assert.NoError(t, err)
+	assert.NoError(t, m.Apply(&VersionEdit{NewFiles: []FileMeta{{Number: 5}}}))
+	path := filepath.Join(dir, FileName(m.FileNumber()))
+	assert.NoError(t, m.Close())
+
+	// Space the file system extended the file with, but the edit never reached
+	f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	f.Write(make([]byte, 64))
+	f.Close()
+
+	m, err = Load(dir)
+	assert.NoError(t, err)
+	defer m.Close()
+	assert.Len(t, m.Files(), 1)
+}
+
+func TestManifest_ColumnFamilies(t *testing.T) {
+	dir := t.TempDir()
+
+	m, err := Create(dir, &VersionEdit{})
+	assert.NoError(t, err)
+	id := m.NewFamilyID()
+	assert.NoError(t, m.Apply(&VersionEdit{Families: []FamilyMeta{{ID: id, Name: "logs", LogNumber: 3}}}))
+	assert.NoError(t, m.Apply(&VersionEdit{NewFiles: []FileMeta{
+		{Number: 10, Smallest: []byte("a"), Largest: []byte("a")},
+		{Family: id, Number: 11, Smallest: []byte("b"), Largest: []byte("b")},
+	}}))
+	assert.NoError(t, m.Apply(&VersionEdit{LogNumber: 9}))
+	assert.NoError(t, m.Apply(&VersionEdit{FamilyLogNumbers: map[uint32]uint64{id: 7}}))
+	assert.Equal(t, uint64(9), m.LogNumber())
+	assert.Equal(t, uint64(7), m.MinLogNumber())
+	assert.NoError(t, m.Close())
+
+	m, err = Load(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, []FamilyMeta{{ID: id, Name: "logs", LogNumber: 7}}, m.Families())
+	assert.Equal(t, []FileMeta{
+		{Number: 10, Smallest: []byte("a"), Largest: []byte("a")},
+		{Family: id, Number: 11, Smallest: []byte("b"), Largest: []byte("b")},
+	}, m.Files())
+
+	// Dropping a family drops its tables in one edit, and later edits of it are ignored
+	assert.NoError(t, m.Apply(&VersionEdit{DroppedFamilies: []uint32{id}}))
+	assert.NoError(t, m.Apply(&VersionEdit{FamilyLogNumbers: map[uint32]uint64{id: 12}, NewFiles: []FileMeta{{Family: id, Number: 12}}}))
+	assert.Empty(t, m.Families())
+	assert.Len(t, m.Files(), 1)
+	assert.Equal(t, uint64(9), m.MinLogNumber())
+	assert.NoError(t, m.Close())
+
+	m, err = Load(dir)
+	assert.NoError(t, err)
+	defer m.Close()
+	assert.Empty(t, m.Families())
+	assert.Len(t, m.Files(), 1)
+	assert.Greater(t, m.NewFamilyID(), id, "Family ids are never reused")
+}
@@ -2,9 +2,13 @@ package memtable
 
 import (
 	"bytes"
+	"math"
 	"math/rand"
 	"sync"
 	"time"
+
+	"github.com/thomazdavis/stratago/comparator"
+	"github.com/thomazdavis/stratago/kind"
 )
 
 const (
@@ -14,6 +18,8 @@ const (
 
 type Node struct {
 	Key   []byte
+	Seq   uint64    // Sequence number of this version, newer versions sort first
+	Kind  kind.Kind // Value or tombstone, an empty Value is still a value
 	Value []byte
 
 	Next []*Node // Holds points to the next node at different levels
@@ -24,20 +30,28 @@ type SkipList struct {
 	Level     int // Current max level in the list
 	Size      int
 	SizeBytes int64
-	rand      *rand.Rand // For randomness
+	cmp       comparator.Comparator // Orders the keys
+	rand      *rand.Rand            // For randomness
 	mu        sync.RWMutex
 }
 
 type Iterator struct {
+	list    *SkipList
 	current *Node
 }
 
 func NewSkipList() *SkipList {
+	return NewSkipListWithComparator(comparator.Bytewise)
+}
+
+// NewSkipListWithComparator returns an empty list whose keys are ordered by cmp
+func NewSkipListWithComparator(cmp comparator.Comparator) *SkipList {
 	return &SkipList{
 		Head: &Node{
 			Next: make([]*Node, MaxLevel),
 		},
 		Level: 1,
+		cmp:   cmp,
 		rand:  rand.New(rand.NewSource(time.Now().UnixNano())),
 	}
 }
@@ -51,26 +65,66 @@ func (sl *SkipList) randomLevel() int {
 	return level
 }
 
-func (sl *SkipList) Put(key, value []byte) {
-	sl.mu.Lock()
-	defer sl.mu.Unlock()
+// compareVersion orders nodes by key ascending, then by sequence number descending
+func (sl *SkipList) compareVersion(key []byte, seq uint64, otherKey []byte, otherSeq uint64) int {
+	if cmp := sl.cmp.Compare(key, otherKey); cmp != 0 {
+		return cmp
+	}
+	if seq > otherSeq {
+		return -1
+	} else if seq < otherSeq {
+		return 1
+	}
+	return 0
+}
 
-	// Array to track the prev node at each level
-	update := make([]*Node, MaxLevel)
+// findGreaterOrEqual returns the first node at or after (key, seq).
+// If update is not nil it is filled with the predecessor at every level.
+// Callers must hold sl.mu.
+func (sl *SkipList) findGreaterOrEqual(key []byte, seq uint64, update []*Node) *Node {
 	current := sl.Head
 
 	// Search downwards from the highest level
 	for i := sl.Level - 1; i >= 0; i-- {
-		for current.Next[i] != nil && bytes.Compare(current.Next[i].Key, key) < 0 {
+		for current.Next[i] != nil && sl.compareVersion(current.Next[i].Key, current.Next[i].Seq, key, seq) < 0 {
 			current = current.Next[i]
 		}
-		update[i] = current
+		if update != nil {
+			update[i] = current
+		}
 	}
+	return current.Next[0]
+}
 
-	// Move to the next node at level 0 to check if key exists
-	current = current.Next[0]
-	if current != nil && bytes.Equal(current.Key, key) {
+// Put stores a value under sequence number 0, replacing any previous value stored the same way
+func (sl *SkipList) Put(key, value []byte) {
+	sl.Add(key, 0, kind.Value, value)
+}
+
+// PutVersion stores a value version of key tagged with seq
+func (sl *SkipList) PutVersion(key, value []byte, seq uint64) {
+	sl.Add(key, seq, kind.Value, value)
+}
+
+// DeleteVersion stores a tombstone for key tagged with seq
+func (sl *SkipList) DeleteVersion(key []byte, seq uint64) {
+	sl.Add(key, seq, kind.Delete, nil)
+}
+
+// Add stores a version of key tagged with seq. Versions with different
+// sequence numbers live side by side; the same (key, seq) pair is replaced.
+func (sl *SkipList) Add(key []byte, seq uint64, k kind.Kind, value []byte) {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+
+	// Array to track the prev node at each level
+	update := make([]*Node, MaxLevel)
+
+	// Check if this exact version already exists
+	current := sl.findGreaterOrEqual(key, seq, update)
+	if current != nil && current.Seq == seq && bytes.Equal(current.Key, key) {
 		sl.SizeBytes += int64(len(value) - len(current.Value))
+		current.Kind = k
 		current.Value = value
 		return
 	}
@@ -90,6 +144,8 @@ func (sl *SkipList) Put(key, value []byte) {
 	// Create the new node
 	newNode := &Node{
 		Key:   key,
+		Seq:   seq,
+		Kind:  k,
 		Value: value,
 		Next:  make([]*Node, newLevel),
 	}
@@ -104,35 +160,55 @@ func (sl *SkipList) Put(key, value []byte) {
 	sl.Size++
 }
 
+// Get returns the newest value of key. A deleted key is not found.
 func (sl *SkipList) Get(key []byte) ([]byte, bool) {
+	val, k, found := sl.GetAt(key, math.MaxUint64)
+	if !found || k == kind.Delete {
+		return nil, false
+	}
+	return val, true
+}
+
+// GetAt returns the newest version of key whose sequence number is <= seq.
+// A tombstone is found with kind.Delete, so callers can stop searching older layers.
+func (sl *SkipList) GetAt(key []byte, seq uint64) ([]byte, kind.Kind, bool) {
 	sl.mu.RLock()
 	defer sl.mu.RUnlock()
 
-	current := sl.Head
-
-	// Travel down from the highest level
-	for i := sl.Level - 1; i >= 0; i-- {
-		for current.Next[i] != nil && bytes.Compare(current.Next[i].Key, key) < 0 {
-			current = current.Next[i]
-		}
+	current := sl.findGreaterOrEqual(key, seq, nil)
+	if current != nil && bytes.Equal(current.Key, key) {
+		return current.Value, current.Kind, true
 	}
 
-	current = current.Next[0]
+	return nil, kind.Delete, false
+}
 
-	if current != nil && bytes.Equal(current.Key, key) {
-		return current.Value, true
-	}
+// Versions calls fn with every version of key whose sequence number is <= seq,
+// newest first, until fn returns false. fn must not write to the list.
+func (sl *SkipList) Versions(key []byte, seq uint64, fn func(seq uint64, k kind.Kind, value []byte) bool) {
+	sl.mu.RLock()
+	defer sl.mu.RUnlock()
 
-	return nil, false
+	for current := sl.findGreaterOrEqual(key, seq, nil); current != nil && bytes.Equal(current.Key, key); current = current.Next[0] {
+		if !fn(current.Seq, current.Kind, current.Value) {
+			return
+		}
+	}
 }
 
 // Creates a standard iterator starting at the head
 func (sl *SkipList) NewIterator() *Iterator {
-	return &Iterator{current: sl.Head}
+	return &Iterator{list: sl, current: sl.Head}
 }
 
 // Next moves the iterator forward. Returns false if we reached the end.
 func (it *Iterator) Next() bool {
+	it.list.mu.RLock()
+	defer it.list.mu.RUnlock()
+
+	if it.current == nil {
+		return false
+	}
 	if it.current.Next[0] != nil {
 		it.current = it.current.Next[0]
 		return true
@@ -140,10 +216,36 @@ func (it *Iterator) Next() bool {
 	return false
 }
 
+// First positions the iterator at the smallest key. Returns false if the list is empty.
+func (it *Iterator) First() bool {
+	it.list.mu.RLock()
+	it.current = it.list.Head
+	it.list.mu.RUnlock()
+	return it.Next()
+}
+
+// SeekGE positions the iterator at the newest version of the first key >= key.
+// Returns false if no such key exists.
+func (it *Iterator) SeekGE(key []byte) bool {
+	it.list.mu.RLock()
+	defer it.list.mu.RUnlock()
+
+	it.current = it.list.findGreaterOrEqual(key, math.MaxUint64, nil)
+	return it.current != nil
+}
+
 func (it *Iterator) Key() []byte {
 	return it.current.Key
 }
 
+func (it *Iterator) Seq() uint64 {
+	return it.current.Seq
+}
+
+func (it *Iterator) Kind() kind.Kind {
+	return it.current.Kind
+}
+
 func (it *Iterator) Value() []byte {
 	return it.current.Value
 }
@@ -6,6 +6,8 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/thomazdavis/stratago/comparator"
+	"github.com/thomazdavis/stratago/kind"
 )
 
 func TestSkipList_BasicOps(t *testing.T) {
@@ -68,3 +70,112 @@ func TestSkipList_Concurrency(t *testing.T) {
 	// If they failed, we might have lost data due to race conditions.
 	assert.Equal(t, numRoutines, list.Size)
 }
+
+func TestSkipList_IteratorSeek(t *testing.T) {
+	list := NewSkipList()
+	list.Put([]byte("b"), []byte("2"))
+	list.Put([]byte("d"), []byte("4"))
+	list.Put([]byte("f"), []byte("6"))
+
+	it := list.NewIterator()
+
+	assert.True(t, it.SeekGE([]byte("c")))
+	assert.Equal(t, []byte("d"), it.Key())
+
+	assert.True(t, it.Next())
+	assert.Equal(t, []byte("f"), it.Key())
+	assert.False(t, it.Next())
+
+	assert.True(t, it.First())
+	assert.Equal(t, []byte("b"), it.Key())
+
+	assert.False(t, it.SeekGE([]byte("g")))
+	assert.False(t, it.Next())
+}
+
+func TestSkipList_Versions(t *testing.T) {
+	list := NewSkipList()
+	list.PutVersion([]byte("k"), []byte("v1"), 1)
+	list.PutVersion([]byte("k"), []byte("v5"), 5)
+	list.PutVersion([]byte("k"), []byte("v3"), 3)
+
+	assert.Equal(t, 3, list.Size)
+
+	val, found := list.Get([]byte("k"))
+	assert.True(t, found)
+	assert.Equal(t, []byte("v5"), val)
+
+	val, _, found = list.GetAt([]byte("k"), 4)
+	assert.True(t, found)
+	assert.Equal(t, []byte("v3"), val)
+
+	_, _, found = list.GetAt([]byte("k"), 0)
+	assert.False(t, found)
+
+	// Iteration yields newest version first
+	it := list.NewIterator()
+	var seqs []uint64
+	for it.Next() {
+		seqs = append(seqs, it.Seq())
+	}
+	assert.Equal(t, []uint64{5, 3, 1}, seqs)
+
+	// Versions walks newest first from seq and stops when asked to
+	list.PutVersion([]byte("l"), []byte("other"), 2)
+	var walked []uint64
+	list.Versions([]byte("k"), 4, func(seq uint64, _ kind.Kind, _ []byte) bool {
+		walked = append(walked, seq)
+		return true
+	})
+	assert.Equal(t, []uint64{3, 1}, walked)
+
+	walked = nil
+	list.Versions([]byte("k"), 10, func(seq uint64, _ kind.Kind, _ []byte) bool {
+		walked = append(walked, seq)
+		return false
+	})
+	assert.Equal(t, []uint64{5}, walked)
+}
+
+func TestSkipList_EmptyValueVsTombstone(t *testing.T) {
+	list := NewSkipList()
+	list.PutVersion([]byte("empty"), []byte{}, 1)
+	list.PutVersion([]byte("nil"), nil, 2)
+	list.PutVersion([]byte("gone"), []byte("v"), 3)
+	list.DeleteVersion([]byte("gone"), 4)
+
+	val, found := list.Get([]byte("empty"))
+	assert.True(t, found)
+	assert.Empty(t, val)
+
+	_, found = list.Get([]byte("nil"))
+	assert.True(t, found, "A nil value is still a value")
+
+	_, found = list.Get([]byte("gone"))
+	assert.False(t, found)
+
+	_, k, found := list.GetAt([]byte("gone"), 4)
+	assert.True(t, found)
+	assert.Equal(t, kind.Delete, k)
+
+	val, k, _ = list.GetAt([]byte("gone"), 3)
+	assert.Equal(t, kind.Value, k)
+	assert.Equal(t, []byte("v"), val)
+}
+
+func TestSkipList_Comparator(t *testing.T) {
+	list := NewSkipListWithComparator(comparator.Numeric)
+	for _, key := range []string{"10", "9", "100", "2"} {
+		list.Put([]byte(key), []byte("v"+key))
+	}
+
+	var keys []string
+	for it := list.NewIterator(); it.Next(); {
+		keys = append(keys, string(it.Key()))
+	}
+	assert.Equal(t, []string{"2", "9", "10", "100"}, keys)
+
+	val, found := list.Get([]byte("10"))
+	assert.True(t, found)
+	assert.Equal(t, []byte("v10"), val)
+}
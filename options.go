@@ -0,0 +1,363 @@
+package stratago
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/thomazdavis/stratago/comparator"
+	"github.com/thomazdavis/stratago/sstable"
+	"github.com/thomazdavis/stratago/wal"
+)
+
+const DefaultCompactionInterval = 10 * time.Second
+
+// CompactionStrategy selects how SSTables are merged in the background
+type CompactionStrategy int
+
+const (
+	// SizeTiered merges adjacent files of similar size. Every table stays in level 0.
+	SizeTiered CompactionStrategy = iota
+
+	// Leveled pushes flush output from level 0 into levels of non-overlapping
+	// tables, each level a fixed ratio larger than the one above it.
+	Leveled
+)
+
+func (s CompactionStrategy) String() string {
+	switch s {
+	case SizeTiered:
+		return "size-tiered"
+	case Leveled:
+		return "leveled"
+	}
+	return fmt.Sprintf("CompactionStrategy(%d)", int(s))
+}
+
+// Leveled compaction defaults
+const (
+	DefaultL0CompactionTrigger = 4
+	DefaultLevelSizeRatio      = 10
+	DefaultBaseLevelSize       = 10 * 1024 * 1024 // 10MB in level 1
+	DefaultMaxLevels           = 7
+	DefaultTargetFileSize      = 2 * 1024 * 1024 // 2MB per output table
+)
+
+const DefaultBlockCacheSize = 8 * 1024 * 1024 // 8MB
+
+// SyncMode selects when WAL writes are forced to stable storage
+type SyncMode int
+
+const (
+	// SyncAlways fsyncs the WAL before every write returns
+	SyncAlways SyncMode = iota
+
+	// SyncPeriodic fsyncs the WAL every SyncInterval in the background.
+	// A crash may lose the writes of the last interval.
+	SyncPeriodic
+
+	// SyncNever leaves flushing the WAL to the OS. A machine crash may lose
+	// any write not yet flushed to an SSTable, but a process crash loses nothing.
+	SyncNever
+)
+
+func (m SyncMode) String() string {
+	switch m {
+	case SyncAlways:
+		return "always"
+	case SyncPeriodic:
+		return "periodic"
+	case SyncNever:
+		return "never"
+	}
+	return fmt.Sprintf("SyncMode(%d)", int(m))
+}
+
+const DefaultSyncInterval = 100 * time.Millisecond
+
+const DefaultWALSegmentSize = 4 * 1024 * 1024 // 4MB
+
+// DefaultTierBounds are the exclusive upper file sizes of each size tier.
+// Files bigger than the last bound fall into a final, unbounded tier.
+var DefaultTierBounds = []int64{
+	10 * 1024 * 1024,   // Tier 0: < 10MB
+	50 * 1024 * 1024,   // Tier 1: 10MB - 50MB
+	250 * 1024 * 1024,  // Tier 2: 50MB - 250MB
+	1024 * 1024 * 1024, // Tier 3: 250MB - 1GB
+}
+
+// Options holds the tunables of a StrataGo instance.
+// Zero-valued fields are replaced with their defaults when the DB is opened.
+type Options struct {
+	// MemtableThreshold is the active memtable size in bytes that triggers a flush
+	MemtableThreshold int64
+
+	// CompactionThreshold is the number of adjacent same-tier SSTables merged at once
+	CompactionThreshold int
+
+	// CompactionInterval is how often the background worker looks for compaction work
+	CompactionInterval time.Duration
+
+	// TierBounds are the ascending, exclusive upper file sizes of each size tier
+	TierBounds []int64
+
+	// IndexInterval is the target size in bytes of an SSTable data block, each of which gets one index entry
+	IndexInterval int
+
+	// CompactionStrategy picks size-tiered (the default) or leveled compaction
+	CompactionStrategy CompactionStrategy
+
+	// L0CompactionTrigger is the number of level 0 tables that starts a leveled compaction
+	L0CompactionTrigger int
+
+	// LevelSizeRatio is how many times larger each level is than the one above it
+	LevelSizeRatio int
+
+	// BaseLevelSize is the target total size of level 1 in bytes
+	BaseLevelSize int64
+
+	// MaxLevels is the number of levels, including level 0
+	MaxLevels int
+
+	// TargetFileSize is the approximate size of each table written by a leveled compaction
+	TargetFileSize int64
+
+	// BloomBitsPerKey is the Bloom filter size per key in each SSTable. Negative disables filters.
+	BloomBitsPerKey int
+
+	// BlockCacheSize is the capacity in bytes of the DB's SSTable block cache. Negative disables it.
+	BlockCacheSize int64
+
+	// BlockCache is used instead of a private cache when set, letting several DBs share one
+	BlockCache *sstable.BlockCache
+
+	// Compression is the codec new SSTable blocks are written with. Tables rewritten
+	// by compaction pick up the current codec, whatever they were written with.
+	Compression sstable.Compression
+
+	// CompactionFilter, if set, is asked to keep, remove or rewrite each value
+	// compactions write out. Flushes write the memtable as is.
+	CompactionFilter sstable.CompactionFilter
+
+	// MergeOperator combines the operands written with Merge with the value below them,
+	// on reads and in compactions. Flushes write operands as they are. It is required
+	// by Merge, and must be able to read every operand already written.
+	MergeOperator sstable.MergeOperator
+
+	// SyncMode picks when WAL writes are fsynced: always (the default), periodically or never
+	SyncMode SyncMode
+
+	// SyncInterval is how often the WAL is fsynced in SyncPeriodic mode
+	SyncInterval time.Duration
+
+	// WALSegmentSize is the size in bytes past which writes roll over to a new WAL segment
+	WALSegmentSize int64
+
+	// WALRecoveryMode decides what Open does with damaged WAL records: discard a torn
+	// tail only (the default), fail on any damage, or skip every damaged record
+	WALRecoveryMode wal.RecoveryMode
+
+	// Comparator orders keys, bytewise by default. Its name is recorded in every table
+	// and in the manifest, and the DB must always be reopened with the same comparator.
+	Comparator comparator.Comparator
+
+	// ColumnFamilies holds, by name, the options Open reopens column families with.
+	// Families without an entry use the DB's options. The WAL, sync, block cache,
+	// comparator and compaction interval settings belong to the whole DB and are ignored here.
+	ColumnFamilies map[string]*Options
+}
+
+// WriteOptions controls the durability of a single write
+type WriteOptions struct {
+	// Sync fsyncs the WAL before the write returns, whatever the SyncMode
+	Sync bool
+
+	// DisableWAL skips the WAL. The write only becomes durable once its
+	// memtable is flushed, and is lost if the process crashes before that.
+	DisableWAL bool
+}
+
+// ReadOptions controls a single point lookup
+type ReadOptions struct {
+	// Snapshot reads the DB as of the snapshot instead of its latest state
+	Snapshot *Snapshot
+}
+
+// DefaultOptions returns the options used by Open
+func DefaultOptions() *Options {
+	return &Options{
+		MemtableThreshold:   DefaultMemtableThreshold,
+		CompactionThreshold: CompactionThreshold,
+		CompactionInterval:  DefaultCompactionInterval,
+		TierBounds:          append([]int64{}, DefaultTierBounds...),
+		IndexInterval:       sstable.IndexInterval,
+		CompactionStrategy:  SizeTiered,
+		L0CompactionTrigger: DefaultL0CompactionTrigger,
+		LevelSizeRatio:      DefaultLevelSizeRatio,
+		BaseLevelSize:       DefaultBaseLevelSize,
+		MaxLevels:           DefaultMaxLevels,
+		TargetFileSize:      DefaultTargetFileSize,
+		BloomBitsPerKey:     sstable.DefaultBloomBitsPerKey,
+		BlockCacheSize:      DefaultBlockCacheSize,
+		SyncMode:            SyncAlways,
+		SyncInterval:        DefaultSyncInterval,
+		WALSegmentSize:      DefaultWALSegmentSize,
+		WALRecoveryMode:     wal.TolerateCorruptedTail,
+		Comparator:          comparator.Bytewise,
+	}
+}
+
+// withDefaults returns a copy of opts with every unset field filled in
+func (opts *Options) withDefaults() *Options {
+	res := DefaultOptions()
+	if opts == nil {
+		return res
+	}
+	if opts.MemtableThreshold != 0 {
+		res.MemtableThreshold = opts.MemtableThreshold
+	}
+	if opts.CompactionThreshold != 0 {
+		res.CompactionThreshold = opts.CompactionThreshold
+	}
+	if opts.CompactionInterval != 0 {
+		res.CompactionInterval = opts.CompactionInterval
+	}
+	if opts.TierBounds != nil {
+		res.TierBounds = append([]int64{}, opts.TierBounds...)
+	}
+	if opts.IndexInterval != 0 {
+		res.IndexInterval = opts.IndexInterval
+	}
+	res.CompactionStrategy = opts.CompactionStrategy
+	if opts.L0CompactionTrigger != 0 {
+		res.L0CompactionTrigger = opts.L0CompactionTrigger
+	}
+	if opts.LevelSizeRatio != 0 {
+		res.LevelSizeRatio = opts.LevelSizeRatio
+	}
+	if opts.BaseLevelSize != 0 {
+		res.BaseLevelSize = opts.BaseLevelSize
+	}
+	if opts.MaxLevels != 0 {
+		res.MaxLevels = opts.MaxLevels
+	}
+	if opts.TargetFileSize != 0 {
+		res.TargetFileSize = opts.TargetFileSize
+	}
+	if opts.BloomBitsPerKey != 0 {
+		res.BloomBitsPerKey = opts.BloomBitsPerKey
+	}
+	if opts.BlockCacheSize != 0 {
+		res.BlockCacheSize = opts.BlockCacheSize
+	}
+	res.BlockCache = opts.BlockCache
+	res.Compression = opts.Compression
+	res.CompactionFilter = opts.CompactionFilter
+	res.MergeOperator = opts.MergeOperator
+	res.SyncMode = opts.SyncMode
+	if opts.SyncInterval != 0 {
+		res.SyncInterval = opts.SyncInterval
+	}
+	if opts.WALSegmentSize != 0 {
+		res.WALSegmentSize = opts.WALSegmentSize
+	}
+	res.WALRecoveryMode = opts.WALRecoveryMode
+	if opts.Comparator != nil {
+		res.Comparator = opts.Comparator
+	}
+	res.ColumnFamilies = opts.ColumnFamilies
+	return res
+}
+
+// validate rejects option combinations the engine cannot run with
+func (opts *Options) validate() error {
+	if opts.MemtableThreshold <= 0 {
+		return fmt.Errorf("invalid options: MemtableThreshold must be positive, got %d", opts.MemtableThreshold)
+	}
+	if opts.CompactionThreshold < 2 {
+		return fmt.Errorf("invalid options: CompactionThreshold must be at least 2, got %d", opts.CompactionThreshold)
+	}
+	if opts.CompactionInterval <= 0 {
+		return fmt.Errorf("invalid options: CompactionInterval must be positive, got %v", opts.CompactionInterval)
+	}
+	for i, bound := range opts.TierBounds {
+		if bound <= 0 {
+			return fmt.Errorf("invalid options: TierBounds[%d] must be positive, got %d", i, bound)
+		}
+		if i > 0 && bound <= opts.TierBounds[i-1] {
+			return fmt.Errorf("invalid options: TierBounds must be strictly ascending")
+		}
+	}
+	if opts.IndexInterval <= 0 {
+		return fmt.Errorf("invalid options: IndexInterval must be positive, got %d", opts.IndexInterval)
+	}
+	if opts.CompactionStrategy != SizeTiered && opts.CompactionStrategy != Leveled {
+		return fmt.Errorf("invalid options: unknown CompactionStrategy %d", int(opts.CompactionStrategy))
+	}
+	if opts.L0CompactionTrigger < 1 {
+		return fmt.Errorf("invalid options: L0CompactionTrigger must be positive, got %d", opts.L0CompactionTrigger)
+	}
+	if opts.LevelSizeRatio < 2 {
+		return fmt.Errorf("invalid options: LevelSizeRatio must be at least 2, got %d", opts.LevelSizeRatio)
+	}
+	if opts.BaseLevelSize <= 0 {
+		return fmt.Errorf("invalid options: BaseLevelSize must be positive, got %d", opts.BaseLevelSize)
+	}
+	if opts.MaxLevels < 2 {
+		return fmt.Errorf("invalid options: MaxLevels must be at least 2, got %d", opts.MaxLevels)
+	}
+	if opts.TargetFileSize <= 0 {
+		return fmt.Errorf("invalid options: TargetFileSize must be positive, got %d", opts.TargetFileSize)
+	}
+	if !opts.Compression.Valid() {
+		return fmt.Errorf("invalid options: unknown Compression %d", uint8(opts.Compression))
+	}
+	if opts.SyncMode != SyncAlways && opts.SyncMode != SyncPeriodic && opts.SyncMode != SyncNever {
+		return fmt.Errorf("invalid options: unknown SyncMode %d", int(opts.SyncMode))
+	}
+	if opts.SyncInterval <= 0 {
+		return fmt.Errorf("invalid options: SyncInterval must be positive, got %v", opts.SyncInterval)
+	}
+	if opts.WALSegmentSize <= 0 {
+		return fmt.Errorf("invalid options: WALSegmentSize must be positive, got %d", opts.WALSegmentSize)
+	}
+	if !opts.WALRecoveryMode.Valid() {
+		return fmt.Errorf("invalid options: unknown WALRecoveryMode %d", int(opts.WALRecoveryMode))
+	}
+	for name, cfOpts := range opts.ColumnFamilies {
+		if err := cfOpts.withDefaults().validate(); err != nil {
+			return fmt.Errorf("column family %q: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// familyOptions returns the options the column family name is opened with
+func (opts *Options) familyOptions(name string) *Options {
+	if cfOpts, ok := opts.ColumnFamilies[name]; ok {
+		res := cfOpts.withDefaults()
+		res.Comparator = opts.Comparator
+		return res
+	}
+	return opts
+}
+
+// newBlockCache returns the block cache readers of the DB share, or nil if caching is disabled
+func (opts *Options) newBlockCache() *sstable.BlockCache {
+	if opts.BlockCache != nil {
+		return opts.BlockCache
+	}
+	if opts.BlockCacheSize < 0 {
+		return nil
+	}
+	return sstable.NewBlockCache(opts.BlockCacheSize)
+}
+
+// sstableOptions returns the builder options derived from the DB options
+func (opts *Options) sstableOptions() sstable.Options {
+	return sstable.Options{
+		IndexInterval:   opts.IndexInterval,
+		BloomBitsPerKey: max(opts.BloomBitsPerKey, 0),
+		Compression:     opts.Compression,
+		Comparator:      opts.Comparator,
+	}
+}
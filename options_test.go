@@ -0,0 +1,75 @@
+package stratago
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/thomazdavis/stratago/wal"
+)
+
+func TestOptions_Defaults(t *testing.T) {
+	opts := (&Options{MemtableThreshold: 512 * 1024}).withDefaults()
+
+	assert.Equal(t, int64(512*1024), opts.MemtableThreshold)
+	assert.Equal(t, CompactionThreshold, opts.CompactionThreshold)
+	assert.Equal(t, DefaultCompactionInterval, opts.CompactionInterval)
+	assert.Equal(t, DefaultTierBounds, opts.TierBounds)
+	assert.NoError(t, opts.validate())
+
+	assert.Equal(t, DefaultOptions(), (*Options)(nil).withDefaults())
+}
+
+func TestOptions_Validation(t *testing.T) {
+	dataDir := "test_options_invalid"
+	defer os.RemoveAll(dataDir)
+
+	invalid := []*Options{
+		{MemtableThreshold: -1},
+		{CompactionThreshold: 1},
+		{CompactionInterval: -time.Second},
+		{TierBounds: []int64{50, 10}},
+		{TierBounds: []int64{0}},
+		{IndexInterval: -5},
+		{SyncMode: SyncMode(7)},
+		{SyncInterval: -time.Millisecond},
+		{WALSegmentSize: -1},
+		{WALRecoveryMode: wal.RecoveryMode(9)},
+	}
+
+	for _, opts := range invalid {
+		db, err := OpenWithOptions(dataDir, opts)
+		assert.Error(t, err, "Options %+v should be rejected", opts)
+		assert.Nil(t, db)
+	}
+}
+
+func TestOptions_SmallMemtableThreshold(t *testing.T) {
+	dataDir := "test_options_small_memtable"
+	defer os.RemoveAll(dataDir)
+
+	db, err := OpenWithOptions(dataDir, &Options{MemtableThreshold: 1024})
+	assert.NoError(t, err)
+	defer db.Close()
+
+	value := make([]byte, 256)
+	for i := range 8 {
+		db.Put([]byte{byte('a' + i)}, value)
+	}
+
+	assert.Eventually(t, func() bool {
+		db.mu.RLock()
+		defer db.mu.RUnlock()
+		return len(db.defaultCF.current.levels[0]) > 0
+	}, 5*time.Second, 50*time.Millisecond)
+}
+
+func TestGetTier_CustomBounds(t *testing.T) {
+	bounds := []int64{100, 1000}
+
+	assert.Equal(t, 0, getTier(99, bounds))
+	assert.Equal(t, 1, getTier(100, bounds))
+	assert.Equal(t, 2, getTier(5000, bounds))
+	assert.Equal(t, 0, getTier(5000, nil), "No bounds means a single tier")
+}
@@ -0,0 +1,154 @@
+package stratago
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/thomazdavis/stratago/manifest"
+	"github.com/thomazdavis/stratago/memtable"
+	"github.com/thomazdavis/stratago/wal"
+)
+
+// legacyLogs are the WAL files written before the log was segmented, oldest first
+var legacyLogs = []string{"wal.log.flushing", "wal.log"}
+
+// logFileName returns the name of the WAL segment with the given file number
+func logFileName(number uint64) string {
+	return fmt.Sprintf("%06d.log", number)
+}
+
+// parseLogFileName returns the file number of a WAL segment name, or false if it is not one
+func parseLogFileName(name string) (uint64, bool) {
+	var number uint64
+	if filepath.Ext(name) != ".log" {
+		return 0, false
+	}
+	if n, _ := fmt.Sscanf(name, "%d.log", &number); n != 1 {
+		return 0, false
+	}
+	return number, true
+}
+
+// listLogs returns the file numbers of the WAL segments in dataDir in ascending order
+func listLogs(dataDir string) ([]uint64, error) {
+	files, err := os.ReadDir(dataDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read data directory: %w", err)
+	}
+	var numbers []uint64
+	for _, f := range files {
+		if number, ok := parseLogFileName(f.Name()); ok {
+			numbers = append(numbers, number)
+		}
+	}
+	sort.Slice(numbers, func(i, j int) bool { return numbers[i] < numbers[j] })
+	return numbers, nil
+}
+
+// recoverLogs replays every WAL segment that may hold unflushed writes into the
+// memtable of their column family in mems, oldest first, and returns their file
+// numbers, the highest sequence number they hold and what was discarded as damaged.
+// Logs written before segmentation are renamed into segments first.
+func recoverLogs(dataDir string, m *manifest.Manifest, mems map[uint32]*memtable.SkipList, mode wal.RecoveryMode) ([]uint64, uint64, wal.RecoveryReport, error) {
+	var report wal.RecoveryReport
+	numbers, err := listLogs(dataDir)
+	if err != nil {
+		return nil, 0, report, err
+	}
+
+	// Segment numbers are reserved without an edit, so a crash may leave
+	// segments the manifest has never heard of
+	if len(numbers) > 0 {
+		m.MarkFileNumberUsed(numbers[len(numbers)-1])
+	}
+	for _, name := range legacyLogs {
+		path := filepath.Join(dataDir, name)
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		number := m.NewFileNumber()
+		if err := os.Rename(path, filepath.Join(dataDir, logFileName(number))); err != nil {
+			return nil, 0, report, fmt.Errorf("failed to adopt %s: %w", name, err)
+		}
+		numbers = append(numbers, number)
+	}
+
+	// Each family has flushed the segments below its own log number
+	logNumbers := map[uint32]uint64{0: m.LogNumber()}
+	for _, f := range m.Families() {
+		logNumbers[f.ID] = f.LogNumber
+	}
+
+	var live []uint64
+	var lastSeq uint64
+	for _, number := range numbers {
+		if number < m.MinLogNumber() {
+			continue // Already flushed
+		}
+		seq, discarded, err := replayLog(filepath.Join(dataDir, logFileName(number)), number, mems, logNumbers, mode)
+		if err != nil {
+			return nil, 0, report, fmt.Errorf("failed to replay WAL segment %d: %w", number, err)
+		}
+		live = append(live, number)
+		lastSeq = max(lastSeq, seq)
+		report.Add(discarded)
+	}
+	return live, lastSeq, report, nil
+}
+
+// replayLog adds the writes of WAL segment number to the memtables of their families
+// in log order and returns the highest sequence number it holds. Every entry keeps
+// its own sequence number, so the newest write of a key wins wherever it sits in the
+// log. Writes of dropped families, or of families that flushed the segment, are skipped.
+func replayLog(path string, number uint64, mems map[uint32]*memtable.SkipList, logNumbers map[uint32]uint64, mode wal.RecoveryMode) (uint64, wal.RecoveryReport, error) {
+	r, err := wal.NewReaderWithOptions(path, wal.ReaderOptions{Mode: mode})
+	if err != nil {
+		return 0, wal.RecoveryReport{}, err
+	}
+	defer r.Close()
+
+	var lastSeq uint64
+	for r.Next() {
+		lastSeq = max(lastSeq, r.Seq())
+		mem, ok := mems[r.Family()]
+		if !ok || number < logNumbers[r.Family()] {
+			continue
+		}
+		mem.Add(r.Key(), r.Seq(), r.Kind(), r.Value())
+	}
+	return lastSeq, r.Report(), r.Error()
+}
+
+// removeLogs deletes the WAL segments numbered below logNumber, whose writes are all in tables
+func removeLogs(dataDir string, logNumber uint64) {
+	numbers, err := listLogs(dataDir)
+	if err != nil {
+		return
+	}
+	for _, number := range numbers {
+		if number < logNumber {
+			os.Remove(filepath.Join(dataDir, logFileName(number)))
+		}
+	}
+}
+
+// switchLog closes the current WAL segment and directs new writes to a fresh one,
+// returning its file number. Callers must hold db.writeMu and db.mu.
+func (db *StrataGo) switchLog() (uint64, error) {
+	number := db.manifest.NewFileNumber()
+	newWal, err := wal.NewWAL(filepath.Join(db.dataDir, logFileName(number)))
+	if err != nil {
+		return 0, fmt.Errorf("failed to create WAL segment: %w", err)
+	}
+
+	oldWAL := db.wal
+	db.wal = newWal
+
+	// Closing syncs whatever SyncPeriodic, SyncNever or unsynced writes left behind
+	if err := oldWAL.Close(); err != nil {
+		return number, fmt.Errorf("failed to close WAL segment %s: %w", oldWAL.Path(), err)
+	}
+	return number, nil
+}
@@ -0,0 +1,235 @@
+package stratago
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/thomazdavis/stratago/kind"
+	"github.com/thomazdavis/stratago/wal"
+)
+
+func TestParseLogFileName(t *testing.T) {
+	number, ok := parseLogFileName(logFileName(42))
+	assert.True(t, ok)
+	assert.Equal(t, uint64(42), number)
+
+	for _, name := range []string{"wal.log", "wal.log.flushing", "000042.sst", "000042.log.upgrade"} {
+		_, ok := parseLogFileName(name)
+		assert.False(t, ok, name)
+	}
+}
+
+func TestWAL_RollsOverSegments(t *testing.T) {
+	dataDir := "test_wal_segments"
+	defer os.RemoveAll(dataDir)
+
+	opts := &Options{WALSegmentSize: 512}
+	db, err := OpenWithOptions(dataDir, opts)
+	assert.NoError(t, err)
+
+	for i := range 100 {
+		assert.NoError(t, db.Put(fmt.Appendf(nil, "key%03d", i), fmt.Appendf(nil, "val%d", i)))
+	}
+	db.Delete([]byte("key000"))
+
+	segments, _ := listLogs(dataDir)
+	assert.Greater(t, len(segments), 3, "Writes should span several segments")
+	for _, number := range segments[:len(segments)-1] {
+		stat, err := os.Stat(filepath.Join(dataDir, logFileName(number)))
+		assert.NoError(t, err)
+		assert.Less(t, stat.Size(), int64(1024), "Only the last group may overflow a segment")
+	}
+	logged, err := db.GetWALContents()
+	assert.NoError(t, err)
+	assert.Len(t, logged, 100, "Every live segment is read")
+	assert.Nil(t, logged["key000"])
+	assert.Equal(t, []byte("val99"), logged["key099"])
+
+	// Crash before a flush, recovery replays every segment in order
+	db.wal.Close()
+	db, err = OpenWithOptions(dataDir, opts)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	_, found := db.Get([]byte("key000"))
+	assert.False(t, found)
+	for i := 1; i < 100; i++ {
+		val, found := db.Get(fmt.Appendf(nil, "key%03d", i))
+		assert.True(t, found)
+		assert.Equal(t, fmt.Appendf(nil, "val%d", i), val)
+	}
+	assert.Equal(t, uint64(101), db.lastSeq.Load())
+
+	// Once flushed, only the segment of the new memtable is left
+	assert.NoError(t, db.Flush())
+	remaining, _ := listLogs(dataDir)
+	assert.Equal(t, []uint64{db.defaultCF.logNumber}, remaining)
+	assert.Equal(t, db.defaultCF.logNumber, db.manifest.LogNumber())
+}
+
+func TestWAL_RollsOverAfterFailedWrite(t *testing.T) {
+	dataDir := "test_wal_failed_write"
+	defer os.RemoveAll(dataDir)
+
+	db, err := Open(dataDir)
+	assert.NoError(t, err)
+	assert.NoError(t, db.Put([]byte("a"), []byte("1")))
+
+	// The segment stops accepting writes, and the next write moves to a fresh one
+	failed := db.GetWAL()
+	failed.Close()
+	assert.Error(t, db.Put([]byte("b"), []byte("2")))
+	assert.NoError(t, db.Put([]byte("c"), []byte("3")))
+	assert.NotEqual(t, failed.Path(), db.GetWAL().Path())
+
+	db.wal.Close()
+	db, err = Open(dataDir)
+	assert.NoError(t, err)
+	defer db.Close()
+	val, _ := db.Get([]byte("a"))
+	assert.Equal(t, []byte("1"), val)
+	_, found := db.Get([]byte("b"))
+	assert.False(t, found)
+	val, _ = db.Get([]byte("c"))
+	assert.Equal(t, []byte("3"), val)
+}
+
+func TestWAL_FlushedSegmentsAreNotReplayed(t *testing.T) {
+	dataDir := "test_wal_segments_flushed"
+	defer os.RemoveAll(dataDir)
+
+	db, err := Open(dataDir)
+	assert.NoError(t, err)
+	db.Put([]byte("k"), []byte("old"))
+	flushed := db.GetWAL().Path()
+	assert.NoError(t, db.Flush())
+	db.Put([]byte("k"), []byte("new"))
+
+	// A segment the flush could not delete before a crash holds only flushed writes
+	assert.NoError(t, os.WriteFile(flushed, nil, 0644))
+	w, err := wal.NewWAL(flushed)
+	assert.NoError(t, err)
+	assert.NoError(t, w.Append(100, []wal.Entry{{Kind: kind.Value, Key: []byte("k"), Value: []byte("old")}}, true))
+	w.Close()
+
+	db.wal.Close()
+	db, err = Open(dataDir)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	val, _ := db.Get([]byte("k"))
+	assert.Equal(t, []byte("new"), val)
+	_, err = os.Stat(flushed)
+	assert.True(t, os.IsNotExist(err), "Flushed segments are removed on open")
+}
+
+func TestOpen_AdoptsLegacyLogs(t *testing.T) {
+	dataDir := "test_legacy_logs"
+	defer os.RemoveAll(dataDir)
+	os.MkdirAll(dataDir, 0755)
+
+	// A crash mid-flush left both logs of the old layout behind
+	write := func(name string, seq uint64, key, value string) {
+		w, err := wal.NewWAL(filepath.Join(dataDir, name))
+		assert.NoError(t, err)
+		assert.NoError(t, w.Append(seq, []wal.Entry{{Kind: kind.Value, Key: []byte(key), Value: []byte(value)}}, true))
+		assert.NoError(t, w.Close())
+	}
+	write("wal.log.flushing", 1, "a", "old")
+	write("wal.log", 2, "a", "new")
+	write("wal.log", 3, "b", "only")
+
+	db, err := Open(dataDir)
+	assert.NoError(t, err)
+
+	val, _ := db.Get([]byte("a"))
+	assert.Equal(t, []byte("new"), val)
+	val, _ = db.Get([]byte("b"))
+	assert.Equal(t, []byte("only"), val)
+	assert.Equal(t, uint64(3), db.lastSeq.Load())
+
+	for _, name := range legacyLogs {
+		_, err := os.Stat(filepath.Join(dataDir, name))
+		assert.True(t, os.IsNotExist(err), "%s should be adopted", name)
+	}
+
+	// The adopted segments survive a crash until they are flushed
+	db.wal.Close()
+	db, err = Open(dataDir)
+	assert.NoError(t, err)
+	defer db.Close()
+	val, _ = db.Get([]byte("a"))
+	assert.Equal(t, []byte("new"), val)
+}
+
+func TestOpen_ReplayKeepsSequenceNumbers(t *testing.T) {
+	dataDir := "test_wal_replay_seq"
+	defer os.RemoveAll(dataDir)
+
+	db, err := Open(dataDir)
+	assert.NoError(t, err)
+	db.Put([]byte("k"), []byte("v1"))
+	db.Put([]byte("j"), []byte("v"))
+	db.Put([]byte("k"), []byte("v2"))
+	db.Delete([]byte("j"))
+
+	db.wal.Close()
+	db, err = Open(dataDir)
+	assert.NoError(t, err)
+	defer db.Close()
+	assert.Equal(t, uint64(4), db.lastSeq.Load())
+
+	// Each write is replayed under its own sequence number, so older versions stay readable
+	val, _, _ := db.get(db.defaultCF, []byte("k"), 1)
+	assert.Equal(t, []byte("v1"), val)
+	_, found, _ := db.get(db.defaultCF, []byte("j"), 1)
+	assert.False(t, found)
+	val, _, _ = db.get(db.defaultCF, []byte("j"), 3)
+	assert.Equal(t, []byte("v"), val)
+	val, _ = db.Get([]byte("k"))
+	assert.Equal(t, []byte("v2"), val)
+	_, found = db.Get([]byte("j"))
+	assert.False(t, found)
+}
+
+func TestOpen_WALRecoveryModes(t *testing.T) {
+	dataDir := "test_wal_recovery_modes"
+	defer os.RemoveAll(dataDir)
+
+	db, err := Open(dataDir)
+	assert.NoError(t, err)
+	for _, key := range []string{"a", "b", "c"} {
+		db.Put([]byte(key), []byte("v"))
+	}
+	segment := db.GetWAL().Path()
+	db.wal.Close()
+
+	// Damage the value of the middle record
+	data, _ := os.ReadFile(segment)
+	data[len(data)-24] ^= 0xFF
+	assert.NoError(t, os.WriteFile(segment, data, 0644))
+
+	for _, mode := range []wal.RecoveryMode{wal.TolerateCorruptedTail, wal.AbsoluteConsistency} {
+		_, err := OpenWithOptions(dataDir, &Options{WALRecoveryMode: mode})
+		assert.ErrorIs(t, err, wal.ErrCorrupt, mode.String())
+	}
+
+	db, err = OpenWithOptions(dataDir, &Options{WALRecoveryMode: wal.SkipCorruptedRecords})
+	assert.NoError(t, err)
+	defer db.Close()
+
+	_, found := db.Get([]byte("b"))
+	assert.False(t, found)
+	for _, key := range []string{"a", "c"} {
+		_, found := db.Get([]byte(key))
+		assert.True(t, found, key)
+	}
+	report := db.WALRecoveryReport()
+	assert.Equal(t, 1, report.RecordsDiscarded)
+	assert.Equal(t, int64(23), report.BytesDiscarded)
+	assert.Len(t, report.Corruptions, 1)
+	assert.Equal(t, segment, report.Corruptions[0].Path)
+}
@@ -0,0 +1,79 @@
+package stratago
+
+import (
+	"sort"
+	"sync"
+)
+
+// Snapshot is a consistent, read-only view of the database as of the moment it was taken.
+// Writes made after the snapshot are invisible to it. Flush and compaction keep
+// every version a live snapshot can see, so snapshots must be released when done.
+type Snapshot struct {
+	db   *StrataGo
+	seq  uint64
+	once sync.Once
+}
+
+// NewSnapshot pins the current state of the database
+func (db *StrataGo) NewSnapshot() *Snapshot {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	seq := db.lastSeq.Load()
+	db.snapshots[seq]++
+	return &Snapshot{db: db, seq: seq}
+}
+
+// Sequence returns the sequence number of the last write visible to the snapshot
+func (s *Snapshot) Sequence() uint64 {
+	return s.seq
+}
+
+// Get returns the value of key as of the snapshot
+func (s *Snapshot) Get(key []byte) ([]byte, bool) {
+	return s.GetCF(s.db.defaultCF, key)
+}
+
+// GetCF returns the value of key in a column family as of the snapshot.
+// A nil, foreign or dropped family holds nothing.
+func (s *Snapshot) GetCF(cf *ColumnFamily, key []byte) ([]byte, bool) {
+	val, found, _ := s.db.get(cf, key, s.seq)
+	return val, found
+}
+
+// NewIterator returns an iterator over [lower, upper) as of the snapshot
+func (s *Snapshot) NewIterator(lower, upper []byte) (*Iterator, error) {
+	return s.db.newIterator(s.db.defaultCF, lower, upper, s.seq)
+}
+
+// NewIteratorCF returns an iterator over the keys of a column family in [lower, upper) as of
+// the snapshot. It fails if the family is not a live family of the DB.
+func (s *Snapshot) NewIteratorCF(cf *ColumnFamily, lower, upper []byte) (*Iterator, error) {
+	return s.db.newIterator(cf, lower, upper, s.seq)
+}
+
+// Release lets flush and compaction discard versions only this snapshot needed.
+// Calling Release more than once is a no-op.
+func (s *Snapshot) Release() {
+	s.once.Do(func() {
+		s.db.mu.Lock()
+		defer s.db.mu.Unlock()
+
+		if s.db.snapshots[s.seq]--; s.db.snapshots[s.seq] <= 0 {
+			delete(s.db.snapshots, s.seq)
+		}
+	})
+}
+
+// liveSnapshots returns the sorted sequence numbers of every unreleased snapshot
+func (db *StrataGo) liveSnapshots() []uint64 {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	seqs := make([]uint64, 0, len(db.snapshots))
+	for seq := range db.snapshots {
+		seqs = append(seqs, seq)
+	}
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+	return seqs
+}
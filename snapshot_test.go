@@ -0,0 +1,146 @@
+package stratago
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshot_ConsistentView(t *testing.T) {
+	dataDir := "test_snapshot"
+	defer os.RemoveAll(dataDir)
+
+	db, err := Open(dataDir)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	db.Put([]byte("a"), []byte("1"))
+	db.Put([]byte("b"), []byte("1"))
+
+	snap := db.NewSnapshot()
+	defer snap.Release()
+
+	db.Put([]byte("a"), []byte("2"))
+	db.Delete([]byte("b"))
+	db.Put([]byte("c"), []byte("2"))
+
+	// Latest view
+	val, found := db.Get([]byte("a"))
+	assert.True(t, found)
+	assert.Equal(t, []byte("2"), val)
+	_, found = db.Get([]byte("b"))
+	assert.False(t, found)
+
+	// Snapshot view
+	val, found = snap.Get([]byte("a"))
+	assert.True(t, found)
+	assert.Equal(t, []byte("1"), val)
+	val, found = snap.Get([]byte("b"))
+	assert.True(t, found)
+	assert.Equal(t, []byte("1"), val)
+	_, found = snap.Get([]byte("c"))
+	assert.False(t, found)
+	val, found, err = db.GetWithOptions([]byte("a"), &ReadOptions{Snapshot: snap})
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, []byte("1"), val)
+
+	it, err := snap.NewIterator(nil, nil)
+	assert.NoError(t, err)
+	defer it.Close()
+	assert.Equal(t, []string{"a", "b"}, collectKeys(t, it))
+}
+
+func TestSnapshot_SurvivesFlushAndCompaction(t *testing.T) {
+	dataDir := "test_snapshot_compaction"
+	defer os.RemoveAll(dataDir)
+
+	db, err := Open(dataDir)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	db.Put([]byte("key"), []byte("v1"))
+	assert.NoError(t, db.Flush())
+
+	snap := db.NewSnapshot()
+
+	// Overwrite the key in memtable and in enough SSTables to trigger a compaction
+	for _, v := range []string{"v2", "v3", "v4"} {
+		db.Put([]byte("key"), []byte(v))
+		assert.NoError(t, db.Flush())
+	}
+	assert.NoError(t, db.RunCompaction())
+
+	db.mu.RLock()
+	assert.Equal(t, 1, len(db.defaultCF.current.levels[0]))
+	db.mu.RUnlock()
+
+	val, found := snap.Get([]byte("key"))
+	assert.True(t, found)
+	assert.Equal(t, []byte("v1"), val, "Snapshot must still see the version it pinned")
+
+	val, found = db.Get([]byte("key"))
+	assert.True(t, found)
+	assert.Equal(t, []byte("v4"), val)
+
+	// Once released, compaction is free to drop the old version
+	snap.Release()
+	snap.Release()
+	assert.Empty(t, db.liveSnapshots())
+}
+
+func TestSnapshot_FlushKeepsPinnedVersions(t *testing.T) {
+	dataDir := "test_snapshot_flush"
+	defer os.RemoveAll(dataDir)
+
+	db, err := Open(dataDir)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	db.Put([]byte("key"), []byte("old"))
+	snap := db.NewSnapshot()
+	defer snap.Release()
+	db.Put([]byte("key"), []byte("new"))
+
+	// Both versions live in the same memtable when it is flushed
+	assert.NoError(t, db.Flush())
+
+	val, found := snap.Get([]byte("key"))
+	assert.True(t, found)
+	assert.Equal(t, []byte("old"), val)
+
+	val, found = db.Get([]byte("key"))
+	assert.True(t, found)
+	assert.Equal(t, []byte("new"), val)
+}
+
+func TestSequenceNumber_RestoredOnOpen(t *testing.T) {
+	dataDir := "test_sequence_restore"
+	defer os.RemoveAll(dataDir)
+
+	db, err := Open(dataDir)
+	assert.NoError(t, err)
+	db.Put([]byte("a"), []byte("1"))
+	db.Put([]byte("b"), []byte("1"))
+	db.Flush()
+	db.Put([]byte("c"), []byte("1"))
+	lastSeq := db.lastSeq.Load()
+	db.Close()
+
+	db2, err := Open(dataDir)
+	assert.NoError(t, err)
+	defer db2.Close()
+
+	assert.GreaterOrEqual(t, db2.lastSeq.Load(), lastSeq)
+
+	// A write after restart must shadow everything written before it
+	snap := db2.NewSnapshot()
+	defer snap.Release()
+	db2.Put([]byte("a"), []byte("2"))
+
+	val, _ := snap.Get([]byte("a"))
+	assert.Equal(t, []byte("1"), val)
+	val, _ = db2.Get([]byte("a"))
+	assert.Equal(t, []byte("2"), val)
+}
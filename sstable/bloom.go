@@ -0,0 +1,72 @@
+package sstable
+
+import (
+	"hash/fnv"
+	"math"
+)
+
+// DefaultBloomBitsPerKey gives a false positive rate of about 1%
+const DefaultBloomBitsPerKey = 10
+
+// bloomFilter is a Bloom filter over the distinct keys of a table.
+// Encoded as [Bits][NumProbes(1B)].
+type bloomFilter struct {
+	bits   []byte
+	probes uint32
+}
+
+// bloomHash returns the 64-bit hash a key is probed with
+func bloomHash(key []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(key)
+	return h.Sum64()
+}
+
+// buildBloomFilter returns the encoded filter for the given key hashes
+func buildBloomFilter(hashes []uint64, bitsPerKey int) []byte {
+	// ln(2) * bits per key probes minimizes the false positive rate
+	probes := uint32(math.Round(float64(bitsPerKey) * math.Ln2))
+	probes = max(1, min(probes, 30))
+
+	numBits := max(len(hashes)*bitsPerKey, 64)
+	numBytes := (numBits + 7) / 8
+	numBits = numBytes * 8
+
+	buf := make([]byte, numBytes+1)
+	for _, h := range hashes {
+		h1, h2 := uint32(h), uint32(h>>32)
+		for i := range probes {
+			bit := (h1 + i*h2) % uint32(numBits)
+			buf[bit/8] |= 1 << (bit % 8)
+		}
+	}
+	buf[numBytes] = byte(probes)
+	return buf
+}
+
+// decodeBloomFilter parses an encoded filter. It returns nil if the
+// block is malformed, in which case every lookup falls through to the data.
+func decodeBloomFilter(block []byte) *bloomFilter {
+	if len(block) < 2 {
+		return nil
+	}
+	probes := uint32(block[len(block)-1])
+	if probes == 0 || probes > 30 {
+		return nil
+	}
+	return &bloomFilter{bits: block[:len(block)-1], probes: probes}
+}
+
+// mayContain reports whether key may be in the table. False is definite.
+func (f *bloomFilter) mayContain(key []byte) bool {
+	h := bloomHash(key)
+	h1, h2 := uint32(h), uint32(h>>32)
+	numBits := uint32(len(f.bits) * 8)
+	for i := range f.probes {
+		bit := (h1 + i*h2) % numBits
+		if f.bits[bit/8]&(1<<(bit%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,83 @@
+package sstable
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/thomazdavis/stratago/kind"
+)
+
+func TestBloomFilter_FalsePositiveRate(t *testing.T) {
+	var hashes []uint64
+	for i := range 10000 {
+		hashes = append(hashes, bloomHash(fmt.Appendf(nil, "key%d", i)))
+	}
+	filter := decodeBloomFilter(buildBloomFilter(hashes, DefaultBloomBitsPerKey))
+	assert.NotNil(t, filter)
+
+	for i := range 10000 {
+		assert.True(t, filter.mayContain(fmt.Appendf(nil, "key%d", i)), "False negative for key%d", i)
+	}
+
+	falsePositives := 0
+	for i := range 10000 {
+		if filter.mayContain(fmt.Appendf(nil, "missing%d", i)) {
+			falsePositives++
+		}
+	}
+	assert.Less(t, falsePositives, 300, "Expected roughly 1%% false positives")
+}
+
+func TestReader_BloomFilterSkipsMissingKeys(t *testing.T) {
+	filename := "test_bloom.sst"
+	defer os.Remove(filename)
+
+	builder, err := NewBuilder(filename)
+	assert.NoError(t, err)
+	for i := range 500 {
+		assert.NoError(t, builder.Add(fmt.Appendf(nil, "key%03d", i), uint64(i+1), kind.Value, []byte("v")))
+	}
+	assert.NoError(t, builder.Finish())
+
+	reader, err := NewReader(filename)
+	assert.NoError(t, err)
+	defer reader.Close()
+	assert.NotNil(t, reader.filter)
+
+	for i := range 500 {
+		_, found := reader.Get(fmt.Appendf(nil, "key%03d", i))
+		assert.True(t, found)
+	}
+
+	skipped := 0
+	for i := range 500 {
+		if !reader.MayContain(fmt.Appendf(nil, "nope%03d", i)) {
+			skipped++
+		}
+		_, found := reader.Get(fmt.Appendf(nil, "nope%03d", i))
+		assert.False(t, found)
+	}
+	assert.Greater(t, skipped, 450)
+}
+
+func TestReader_NoBloomFilter(t *testing.T) {
+	filename := "test_no_bloom.sst"
+	defer os.Remove(filename)
+
+	builder, err := NewBuilderWithOptions(filename, Options{IndexInterval: IndexInterval})
+	assert.NoError(t, err)
+	assert.NoError(t, builder.Add([]byte("a"), 1, kind.Value, []byte("1")))
+	assert.NoError(t, builder.Finish())
+
+	reader, err := NewReader(filename)
+	assert.NoError(t, err)
+	defer reader.Close()
+
+	assert.Nil(t, reader.filter)
+	assert.True(t, reader.MayContain([]byte("zzz")))
+	val, found := reader.Get([]byte("a"))
+	assert.True(t, found)
+	assert.Equal(t, []byte("1"), val)
+}
@@ -1,19 +1,51 @@
 package sstable
 
 import (
+	"bytes"
 	"encoding/binary"
 	"fmt"
 	"os"
+	"path/filepath"
 	"time"
 
+	"github.com/thomazdavis/stratago/comparator"
+	"github.com/thomazdavis/stratago/kind"
 	"github.com/thomazdavis/stratago/memtable"
 )
 
 const IndexInterval = 1024
 
+// Options controls the layout of the SSTables written by a Builder
+type Options struct {
+	// IndexInterval is the target size of a data block in bytes.
+	// The index holds one entry per block.
+	IndexInterval int
+
+	// BloomBitsPerKey is the size of the Bloom filter per distinct key.
+	// Zero writes no filter.
+	BloomBitsPerKey int
+
+	// Compression is the codec data blocks are written with
+	Compression Compression
+
+	// Comparator is the order the entries are added in. Its name is recorded in
+	// the table, which only opens with the same comparator. Nil means Bytewise.
+	Comparator comparator.Comparator
+}
+
+// DefaultOptions returns the options used by NewBuilder
+func DefaultOptions() Options {
+	return Options{
+		IndexInterval:   IndexInterval,
+		BloomBitsPerKey: DefaultBloomBitsPerKey,
+	}
+}
+
+// IndexEntry is the handle of a data block: its first key, offset and size without the trailer
 type IndexEntry struct {
 	Key    []byte
 	Offset int64
+	Size   int64
 }
 
 type Builder struct {
@@ -21,11 +53,30 @@ type Builder struct {
 	tmpFilename   string
 	finalFilename string
 	index         []IndexEntry
+	block         []byte // Entries of the data block being filled
 	bytesWritten  int64
-	lastIndexPos  int64
+	maxSeq        uint64
+	keyHashes     []uint64 // One per distinct key, for the Bloom filter
+	lastKey       []byte
+	opts          Options
 }
 
 func NewBuilder(filename string) (*Builder, error) {
+	return NewBuilderWithOptions(filename, DefaultOptions())
+}
+
+// NewBuilderWithOptions creates a Builder that writes with the given layout options
+func NewBuilderWithOptions(filename string, opts Options) (*Builder, error) {
+	if opts.IndexInterval <= 0 {
+		return nil, fmt.Errorf("invalid index interval: %d", opts.IndexInterval)
+	}
+	if opts.BloomBitsPerKey < 0 {
+		return nil, fmt.Errorf("invalid bloom bits per key: %d", opts.BloomBitsPerKey)
+	}
+	if !opts.Compression.Valid() {
+		return nil, fmt.Errorf("invalid compression: %v", opts.Compression)
+	}
+
 	tmpFilename := fmt.Sprintf("%s.tmp.%d", filename, time.Now().UnixNano())
 
 	file, err := os.Create(tmpFilename)
@@ -37,58 +88,103 @@ func NewBuilder(filename string) (*Builder, error) {
 		tmpFilename:   tmpFilename,
 		finalFilename: filename,
 		index:         make([]IndexEntry, 0),
+		opts:          opts,
 	}, nil
 }
 
-// Add inserts a single key-value pair into the SSTable. Keys MUST be inserted in sorted order.
-func (b *Builder) Add(key, val []byte) error {
-	startOffset := b.bytesWritten
-
-	if startOffset == 0 || startOffset-b.lastIndexPos >= IndexInterval {
-		keyCopy := make([]byte, len(key))
-		copy(keyCopy, key)
-
+// Add inserts a single versioned entry into the SSTable.
+// Entries MUST be inserted sorted by key, and by descending sequence number within a key.
+// Format: [Key Size (4B)] [Val Size (4B)] [Seq (8B)] [Kind (1B)] [Key Bytes] [Value Bytes]
+func (b *Builder) Add(key []byte, seq uint64, k kind.Kind, val []byte) error {
+	if len(b.block) == 0 {
 		b.index = append(b.index, IndexEntry{
-			Key:    keyCopy,
-			Offset: startOffset,
+			Key:    append([]byte{}, key...),
+			Offset: b.bytesWritten,
 		})
-		b.lastIndexPos = startOffset
 	}
 
-	// Write Key Size (4 bytes)
-	if err := binary.Write(b.file, binary.LittleEndian, uint32(len(key))); err != nil {
-		return err
+	first := b.bytesWritten == 0 && len(b.block) == 0
+	b.block = append(b.block, encodeEntryHeader(len(key), len(val), seq, k)...)
+	b.block = append(b.block, key...)
+	b.block = append(b.block, val...)
+
+	if seq > b.maxSeq {
+		b.maxSeq = seq
+	}
+	if b.opts.BloomBitsPerKey > 0 && (first || !bytes.Equal(key, b.lastKey)) {
+		b.keyHashes = append(b.keyHashes, bloomHash(key))
+		b.lastKey = append(b.lastKey[:0], key...)
 	}
 
-	// Write Value Size (4 bytes)
-	if err := binary.Write(b.file, binary.LittleEndian, uint32(len(val))); err != nil {
-		return err
+	// Blocks are cut after the entry that fills them, so an entry never spans two blocks
+	if len(b.block) >= b.opts.IndexInterval {
+		return b.flushBlock()
 	}
+	return nil
+}
 
-	// Write Key Bytes
-	if _, err := b.file.Write(key); err != nil {
-		return err
+// flushBlock compresses the pending data block and writes it followed by its codec and checksum
+func (b *Builder) flushBlock() error {
+	if len(b.block) == 0 {
+		return nil
 	}
-	if _, err := b.file.Write(val); err != nil {
+
+	codec := b.opts.Compression
+	payload, err := compressBlock(codec, b.block)
+	if err != nil {
 		return err
 	}
+	if len(payload) >= len(b.block) {
+		// Incompressible data is cheaper to read back as is
+		codec, payload = NoCompression, b.block
+	}
+	b.index[len(b.index)-1].Size = int64(len(payload))
 
-	b.bytesWritten += int64(8 + len(key) + len(val))
+	block := appendBlockTrailer(append(append([]byte{}, payload...), byte(codec)))
+	if _, err := b.file.Write(block); err != nil {
+		return err
+	}
+	b.bytesWritten += int64(len(block))
+	b.block = b.block[:0]
 	return nil
 }
 
 // Finish writes the index and footer, then closes and renames the file.
+// The directory is synced too, so the table survives a crash once Finish returns.
 func (b *Builder) Finish() error {
+	if err := b.flushBlock(); err != nil {
+		b.cleanup()
+		return err
+	}
 
-	// Index block (sparse index)
+	// Index block (block handles)
 	indexOffset := b.bytesWritten
-	if err := b.writeIndex(); err != nil {
+	index := b.encodeIndex()
+	if _, err := b.file.Write(index); err != nil {
 		b.cleanup()
 		return err
 	}
 
-	// Footer (8 bytes containing the offset of the Index)
-	if err := binary.Write(b.file, binary.LittleEndian, uint64(indexOffset)); err != nil {
+	// Filter block, left empty when filters are disabled
+	filterOffset := indexOffset + int64(len(index))
+	comparatorOffset := filterOffset
+	if b.opts.BloomBitsPerKey > 0 {
+		filter := appendBlockTrailer(buildBloomFilter(b.keyHashes, b.opts.BloomBitsPerKey))
+		if _, err := b.file.Write(filter); err != nil {
+			b.cleanup()
+			return err
+		}
+		comparatorOffset += int64(len(filter))
+	}
+
+	// Comparator block (name of the key order)
+	if _, err := b.file.Write(appendBlockTrailer([]byte(orBytewise(b.opts.Comparator).Name()))); err != nil {
+		b.cleanup()
+		return err
+	}
+
+	// Footer (highest sequence number, offsets of the Index, Filter and Comparator blocks, format version and magic)
+	if _, err := b.file.Write(encodeFooter(b.maxSeq, indexOffset, filterOffset, comparatorOffset)); err != nil {
 		b.cleanup()
 		return err
 	}
@@ -104,17 +200,30 @@ func (b *Builder) Finish() error {
 		b.cleanup()
 		return err
 	}
+	if err := syncDir(filepath.Dir(b.finalFilename)); err != nil {
+		return fmt.Errorf("failed to sync directory of %s: %w", b.finalFilename, err)
+	}
 	return nil
 }
 
-// Flush writes the entire Skiplist to the SSTable file
+// syncDir makes renames and new files in dir durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// Flush writes every version held by the Skiplist to the SSTable file
 func (b *Builder) Flush(skiplist *memtable.SkipList) error {
 
 	iter := skiplist.NewIterator()
 
 	// Iterate through every node
 	for iter.Next() {
-		if err := b.Add(iter.Key(), iter.Value()); err != nil {
+		if err := b.Add(iter.Key(), iter.Seq(), iter.Kind(), iter.Value()); err != nil {
 			b.cleanup()
 			return err
 		}
@@ -122,23 +231,26 @@ func (b *Builder) Flush(skiplist *memtable.SkipList) error {
 	return b.Finish()
 }
 
-func (b *Builder) writeIndex() error {
-	if err := binary.Write(b.file, binary.LittleEndian, uint32(len(b.index))); err != nil {
-		return err
-	}
-
+// encodeIndex returns the index block: [Count(4B)] followed by one handle per data block
+func (b *Builder) encodeIndex() []byte {
+	buf := binary.LittleEndian.AppendUint32(nil, uint32(len(b.index)))
 	for _, entry := range b.index {
-		if err := binary.Write(b.file, binary.LittleEndian, uint32(len(entry.Key))); err != nil {
-			return err
-		}
-		if _, err := b.file.Write(entry.Key); err != nil {
-			return err
-		}
-		if err := binary.Write(b.file, binary.LittleEndian, int64(entry.Offset)); err != nil {
-			return err
-		}
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(len(entry.Key)))
+		buf = append(buf, entry.Key...)
+		buf = binary.LittleEndian.AppendUint64(buf, uint64(entry.Offset))
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(entry.Size))
 	}
-	return nil
+	return appendBlockTrailer(buf)
+}
+
+// Size returns the number of data bytes added so far, including the pending block
+func (b *Builder) Size() int64 {
+	return b.bytesWritten + int64(len(b.block))
+}
+
+// Path returns the final filename of the table being built
+func (b *Builder) Path() string {
+	return b.finalFilename
 }
 
 // cleanup removes the temporary file if something goes wrong
@@ -1,6 +1,7 @@
 package sstable
 
 import (
+	"fmt"
 	"os"
 	"testing"
 
@@ -90,3 +91,31 @@ func TestBuilder_EmptyFlush(t *testing.T) {
 	_, found := reader.Get([]byte("any"))
 	assert.False(t, found)
 }
+
+func TestBuilder_CustomIndexInterval(t *testing.T) {
+	filename := "test_index_interval.sst"
+	defer os.Remove(filename)
+
+	list := memtable.NewSkipList()
+	for i := range 100 {
+		list.Put([]byte(fmt.Sprintf("key-%03d", i)), []byte("value"))
+	}
+
+	builder, err := NewBuilderWithOptions(filename, Options{IndexInterval: 64})
+	assert.NoError(t, err)
+	assert.NoError(t, builder.Flush(list))
+
+	reader, err := NewReader(filename)
+	assert.NoError(t, err)
+	defer reader.Close()
+
+	// Every entry is 29 bytes, so 64 byte blocks hold 3 entries each
+	assert.Equal(t, 34, len(reader.index))
+
+	val, found := reader.Get([]byte("key-077"))
+	assert.True(t, found)
+	assert.Equal(t, []byte("value"), val)
+
+	_, err = NewBuilderWithOptions(filename, Options{})
+	assert.Error(t, err)
+}
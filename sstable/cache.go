@@ -0,0 +1,125 @@
+package sstable
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+)
+
+// cacheShards spreads the cache over independently locked LRUs
+const cacheShards = 16
+
+// nextFileID hands out the IDs that key cached blocks. IDs are unique for the
+// life of the process, so one cache can be shared by readers of several DBs.
+var nextFileID atomic.Uint64
+
+type cacheKey struct {
+	fileID uint64
+	offset int64
+}
+
+type cacheEntry struct {
+	key   cacheKey
+	block []byte
+}
+
+type cacheShard struct {
+	mu       sync.Mutex
+	entries  map[cacheKey]*list.Element
+	lru      *list.List // Front is the most recently used
+	size     int64
+	capacity int64
+}
+
+// BlockCache is a sharded LRU cache of decompressed, verified data blocks
+// bounded by a total byte capacity. It is safe for concurrent use.
+type BlockCache struct {
+	shards [cacheShards]cacheShard
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+// CacheStats is a snapshot of the counters of a BlockCache
+type CacheStats struct {
+	Hits     uint64
+	Misses   uint64
+	Size     int64 // Bytes currently cached
+	Capacity int64
+}
+
+// NewBlockCache returns a cache holding up to capacity bytes of blocks
+func NewBlockCache(capacity int64) *BlockCache {
+	c := &BlockCache{}
+	for i := range c.shards {
+		c.shards[i] = cacheShard{
+			entries:  make(map[cacheKey]*list.Element),
+			lru:      list.New(),
+			capacity: capacity / cacheShards,
+		}
+	}
+	return c
+}
+
+func (c *BlockCache) shard(key cacheKey) *cacheShard {
+	h := key.fileID*0x9E3779B97F4A7C15 ^ uint64(key.offset)*0xC2B2AE3D27D4EB4F
+	return &c.shards[(h>>32)%cacheShards]
+}
+
+// get returns the cached block, counting the lookup as a hit or a miss
+func (c *BlockCache) get(key cacheKey) ([]byte, bool) {
+	s := c.shard(key)
+	s.mu.Lock()
+	elem, ok := s.entries[key]
+	if ok {
+		s.lru.MoveToFront(elem)
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		c.misses.Add(1)
+		return nil, false
+	}
+	c.hits.Add(1)
+	return elem.Value.(*cacheEntry).block, true
+}
+
+// put caches a block, evicting the least recently used blocks of its shard.
+// Blocks bigger than a shard are not cached.
+func (c *BlockCache) put(key cacheKey, block []byte) {
+	s := c.shard(key)
+	charge := int64(len(block))
+	if charge > s.capacity {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if elem, ok := s.entries[key]; ok {
+		s.lru.MoveToFront(elem)
+		return
+	}
+	s.entries[key] = s.lru.PushFront(&cacheEntry{key: key, block: block})
+	s.size += charge
+
+	for s.size > s.capacity {
+		oldest := s.lru.Back()
+		entry := oldest.Value.(*cacheEntry)
+		s.lru.Remove(oldest)
+		delete(s.entries, entry.key)
+		s.size -= int64(len(entry.block))
+	}
+}
+
+// Stats returns the hit and miss counters and the current size of the cache
+func (c *BlockCache) Stats() CacheStats {
+	stats := CacheStats{Hits: c.hits.Load(), Misses: c.misses.Load()}
+	for i := range c.shards {
+		s := &c.shards[i]
+		s.mu.Lock()
+		stats.Size += s.size
+		stats.Capacity += s.capacity
+		s.mu.Unlock()
+	}
+	return stats
+}
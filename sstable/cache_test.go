@@ -0,0 +1,84 @@
+package sstable
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/thomazdavis/stratago/kind"
+)
+
+func TestBlockCache_EvictsLeastRecentlyUsed(t *testing.T) {
+	cache := NewBlockCache(cacheShards * 100) // 100 bytes per shard
+
+	// Find three keys that land in the same shard
+	var keys []cacheKey
+	for offset := int64(0); len(keys) < 3; offset++ {
+		key := cacheKey{fileID: 1, offset: offset}
+		if cache.shard(key) == cache.shard(cacheKey{fileID: 1, offset: 0}) {
+			keys = append(keys, key)
+		}
+	}
+
+	cache.put(keys[0], make([]byte, 40))
+	cache.put(keys[1], make([]byte, 40))
+	_, ok := cache.get(keys[0]) // keys[1] is now the least recently used
+	assert.True(t, ok)
+
+	cache.put(keys[2], make([]byte, 40))
+	_, ok = cache.get(keys[1])
+	assert.False(t, ok, "Least recently used block should be evicted")
+	_, ok = cache.get(keys[0])
+	assert.True(t, ok)
+
+	cache.put(cacheKey{fileID: 2}, make([]byte, 500))
+	_, ok = cache.get(cacheKey{fileID: 2})
+	assert.False(t, ok, "Blocks bigger than a shard are not cached")
+
+	stats := cache.Stats()
+	assert.Equal(t, uint64(2), stats.Hits)
+	assert.Equal(t, uint64(2), stats.Misses)
+	assert.Equal(t, int64(80), stats.Size)
+	assert.Equal(t, int64(cacheShards*100), stats.Capacity)
+}
+
+func TestReader_UsesBlockCache(t *testing.T) {
+	cache := NewBlockCache(1024 * 1024)
+
+	var readers []*Reader
+	for i := range 2 {
+		filename := fmt.Sprintf("test_cached_%d.sst", i)
+		defer os.Remove(filename)
+
+		builder, _ := NewBuilder(filename)
+		for j := range 100 {
+			builder.Add(fmt.Appendf(nil, "key%03d", j), 1, kind.Value, fmt.Appendf(nil, "table%d", i))
+		}
+		assert.NoError(t, builder.Finish())
+
+		r, err := NewReaderWithOptions(filename, ReaderOptions{BlockCache: cache})
+		assert.NoError(t, err)
+		defer r.Close()
+		readers = append(readers, r)
+	}
+	before := cache.Stats()
+
+	for range 3 {
+		for i, r := range readers {
+			val, found := r.Get([]byte("key042"))
+			assert.True(t, found)
+			assert.Equal(t, fmt.Appendf(nil, "table%d", i), val, "Tables sharing a cache must not see each other's blocks")
+		}
+	}
+
+	stats := cache.Stats()
+	assert.Equal(t, uint64(2), stats.Misses-before.Misses)
+	assert.Equal(t, uint64(4), stats.Hits-before.Hits)
+
+	// Values handed out are copies, so the cached block stays intact
+	val, _ := readers[0].Get([]byte("key042"))
+	val[0] = 'X'
+	val, _ = readers[0].Get([]byte("key042"))
+	assert.Equal(t, []byte("table0"), val)
+}
@@ -0,0 +1,78 @@
+package sstable
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+)
+
+// Compression identifies the codec a data block is stored with.
+// The ID is written next to every block, so one table may mix codecs.
+type Compression uint8
+
+const (
+	NoCompression      Compression = 0
+	DeflateCompression Compression = 1 // compress/flate, best ratio
+	LZCompression      Compression = 2 // Byte-oriented LZ77, fastest
+)
+
+// Valid reports whether c is a known codec
+func (c Compression) Valid() bool {
+	return c <= LZCompression
+}
+
+func (c Compression) String() string {
+	switch c {
+	case NoCompression:
+		return "none"
+	case DeflateCompression:
+		return "deflate"
+	case LZCompression:
+		return "lz"
+	}
+	return fmt.Sprintf("Compression(%d)", uint8(c))
+}
+
+// compressBlock encodes a block with the given codec
+func compressBlock(c Compression, block []byte) ([]byte, error) {
+	switch c {
+	case NoCompression:
+		return block, nil
+	case DeflateCompression:
+		var buf bytes.Buffer
+		w, err := flate.NewWriter(&buf, flate.DefaultCompression)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(block); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case LZCompression:
+		return lzCompress(block), nil
+	}
+	return nil, fmt.Errorf("unknown compression %d", uint8(c))
+}
+
+// decompressBlock decodes a block stored with the given codec
+func decompressBlock(c Compression, payload []byte) ([]byte, error) {
+	switch c {
+	case NoCompression:
+		return payload, nil
+	case DeflateCompression:
+		r := flate.NewReader(bytes.NewReader(payload))
+		defer r.Close()
+		block, err := io.ReadAll(r)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrCorrupt, err)
+		}
+		return block, nil
+	case LZCompression:
+		return lzDecompress(payload)
+	}
+	return nil, fmt.Errorf("%w: unknown compression %d", ErrCorrupt, uint8(c))
+}
@@ -0,0 +1,100 @@
+package sstable
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/thomazdavis/stratago/kind"
+)
+
+func TestCompression_RoundTrip(t *testing.T) {
+	random := make([]byte, 4096)
+	rand.New(rand.NewSource(1)).Read(random)
+
+	inputs := map[string][]byte{
+		"empty":      {},
+		"short":      []byte("abc"),
+		"zeros":      make([]byte, 10000),
+		"json":       bytes.Repeat([]byte(`{"name":"stratago","tags":["lsm","go"],"size":42}`), 80),
+		"random":     random,
+		"overlapped": []byte("abcabcabcabcabcabcabcabcxyz"),
+	}
+
+	for _, codec := range []Compression{NoCompression, DeflateCompression, LZCompression} {
+		for name, input := range inputs {
+			payload, err := compressBlock(codec, input)
+			assert.NoError(t, err)
+			output, err := decompressBlock(codec, payload)
+			assert.NoError(t, err, "%v/%s", codec, name)
+			assert.True(t, bytes.Equal(input, output), "%v/%s did not round trip", codec, name)
+		}
+	}
+
+	json := inputs["json"]
+	lz, _ := compressBlock(LZCompression, json)
+	deflate, _ := compressBlock(DeflateCompression, json)
+	assert.Less(t, len(lz)*5, len(json))
+	assert.Less(t, len(deflate)*5, len(json))
+}
+
+func TestCompression_RejectsMalformedLZ(t *testing.T) {
+	payload, _ := compressBlock(LZCompression, bytes.Repeat([]byte("hello world "), 20))
+
+	for _, bad := range [][]byte{
+		payload[:len(payload)-1],
+		{10, lzOpCopy, 5, 4}, // Copy before any output
+		{},
+	} {
+		_, err := decompressBlock(LZCompression, bad)
+		assert.ErrorIs(t, err, ErrCorrupt)
+	}
+}
+
+func TestBuilder_CompressedTables(t *testing.T) {
+	value := []byte(`{"user":"someone","events":["login","view","view","logout"],"ok":true}`)
+	random := make([]byte, 200)
+	rand.New(rand.NewSource(2)).Read(random)
+
+	sizes := make(map[Compression]int64)
+	for _, codec := range []Compression{NoCompression, DeflateCompression, LZCompression} {
+		filename := fmt.Sprintf("test_compressed_%v.sst", codec)
+		defer os.Remove(filename)
+
+		opts := DefaultOptions()
+		opts.Compression = codec
+		builder, err := NewBuilderWithOptions(filename, opts)
+		assert.NoError(t, err)
+		for i := range 200 {
+			val := value
+			if i%50 == 0 {
+				val = random // Some blocks do not compress and are stored raw
+			}
+			assert.NoError(t, builder.Add(fmt.Appendf(nil, "key%03d", i), uint64(i+1), kind.Value, val))
+		}
+		assert.NoError(t, builder.Finish())
+
+		reader, err := NewReader(filename)
+		assert.NoError(t, err)
+		defer reader.Close()
+		sizes[codec] = reader.Size()
+
+		val, found := reader.Get([]byte("key050"))
+		assert.True(t, found)
+		assert.Equal(t, random, val)
+
+		all, err := reader.ReadAll()
+		assert.NoError(t, err)
+		assert.Len(t, all, 200)
+		assert.Equal(t, []byte("key199"), reader.Largest())
+	}
+
+	assert.Less(t, sizes[DeflateCompression], sizes[NoCompression]/2)
+	assert.Less(t, sizes[LZCompression], sizes[NoCompression]/2)
+
+	_, err := NewBuilderWithOptions("test_compressed_invalid.sst", Options{IndexInterval: IndexInterval, Compression: 9})
+	assert.Error(t, err)
+}
@@ -0,0 +1,49 @@
+package sstable
+
+import "fmt"
+
+// FilterDecision is what a CompactionFilter does with a value
+type FilterDecision int
+
+const (
+	FilterKeep   FilterDecision = iota // Write the value unchanged
+	FilterRemove                       // Delete the key
+	FilterChange                       // Replace the value with the one returned by the filter
+)
+
+func (d FilterDecision) String() string {
+	switch d {
+	case FilterKeep:
+		return "keep"
+	case FilterRemove:
+		return "remove"
+	case FilterChange:
+		return "change"
+	}
+	return fmt.Sprintf("FilterDecision(%d)", int(d))
+}
+
+// FilterContext describes the merge a CompactionFilter is called from
+type FilterContext struct {
+	// Bottommost is set when no older data exists below the output, so a
+	// removed key leaves nothing behind
+	Bottommost bool
+
+	// OutputLevel is the level the merged tables are written to
+	OutputLevel int
+}
+
+// CompactionFilter drops or rewrites values while tables are merged, applying
+// application rules such as expiring sessions. Filter is called with the newest
+// value of each key that no live snapshot can see; tombstones and versions kept
+// for snapshots are never filtered. key and value are only valid during the call.
+type CompactionFilter interface {
+	Filter(ctx FilterContext, key, value []byte) (FilterDecision, []byte)
+}
+
+// CompactionFilterFunc adapts a function to the CompactionFilter interface
+type CompactionFilterFunc func(ctx FilterContext, key, value []byte) (FilterDecision, []byte)
+
+func (f CompactionFilterFunc) Filter(ctx FilterContext, key, value []byte) (FilterDecision, []byte) {
+	return f(ctx, key, value)
+}
@@ -0,0 +1,272 @@
+package sstable
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+
+	"github.com/thomazdavis/stratago/kind"
+)
+
+// tableMagic closes every versioned SSTable. Files without it predate versioning.
+const tableMagic = 0x54534753 // "SGST"
+
+// On-disk format versions
+const (
+	// formatV0 is the layout of the original release. Entries are [KeySize(4B)][ValSize(4B)][Key][Value]
+	// without sequence numbers, and tombstones are empty values. Footer: [IndexOffset(8B)]
+	formatV0 uint32 = 0
+
+	// formatSeq adds sequence numbers: [KeySize(4B)][ValSize(4B)][Seq(8B)][Key][Value].
+	// Footer: [MaxSeq(8B)][IndexOffset(8B)]
+	formatSeq uint32 = 1
+
+	// formatKinds adds a Kind byte: [KeySize(4B)][ValSize(4B)][Seq(8B)][Kind(1B)][Key][Value].
+	// Footer: [MaxSeq(8B)][IndexOffset(8B)][Version(4B)][Magic(4B)]
+	formatKinds uint32 = 2
+
+	// formatFilter adds a Bloom filter block after the index.
+	// Footer: [MaxSeq(8B)][IndexOffset(8B)][FilterOffset(8B)][Version(4B)][Magic(4B)]
+	formatFilter uint32 = 3
+
+	// formatBlocks groups entries into data blocks, each followed by a CRC32C trailer.
+	// Index entries are block handles [KeyLen(4B)][Key][Offset(8B)][Size(4B)], and the
+	// index and filter blocks carry a trailer too. The footer is unchanged.
+	formatBlocks uint32 = 4
+
+	// formatCompressed stores each data block as [Payload][Compression(1B)][CRC32C(4B)],
+	// where the checksum covers the payload and the codec ID.
+	formatCompressed uint32 = 5
+
+	// formatComparator records the name of the key order in a block between the filter and the footer.
+	// Footer: [MaxSeq(8B)][IndexOffset(8B)][FilterOffset(8B)][ComparatorOffset(8B)][Version(4B)][Magic(4B)]
+	formatComparator uint32 = 6
+
+	currentFormat = formatComparator
+)
+
+// blockTrailerSize is the size of the CRC32C that closes every block
+const blockTrailerSize = 4
+
+// ErrCorrupt is returned when a table fails its checksums or cannot be decoded
+var ErrCorrupt = errors.New("sstable: corrupt table")
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+const (
+	v0FooterSize     = 8
+	seqFooterSize    = 16
+	kindsFooterSize  = 24
+	filterFooterSize = 32
+	footerSize       = 40
+)
+
+// footer describes where the data section ends and how it is encoded
+type footer struct {
+	version          uint32
+	maxSeq           uint64
+	indexOffset      int64 // End of the data section
+	filterOffset     int64 // Start of the filter block, equal to its end if there is none
+	comparatorOffset int64 // End of the filter block and start of the comparator block, if any
+}
+
+// readFooter decodes the footer of a file of the given size.
+// Files too small to hold a footer are treated as empty tables.
+func readFooter(f *os.File, fileSize int64) (footer, error) {
+	if fileSize < v0FooterSize {
+		return footer{version: currentFormat, indexOffset: 0}, nil
+	}
+
+	tail := make([]byte, min(fileSize, footerSize))
+	if _, err := f.ReadAt(tail, fileSize-int64(len(tail))); err != nil {
+		return footer{}, err
+	}
+
+	n := len(tail)
+	if n >= kindsFooterSize && binary.LittleEndian.Uint32(tail[n-4:]) == tableMagic {
+		version := binary.LittleEndian.Uint32(tail[n-8 : n-4])
+		switch {
+		case version == formatKinds:
+			tail = tail[n-kindsFooterSize:]
+			return footer{
+				version:          version,
+				maxSeq:           binary.LittleEndian.Uint64(tail[0:8]),
+				indexOffset:      int64(binary.LittleEndian.Uint64(tail[8:16])),
+				filterOffset:     fileSize - kindsFooterSize,
+				comparatorOffset: fileSize - kindsFooterSize,
+			}, nil
+		case version >= formatFilter && version <= formatCompressed && n >= filterFooterSize:
+			tail = tail[n-filterFooterSize:]
+			return footer{
+				version:          version,
+				maxSeq:           binary.LittleEndian.Uint64(tail[0:8]),
+				indexOffset:      int64(binary.LittleEndian.Uint64(tail[8:16])),
+				filterOffset:     int64(binary.LittleEndian.Uint64(tail[16:24])),
+				comparatorOffset: fileSize - filterFooterSize,
+			}, nil
+		case version == formatComparator && n == footerSize:
+			return footer{
+				version:          version,
+				maxSeq:           binary.LittleEndian.Uint64(tail[0:8]),
+				indexOffset:      int64(binary.LittleEndian.Uint64(tail[8:16])),
+				filterOffset:     int64(binary.LittleEndian.Uint64(tail[16:24])),
+				comparatorOffset: int64(binary.LittleEndian.Uint64(tail[24:32])),
+			}, nil
+		}
+		return footer{}, fmt.Errorf("unsupported sstable format version %d", version)
+	}
+
+	// No magic, the table predates versioning. Both unversioned footers end in the
+	// index offset, but only in formatV0 does the index run up to the footer itself.
+	indexOffset := int64(binary.LittleEndian.Uint64(tail[n-8:]))
+	v0, err := indexEndsAt(f, indexOffset, fileSize-v0FooterSize)
+	if err != nil {
+		return footer{}, err
+	}
+	if v0 {
+		return footer{
+			version:          formatV0,
+			indexOffset:      indexOffset,
+			filterOffset:     fileSize - v0FooterSize,
+			comparatorOffset: fileSize - v0FooterSize,
+		}, nil
+	}
+	if n < seqFooterSize {
+		return footer{}, fmt.Errorf("%w: truncated footer", ErrCorrupt)
+	}
+	return footer{
+		version:          formatSeq,
+		maxSeq:           binary.LittleEndian.Uint64(tail[n-16 : n-8]),
+		indexOffset:      indexOffset,
+		filterOffset:     fileSize - seqFooterSize,
+		comparatorOffset: fileSize - seqFooterSize,
+	}, nil
+}
+
+// indexEndsAt reports whether the unversioned index starting at offset ends exactly at end
+func indexEndsAt(f *os.File, offset, end int64) (bool, error) {
+	if offset < 0 || end-offset < 4 {
+		return false, nil
+	}
+	buf := make([]byte, end-offset)
+	if _, err := f.ReadAt(buf, offset); err != nil {
+		return false, err
+	}
+
+	numEntries := binary.LittleEndian.Uint32(buf)
+	pos := int64(4)
+	for range numEntries {
+		if int64(len(buf))-pos < 4 {
+			return false, nil
+		}
+		pos += 4 + int64(binary.LittleEndian.Uint32(buf[pos:])) + 8
+	}
+	return pos == int64(len(buf)), nil
+}
+
+// encodeFooter returns the footer written by the current format
+func encodeFooter(maxSeq uint64, indexOffset, filterOffset, comparatorOffset int64) []byte {
+	buf := make([]byte, footerSize)
+	binary.LittleEndian.PutUint64(buf[0:8], maxSeq)
+	binary.LittleEndian.PutUint64(buf[8:16], uint64(indexOffset))
+	binary.LittleEndian.PutUint64(buf[16:24], uint64(filterOffset))
+	binary.LittleEndian.PutUint64(buf[24:32], uint64(comparatorOffset))
+	binary.LittleEndian.PutUint32(buf[32:36], currentFormat)
+	binary.LittleEndian.PutUint32(buf[36:40], tableMagic)
+	return buf
+}
+
+// entryHeaderSize returns the size of an entry header in the given format
+func entryHeaderSize(version uint32) int {
+	switch version {
+	case formatV0:
+		return 8
+	case formatSeq:
+		return 16
+	}
+	return 17
+}
+
+// entryHeader is the fixed-size prefix of every data entry
+type entryHeader struct {
+	keySize uint32
+	valSize uint32
+	seq     uint64
+	kind    kind.Kind
+}
+
+// decodeEntryHeader parses an entry header written in the given format
+func decodeEntryHeader(version uint32, buf []byte) (entryHeader, error) {
+	h := entryHeader{
+		keySize: binary.LittleEndian.Uint32(buf[0:4]),
+		valSize: binary.LittleEndian.Uint32(buf[4:8]),
+	}
+	if version != formatV0 {
+		// formatV0 has no sequence numbers, its versions are ordered by table age alone
+		h.seq = binary.LittleEndian.Uint64(buf[8:16])
+	}
+
+	if version <= formatSeq {
+		// These tables could not store empty values, every empty value is a tombstone
+		h.kind = kind.Value
+		if h.valSize == 0 {
+			h.kind = kind.Delete
+		}
+		return h, nil
+	}
+
+	h.kind = kind.Kind(buf[16])
+	if !h.kind.Valid() {
+		return h, fmt.Errorf("invalid entry kind %d", buf[16])
+	}
+	return h, nil
+}
+
+// encodeEntryHeader returns the entry header written by the current format
+func encodeEntryHeader(keySize, valSize int, seq uint64, k kind.Kind) []byte {
+	buf := make([]byte, entryHeaderSize(currentFormat))
+	binary.LittleEndian.PutUint32(buf[0:4], uint32(keySize))
+	binary.LittleEndian.PutUint32(buf[4:8], uint32(valSize))
+	binary.LittleEndian.PutUint64(buf[8:16], seq)
+	buf[16] = byte(k)
+	return buf
+}
+
+// appendBlockTrailer appends the CRC32C of block to it
+func appendBlockTrailer(block []byte) []byte {
+	return binary.LittleEndian.AppendUint32(block, crc32.Checksum(block, crcTable))
+}
+
+// checkBlock verifies the trailer of a block read from offset and returns its contents
+func checkBlock(buf []byte, offset int64) ([]byte, error) {
+	if len(buf) < blockTrailerSize {
+		return nil, fmt.Errorf("%w: truncated block at offset %d", ErrCorrupt, offset)
+	}
+	contents := buf[:len(buf)-blockTrailerSize]
+	if crc32.Checksum(contents, crcTable) != binary.LittleEndian.Uint32(buf[len(contents):]) {
+		return nil, fmt.Errorf("%w: checksum mismatch in block at offset %d", ErrCorrupt, offset)
+	}
+	return contents, nil
+}
+
+// decodeEntry parses the first entry of a data block and returns the rest of the block
+func decodeEntry(version uint32, block []byte) (entryHeader, []byte, []byte, []byte, error) {
+	size := entryHeaderSize(version)
+	if len(block) < size {
+		return entryHeader{}, nil, nil, nil, fmt.Errorf("%w: truncated entry header", ErrCorrupt)
+	}
+	h, err := decodeEntryHeader(version, block)
+	if err != nil {
+		return entryHeader{}, nil, nil, nil, fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+
+	end := int64(size) + int64(h.keySize) + int64(h.valSize)
+	if int64(len(block)) < end {
+		return entryHeader{}, nil, nil, nil, fmt.Errorf("%w: truncated entry", ErrCorrupt)
+	}
+	key := block[size : size+int(h.keySize)]
+	val := block[size+int(h.keySize) : end]
+	return h, key, val, block[end:], nil
+}
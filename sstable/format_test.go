@@ -0,0 +1,286 @@
+package sstable
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/thomazdavis/stratago/kind"
+	"github.com/thomazdavis/stratago/memtable"
+	"github.com/thomazdavis/stratago/sstable/sstabletest"
+)
+
+func TestFormat_ReadsV0Tables(t *testing.T) {
+	filename := "test_v0.sst"
+	defer os.Remove(filename)
+
+	var kvs []string
+	for i := range 100 {
+		val := fmt.Sprintf("val%d", i)
+		if i%10 == 0 {
+			val = "" // Tombstone
+		}
+		kvs = append(kvs, fmt.Sprintf("key%03d", i), val)
+	}
+	assert.NoError(t, sstabletest.WriteV0Table(filename, 64, kvs...))
+
+	reader, err := NewReader(filename)
+	assert.NoError(t, err)
+	defer reader.Close()
+
+	assert.Equal(t, formatV0, reader.footer.version)
+	assert.Greater(t, len(reader.index), 1)
+	assert.Equal(t, uint64(0), reader.MaxSequence())
+	assert.Equal(t, []byte("key000"), reader.Smallest())
+	assert.Equal(t, []byte("key099"), reader.Largest())
+
+	val, found := reader.Get([]byte("key042"))
+	assert.True(t, found)
+	assert.Equal(t, []byte("val42"), val)
+	_, k, found, err := reader.GetAt([]byte("key050"), 0)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, kind.Delete, k)
+
+	it, err := reader.NewIterator()
+	assert.NoError(t, err)
+	defer it.Close()
+	count := 0
+	for it.Next() {
+		count++
+	}
+	assert.NoError(t, it.Error())
+	assert.Equal(t, 100, count)
+
+	// An empty table of the original release is only its index count and footer
+	assert.NoError(t, sstabletest.WriteV0Table(filename, 64))
+	empty, err := NewReader(filename)
+	assert.NoError(t, err)
+	defer empty.Close()
+	assert.Empty(t, empty.index)
+	_, found = empty.Get([]byte("key000"))
+	assert.False(t, found)
+}
+
+// writeLegacyTable writes a table in the formatSeq layout, where tombstones are empty values
+func writeLegacyTable(t *testing.T, filename string) {
+	var data []byte
+	entry := func(key, val string, seq uint64) {
+		data = binary.LittleEndian.AppendUint32(data, uint32(len(key)))
+		data = binary.LittleEndian.AppendUint32(data, uint32(len(val)))
+		data = binary.LittleEndian.AppendUint64(data, seq)
+		data = append(data, key...)
+		data = append(data, val...)
+	}
+	entry("a", "1", 3)
+	entry("b", "", 2) // Tombstone
+	entry("c", "3", 1)
+
+	indexOffset := len(data)
+	data = binary.LittleEndian.AppendUint32(data, 1)
+	data = binary.LittleEndian.AppendUint32(data, 1)
+	data = append(data, 'a')
+	data = binary.LittleEndian.AppendUint64(data, 0)
+
+	data = binary.LittleEndian.AppendUint64(data, 3)
+	data = binary.LittleEndian.AppendUint64(data, uint64(indexOffset))
+
+	assert.NoError(t, os.WriteFile(filename, data, 0644))
+}
+
+func TestFormat_ReadsLegacyTables(t *testing.T) {
+	filename := "test_legacy.sst"
+	defer os.Remove(filename)
+	writeLegacyTable(t, filename)
+
+	reader, err := NewReader(filename)
+	assert.NoError(t, err)
+	defer reader.Close()
+
+	assert.Equal(t, formatSeq, reader.footer.version)
+	assert.Equal(t, uint64(3), reader.MaxSequence())
+
+	val, found := reader.Get([]byte("a"))
+	assert.True(t, found)
+	assert.Equal(t, []byte("1"), val)
+
+	_, k, found, err := reader.GetAt([]byte("b"), 10)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, kind.Delete, k)
+
+	it, err := reader.NewIterator()
+	assert.NoError(t, err)
+	defer it.Close()
+
+	var kinds []kind.Kind
+	for it.Next() {
+		kinds = append(kinds, it.Kind())
+	}
+	assert.NoError(t, it.Error())
+	assert.Equal(t, []kind.Kind{kind.Value, kind.Delete, kind.Value}, kinds)
+}
+
+func TestFormat_EmptyValueRoundTrip(t *testing.T) {
+	filename := "test_empty_values.sst"
+	defer os.Remove(filename)
+
+	list := memtable.NewSkipList()
+	list.PutVersion([]byte("empty"), []byte{}, 2)
+	list.DeleteVersion([]byte("gone"), 1)
+
+	builder, _ := NewBuilder(filename)
+	assert.NoError(t, builder.Flush(list))
+
+	reader, err := NewReader(filename)
+	assert.NoError(t, err)
+	defer reader.Close()
+	assert.Equal(t, currentFormat, reader.footer.version)
+
+	val, found := reader.Get([]byte("empty"))
+	assert.True(t, found)
+	assert.Empty(t, val)
+
+	_, found = reader.Get([]byte("gone"))
+	assert.False(t, found)
+
+	all, err := reader.ReadAll()
+	assert.NoError(t, err)
+	assert.NotNil(t, all["empty"])
+	assert.Nil(t, all["gone"])
+}
+
+// writeKindsTable writes a table in the formatKinds layout: explicit kinds but no blocks or filter
+func writeKindsTable(t *testing.T, filename string) {
+	var data []byte
+	entry := func(key, val string, seq uint64, k kind.Kind) {
+		data = append(data, encodeEntryHeader(len(key), len(val), seq, k)...)
+		data = append(data, key...)
+		data = append(data, val...)
+	}
+	entry("a", "1", 2, kind.Value)
+	entry("b", "", 1, kind.Delete)
+
+	indexOffset := len(data)
+	data = binary.LittleEndian.AppendUint32(data, 1)
+	data = binary.LittleEndian.AppendUint32(data, 1)
+	data = append(data, 'a')
+	data = binary.LittleEndian.AppendUint64(data, 0)
+
+	data = binary.LittleEndian.AppendUint64(data, 2)
+	data = binary.LittleEndian.AppendUint64(data, uint64(indexOffset))
+	data = binary.LittleEndian.AppendUint32(data, formatKinds)
+	data = binary.LittleEndian.AppendUint32(data, tableMagic)
+
+	assert.NoError(t, os.WriteFile(filename, data, 0644))
+}
+
+func TestFormat_ReadsTablesWithoutBlocks(t *testing.T) {
+	filename := "test_kinds_format.sst"
+	defer os.Remove(filename)
+	writeKindsTable(t, filename)
+
+	reader, err := NewReader(filename)
+	assert.NoError(t, err)
+	defer reader.Close()
+
+	assert.Equal(t, formatKinds, reader.footer.version)
+	assert.Nil(t, reader.filter)
+	assert.Equal(t, []byte("b"), reader.Largest())
+
+	val, found := reader.Get([]byte("a"))
+	assert.True(t, found)
+	assert.Equal(t, []byte("1"), val)
+	_, k, found, err := reader.GetAt([]byte("b"), 10)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, kind.Delete, k)
+}
+
+func TestFormat_DetectsCorruptBlocks(t *testing.T) {
+	filename := "test_corrupt.sst"
+	defer os.Remove(filename)
+
+	builder, _ := NewBuilderWithOptions(filename, Options{IndexInterval: 64})
+	for i := range 20 {
+		builder.Add([]byte{'a' + byte(i)}, uint64(i+1), kind.Value, []byte("value"))
+	}
+	assert.NoError(t, builder.Finish())
+
+	reader, err := NewReader(filename)
+	assert.NoError(t, err)
+	assert.Greater(t, len(reader.index), 2)
+	target := reader.index[1]
+	reader.Close()
+
+	// Flip one bit in the value of an entry inside the second block
+	data, _ := os.ReadFile(filename)
+	data[target.Offset+target.Size-1] ^= 0x01
+	assert.NoError(t, os.WriteFile(filename, data, 0644))
+
+	reader, err = NewReader(filename)
+	assert.NoError(t, err)
+	defer reader.Close()
+
+	// The first block is intact
+	val, found := reader.Get([]byte("a"))
+	assert.True(t, found)
+	assert.Equal(t, []byte("value"), val)
+
+	_, _, _, err = reader.GetAt(target.Key, 100)
+	assert.ErrorIs(t, err, ErrCorrupt)
+
+	_, err = reader.ReadAll()
+	assert.ErrorIs(t, err, ErrCorrupt)
+
+	it, _ := reader.NewIterator()
+	defer it.Close()
+	count := 0
+	for it.Next() {
+		count++
+	}
+	// Entries are 23 bytes, so the intact first block holds 3 of them
+	assert.Equal(t, 3, count, "Iteration stops at the corrupt block")
+	assert.ErrorIs(t, it.Error(), ErrCorrupt)
+}
+
+func TestFormat_DetectsCorruptIndex(t *testing.T) {
+	filename := "test_corrupt_index.sst"
+	defer os.Remove(filename)
+
+	builder, _ := NewBuilder(filename)
+	builder.Add([]byte("a"), 1, kind.Value, []byte("1"))
+	assert.NoError(t, builder.Finish())
+
+	reader, err := NewReader(filename)
+	assert.NoError(t, err)
+	indexOffset := reader.footer.indexOffset
+	reader.Close()
+
+	data, _ := os.ReadFile(filename)
+	data[indexOffset+4] ^= 0xFF
+	assert.NoError(t, os.WriteFile(filename, data, 0644))
+
+	_, err = NewReader(filename)
+	assert.ErrorIs(t, err, ErrCorrupt)
+}
+
+func TestFormat_DetectsCorruptComparatorOffset(t *testing.T) {
+	filename := "test_corrupt_comparator_offset.sst"
+	defer os.Remove(filename)
+
+	builder, _ := NewBuilder(filename)
+	builder.Add([]byte("a"), 1, kind.Value, []byte("1"))
+	assert.NoError(t, builder.Finish())
+
+	// An offset inside the footer leaves no room for the comparator block
+	data, _ := os.ReadFile(filename)
+	comparatorOffset := len(data) - footerSize + 24
+	binary.LittleEndian.PutUint64(data[comparatorOffset:], uint64(len(data)-footerSize/2))
+	assert.NoError(t, os.WriteFile(filename, data, 0644))
+
+	_, err := NewReader(filename)
+	assert.ErrorIs(t, err, ErrCorrupt)
+}
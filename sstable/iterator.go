@@ -1,95 +1,113 @@
 package sstable
 
 import (
-	"encoding/binary"
-	"io"
 	"os"
+
+	"github.com/thomazdavis/stratago/kind"
 )
 
 type Iterator struct {
-	file       *os.File
-	limit      int64
-	currentPos int64
-	key        []byte
-	val        []byte
-	err        error
+	file      *os.File
+	reader    *Reader
+	nextBlock int    // Position in the index of the block to load next
+	block     []byte // Undecoded entries left in the current block
+	key       []byte
+	seq       uint64
+	kind      kind.Kind
+	val       []byte
+	err       error
 }
 
 // Creates a sequential scanner for an SSTable
 func (r *Reader) NewIterator() (*Iterator, error) {
-	// Using a new file handle so that Iterator's position
-	// doesn't interfere with concurrent Get() calls
+	// Using a new file handle keeps the iterator usable even after
+	// the Reader is closed and its file removed by a compaction
 	f, err := os.Open(r.file.Name())
 	if err != nil {
 		return nil, err
 	}
 
-	stat, err := f.Stat()
-	if err != nil {
-		f.Close()
-		return nil, err
-	}
-
-	fileSize := stat.Size()
-	limit := fileSize
-
-	if fileSize > 8 {
-		footer := make([]byte, 8)
-		f.ReadAt(footer, fileSize-8)
-		limit = int64(binary.LittleEndian.Uint64(footer))
-	}
-
 	return &Iterator{
-		file:       f,
-		limit:      limit,
-		currentPos: 0,
+		file:   f,
+		reader: r,
 	}, nil
 }
 
+// Next advances to the next entry, loading and verifying blocks as needed.
+// A corrupt block stops the iteration and is reported by Error.
 func (it *Iterator) Next() bool {
-	if it.currentPos >= it.limit {
+	if it.err != nil {
 		return false
 	}
 
-	var keySize, valSize uint32
-
-	if err := binary.Read(it.file, binary.LittleEndian, &keySize); err != nil {
-		it.err = err
-		return false
-	}
-	if err := binary.Read(it.file, binary.LittleEndian, &valSize); err != nil {
-		it.err = err
-		return false
+	for len(it.block) == 0 {
+		if it.nextBlock >= len(it.reader.index) {
+			return false
+		}
+		block, err := it.reader.readBlock(it.file, it.nextBlock)
+		if err != nil {
+			it.err = err
+			return false
+		}
+		it.block = block
+		it.nextBlock++
 	}
 
-	it.key = make([]byte, keySize)
-	if _, err := io.ReadFull(it.file, it.key); err != nil {
+	header, key, val, rest, err := decodeEntry(it.reader.footer.version, it.block)
+	if err != nil {
 		it.err = err
 		return false
 	}
+	it.block = rest
+	it.seq = header.seq
+	it.kind = header.kind
+	it.key = key
+	it.val = val
+	return true
+}
 
-	it.val = make([]byte, valSize)
-	if _, err := io.ReadFull(it.file, it.val); err != nil {
-		it.err = err
-		return false
+// First rewinds the iterator to the smallest key. Returns false if the table is empty.
+func (it *Iterator) First() bool {
+	it.seekBlock(0)
+	return it.Next()
+}
+
+// SeekGE positions the iterator at the newest version of the first key >= key, using the
+// block index to skip blocks that can only hold smaller keys.
+func (it *Iterator) SeekGE(key []byte) bool {
+	it.seekBlock(it.reader.findBlock(key))
+	for it.Next() {
+		if it.reader.cmp.Compare(it.key, key) >= 0 {
+			return true
+		}
 	}
+	return false
+}
 
-	it.currentPos += int64(8 + keySize + valSize)
-	return true
+// seekBlock positions the iterator before the first entry of the i-th block
+func (it *Iterator) seekBlock(i int) {
+	it.nextBlock = i
+	it.block = nil
+	it.err = nil
 }
 
 func (it *Iterator) Key() []byte {
 	return it.key
 }
 
+func (it *Iterator) Seq() uint64 {
+	return it.seq
+}
+
+func (it *Iterator) Kind() kind.Kind {
+	return it.kind
+}
+
 func (it *Iterator) Value() []byte {
 	return it.val
 }
 
 func (it *Iterator) Error() error {
-	if it.err == io.EOF {
-		return nil
-	}
 	return it.err
 }
 
@@ -0,0 +1,44 @@
+package sstable
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIterator_SeekGE(t *testing.T) {
+	filename := "test_iter_seek.sst"
+	defer os.Remove(filename)
+
+	kvs := make(map[string]string)
+	for i := range 200 {
+		kvs[fmt.Sprintf("key-%03d", i*2)] = fmt.Sprintf("val-%03d", i*2)
+	}
+	reader := buildTestSSTable(filename, kvs)
+	defer reader.Close()
+
+	it, err := reader.NewIterator()
+	assert.NoError(t, err)
+	defer it.Close()
+
+	// Exact match deep into the file (jumps through the sparse index)
+	assert.True(t, it.SeekGE([]byte("key-300")))
+	assert.Equal(t, []byte("key-300"), it.Key())
+	assert.Equal(t, []byte("val-300"), it.Value())
+
+	// Missing key lands on the next larger one
+	assert.True(t, it.SeekGE([]byte("key-101")))
+	assert.Equal(t, []byte("key-102"), it.Key())
+
+	assert.True(t, it.Next())
+	assert.Equal(t, []byte("key-104"), it.Key())
+
+	// Seeking backwards works too
+	assert.True(t, it.First())
+	assert.Equal(t, []byte("key-000"), it.Key())
+
+	assert.False(t, it.SeekGE([]byte("zzz")))
+	assert.NoError(t, it.Error())
+}
@@ -0,0 +1,120 @@
+package sstable
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// A small LZ77 codec tuned for speed over ratio. The encoding is
+// [DecodedLen(uvarint)] followed by a stream of operations:
+//
+//	literal: [0][Len(uvarint)][Bytes]
+//	copy:    [1][Offset(uvarint)][Len(uvarint)]
+//
+// A copy repeats Len bytes starting Offset bytes back in the output,
+// and may overlap the bytes it produces.
+const (
+	lzOpLiteral = 0
+	lzOpCopy    = 1
+
+	lzMinMatch  = 4
+	lzHashBits  = 14
+	lzMaxOffset = 1 << 16
+)
+
+func lzHash(v uint32) uint32 {
+	return (v * 2654435761) >> (32 - lzHashBits)
+}
+
+// lzCompress encodes src
+func lzCompress(src []byte) []byte {
+	dst := binary.AppendUvarint(nil, uint64(len(src)))
+
+	var table [1 << lzHashBits]int32 // Position+1 of the last occurrence of each hash
+	literalStart := 0
+	emitLiterals := func(end int) {
+		if end > literalStart {
+			dst = append(dst, lzOpLiteral)
+			dst = binary.AppendUvarint(dst, uint64(end-literalStart))
+			dst = append(dst, src[literalStart:end]...)
+		}
+	}
+
+	for i := 0; i+lzMinMatch <= len(src); {
+		v := binary.LittleEndian.Uint32(src[i:])
+		h := lzHash(v)
+		candidate := int(table[h]) - 1
+		table[h] = int32(i + 1)
+
+		if candidate < 0 || i-candidate > lzMaxOffset || binary.LittleEndian.Uint32(src[candidate:]) != v {
+			i++
+			continue
+		}
+
+		length := lzMinMatch
+		for i+length < len(src) && src[candidate+length] == src[i+length] {
+			length++
+		}
+
+		emitLiterals(i)
+		dst = append(dst, lzOpCopy)
+		dst = binary.AppendUvarint(dst, uint64(i-candidate))
+		dst = binary.AppendUvarint(dst, uint64(length))
+
+		i += length
+		literalStart = i
+	}
+	emitLiterals(len(src))
+	return dst
+}
+
+// lzDecompress decodes the output of lzCompress
+func lzDecompress(src []byte) ([]byte, error) {
+	corrupt := fmt.Errorf("%w: malformed lz block", ErrCorrupt)
+
+	size, n := binary.Uvarint(src)
+	if n <= 0 {
+		return nil, corrupt
+	}
+	src = src[n:]
+
+	// The header is not trusted for the allocation until the data proves it
+	dst := make([]byte, 0, min(size, uint64(len(src))*8))
+
+	for len(src) > 0 {
+		op := src[0]
+		src = src[1:]
+		switch op {
+		case lzOpLiteral:
+			length, n := binary.Uvarint(src)
+			if n <= 0 || length > uint64(len(src)-n) {
+				return nil, corrupt
+			}
+			dst = append(dst, src[n:n+int(length)]...)
+			src = src[n+int(length):]
+		case lzOpCopy:
+			offset, n := binary.Uvarint(src)
+			if n <= 0 {
+				return nil, corrupt
+			}
+			length, m := binary.Uvarint(src[n:])
+			if m <= 0 || offset == 0 || offset > uint64(len(dst)) || length > size-uint64(len(dst)) {
+				return nil, corrupt
+			}
+			src = src[n+m:]
+
+			// Byte by byte, since the copy may overlap its own output
+			start := len(dst) - int(offset)
+			for i := range int(length) {
+				dst = append(dst, dst[start+i])
+			}
+		default:
+			return nil, corrupt
+		}
+	}
+
+	if uint64(len(dst)) != size {
+		return nil, corrupt
+	}
+	return dst, nil
+}
@@ -3,44 +3,139 @@ package sstable
 import (
 	"bytes"
 	"container/heap"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/thomazdavis/stratago/comparator"
+	"github.com/thomazdavis/stratago/kind"
 )
 
+// Source is a sorted stream of versioned entries that Merge can consume.
+// Entries must be ordered by key, and by descending sequence number within a key.
+type Source interface {
+	Next() bool
+	Key() []byte
+	Seq() uint64
+	Kind() kind.Kind
+	Value() []byte
+	Error() error
+}
+
+// MergeOptions controls which versions survive a merge
+type MergeOptions struct {
+	// Snapshots are the sequence numbers of live snapshots, in any order.
+	// The newest version visible to each snapshot is kept; every other
+	// shadowed version is dropped.
+	Snapshots []uint64
+
+	// TargetFileSize splits the output into several tables of roughly this
+	// many data bytes. Versions of one key never straddle two tables.
+	// Zero writes a single table.
+	TargetFileSize int64
+
+	// NextBuilder opens the next output table when the current one is full.
+	// It is required when TargetFileSize is set.
+	NextBuilder func() (*Builder, error)
+
+	// Bottommost tells the merge that no older data exists for the key range of
+	// its output. A tombstone every snapshot can see then has nothing left to
+	// hide, so it is dropped along with the versions it shadows.
+	Bottommost bool
+
+	// CompactionFilter, if set, may remove or rewrite each surviving value.
+	// A removed key becomes a tombstone, or disappears in a bottommost merge.
+	CompactionFilter CompactionFilter
+
+	// OutputLevel is passed to the CompactionFilter
+	OutputLevel int
+
+	// MergeOperator combines merge operands with the version below them. Operands
+	// left without a value below them are folded with PartialMerge, or into a value
+	// in a bottommost merge. Without an operator, operands are kept as they are.
+	MergeOperator MergeOperator
+
+	// Comparator is the order of the sources and of the output, Bytewise if nil
+	Comparator comparator.Comparator
+
+	// Now is the time expiring values are checked against, time.Now() if zero.
+	// An expired value is written as a tombstone, or dropped in a bottommost merge.
+	Now time.Time
+
+	// Stats, if set, receives the counters of the merge
+	Stats *MergeStats
+}
+
+// MergeStats describes what a merge discarded or rewrote
+type MergeStats struct {
+	// TombstonesDropped is the number of tombstones garbage collected by a bottommost merge
+	TombstonesDropped int
+
+	// FilterRemoved and FilterChanged count the values the CompactionFilter removed or rewrote
+	FilterRemoved int
+	FilterChanged int
+
+	// OperandsMerged counts the keys whose merge operands were combined into a value
+	OperandsMerged int
+
+	// Expired counts the expired values turned into tombstones or dropped
+	Expired int
+}
+
+// Add adds the counters of other to s
+func (s *MergeStats) Add(other MergeStats) {
+	s.TombstonesDropped += other.TombstonesDropped
+	s.FilterRemoved += other.FilterRemoved
+	s.FilterChanged += other.FilterChanged
+	s.OperandsMerged += other.OperandsMerged
+	s.Expired += other.Expired
+}
+
 type mergeItem struct {
 	key     []byte
+	seq     uint64
+	kind    kind.Kind
 	val     []byte
 	iterIdx int
-	iter    *Iterator
+	iter    Source
 }
 
-type mergeHeap []*mergeItem
+type mergeHeap struct {
+	items []*mergeItem
+	cmp   comparator.Comparator
+}
 
-func (h mergeHeap) Len() int {
-	return len(h)
+func (h *mergeHeap) Len() int {
+	return len(h.items)
 }
 
-func (h mergeHeap) Less(i, j int) bool {
-	cmp := bytes.Compare(h[i].key, h[j].key)
+func (h *mergeHeap) Less(i, j int) bool {
+	a, b := h.items[i], h.items[j]
+	cmp := h.cmp.Compare(a.key, b.key)
 	if cmp == 0 {
-		// If keys are identical, we pop the newer file first
+		// Newer versions of a key come first
+		if a.seq != b.seq {
+			return a.seq > b.seq
+		}
+		// If versions are identical, we pop the newer file first
 		// We pass iterators ordered from new to old
-		return h[i].iterIdx < h[j].iterIdx
+		return a.iterIdx < b.iterIdx
 	}
 	return cmp < 0
 }
 
-func (h mergeHeap) Swap(i, j int) {
-	h[i], h[j] = h[j], h[i]
+func (h *mergeHeap) Swap(i, j int) {
+	h.items[i], h.items[j] = h.items[j], h.items[i]
 }
 
 func (h *mergeHeap) Push(x any) {
-	*h = append(*h, x.(*mergeItem))
+	h.items = append(h.items, x.(*mergeItem))
 }
 
 func (h *mergeHeap) Pop() any {
-	old := *h
-	n := len(old)
-	item := old[n-1]
-	*h = old[0 : n-1]
+	n := len(h.items)
+	item := h.items[n-1]
+	h.items = h.items[:n-1]
 	return item
 }
 
@@ -48,52 +143,264 @@ func (h *mergeHeap) Pop() any {
 // Merge takes a list of Iterators (ordered from newest to oldest)
 // and writes their deduplicated, sorted contents to the builder.
 func Merge(iters []*Iterator, builder *Builder) error {
-	h := &mergeHeap{}
+	sources := make([]Source, len(iters))
+	for i, it := range iters {
+		sources[i] = it
+	}
+	return MergeWithOptions(sources, builder, MergeOptions{})
+}
+
+// MergeWithOptions merges any sorted sources (ordered from newest to oldest)
+// into the builder, keeping the versions still needed by live snapshots.
+func MergeWithOptions(sources []Source, builder *Builder, opts MergeOptions) error {
+	if opts.TargetFileSize > 0 && opts.NextBuilder == nil {
+		builder.cleanup()
+		return fmt.Errorf("merge: TargetFileSize requires NextBuilder")
+	}
+
+	var stats MergeStats
+	if opts.Stats != nil {
+		defer func() { *opts.Stats = stats }()
+	}
+
+	snapshots := append([]uint64{}, opts.Snapshots...)
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i] < snapshots[j] })
+	filterCtx := FilterContext{Bottommost: opts.Bottommost, OutputLevel: opts.OutputLevel}
+	now := opts.Now
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	// expired reports whether an Expiring value has passed its expiry
+	expired := func(val []byte) bool {
+		expiry, _ := kind.DecodeExpiring(val)
+		return expiry <= now.UnixNano()
+	}
+
+	h := &mergeHeap{cmp: orBytewise(opts.Comparator)}
 	heap.Init(h)
 
 	// Seed the heap with the first item from each file
-	for i, it := range iters {
+	for i, it := range sources {
 		if it.Next() {
 			heap.Push(h, &mergeItem{
 				key:     append([]byte{}, it.Key()...), // Copy to avoid memory mutation
+				seq:     it.Seq(),
+				kind:    it.Kind(),
 				val:     append([]byte{}, it.Value()...),
 				iterIdx: i,
 				iter:    it,
 			})
 		} else if err := it.Error(); err != nil {
+			builder.cleanup()
 			return err
 		}
 	}
 
 	var lastKey []byte
+	lastStripe := -1
+	hasLast := false
+	resolved := false           // The current key and stripe already wrote the version its snapshots see
+	var operands []mergeOperand // Merge operands of the current key and stripe, newest first
+
+	var lastWritten []byte
+	written := false
+
+	// write adds a surviving version to the output, after the compaction filter and tombstone GC
+	write := func(key []byte, seq uint64, stripe int, k kind.Kind, val []byte) error {
+		original := k
+
+		if k == kind.Expiring && expired(val) {
+			// Every reader sees an expired value as deleted from now on
+			k, val = kind.Delete, nil
+			stats.Expired++
+		}
+
+		// Only versions newer than every snapshot are filtered, so snapshots keep a stable view
+		if opts.CompactionFilter != nil && (k == kind.Value || k == kind.Expiring) && stripe == len(snapshots) {
+			expiry, value := int64(0), val
+			if k == kind.Expiring {
+				expiry, value = kind.DecodeExpiring(val)
+			}
+			decision, changed := opts.CompactionFilter.Filter(filterCtx, key, value)
+			switch decision {
+			case FilterKeep:
+			case FilterRemove:
+				// A tombstone keeps older versions of the key hidden
+				k, val = kind.Delete, nil
+				stats.FilterRemoved++
+			case FilterChange:
+				val = changed
+				if k == kind.Expiring {
+					val = kind.EncodeExpiring(expiry, changed)
+				}
+				stats.FilterChanged++
+			default:
+				builder.cleanup()
+				return fmt.Errorf("compaction filter: unknown decision %v", decision)
+			}
+		}
+
+		if opts.Bottommost && stripe == 0 && k == kind.Delete {
+			// Every snapshot sees the tombstone and nothing older lives below the
+			// output, so it goes away along with the older versions of its stripe
+			if original == kind.Delete {
+				stats.TombstonesDropped++
+			}
+			return nil
+		}
+
+		// Roll over to the next table only on a key boundary
+		if written && !bytes.Equal(lastWritten, key) && opts.TargetFileSize > 0 && builder.Size() >= opts.TargetFileSize {
+			if err := builder.Finish(); err != nil {
+				return err
+			}
+			next, err := opts.NextBuilder()
+			if err != nil {
+				return err
+			}
+			builder = next
+		}
+
+		// Write to the new SSTable
+		if err := builder.Add(key, seq, k, val); err != nil {
+			builder.cleanup()
+			return err
+		}
+		lastWritten = append(lastWritten[:0], key...)
+		written = true
+		return nil
+	}
+
+	// fullMerge applies the pending operands to base and writes the result under the newest operand
+	fullMerge := func(base []byte) error {
+		merged, err := opts.MergeOperator.FullMerge(lastKey, base, oldestFirst(operands))
+		if err != nil {
+			builder.cleanup()
+			return fmt.Errorf("merge operator: %w", err)
+		}
+		seq := operands[0].seq
+		operands = operands[:0]
+		stats.OperandsMerged++
+		return write(lastKey, seq, lastStripe, kind.Value, merged)
+	}
+
+	// finishOperands writes the operands of a key and stripe that ended without a value below them
+	finishOperands := func(keyEnds bool) error {
+		if len(operands) == 0 {
+			return nil
+		}
+		if opts.MergeOperator != nil {
+			if keyEnds && opts.Bottommost {
+				// Nothing older exists anywhere, so the operands apply to a missing key
+				return fullMerge(nil)
+			}
+			if len(operands) > 1 {
+				if merged, ok := opts.MergeOperator.PartialMerge(lastKey, oldestFirst(operands)); ok {
+					seq := operands[0].seq
+					operands = operands[:0]
+					return write(lastKey, seq, lastStripe, kind.Merge, merged)
+				}
+			}
+		}
+		for _, op := range operands {
+			if err := write(lastKey, op.seq, lastStripe, kind.Merge, op.val); err != nil {
+				return err
+			}
+		}
+		operands = operands[:0]
+		return nil
+	}
 
 	// Process the heap until all files are completely read
 	for h.Len() > 0 {
 		item := heap.Pop(h).(*mergeItem)
 
 		// Deduplication Logic
-		if lastKey == nil || !bytes.Equal(lastKey, item.key) {
-
-			// Write to the new SSTable
-			if err := builder.Add(item.key, item.val); err != nil {
+		// A version survives if it is the newest one of its key in its snapshot stripe,
+		// i.e. no newer version is visible to exactly the same set of snapshots.
+		// Merge operands on top of a stripe are combined with the version below them.
+		stripe := snapshotStripe(snapshots, item.seq)
+		newKey := !hasLast || !bytes.Equal(lastKey, item.key)
+		if newKey || stripe != lastStripe {
+			if err := finishOperands(newKey); err != nil {
 				return err
 			}
 
 			// Remember this key so we can skip older versions of it
 			// which might come in later iteratiosn
-			lastKey = append([]byte{}, item.key...)
+			lastKey = append(lastKey[:0], item.key...)
+			lastStripe = stripe
+			hasLast = true
+			resolved = false
+		}
+
+		if !resolved {
+			switch {
+			case item.kind == kind.Merge:
+				// The operand applies to whatever version of the stripe comes next
+				operands = append(operands, mergeOperand{seq: item.seq, val: item.val})
+			case len(operands) > 0 && opts.MergeOperator != nil && (item.kind != kind.Expiring || expired(item.val)):
+				// A value that has yet to expire keeps its operands apart, since
+				// reads after the expiry merge them into a missing key instead
+				var base []byte
+				if item.kind == kind.Value {
+					base = item.val
+				}
+				if err := fullMerge(base); err != nil {
+					return err
+				}
+				resolved = true
+			default:
+				// Without an operator the operands are kept in front of their base
+				if err := finishOperands(false); err != nil {
+					return err
+				}
+				if err := write(item.key, item.seq, stripe, item.kind, item.val); err != nil {
+					return err
+				}
+				resolved = true
+			}
 		}
 
 		// Advance the iterator that this item came from
 		if item.iter.Next() {
 			item.key = append([]byte{}, item.iter.Key()...)
+			item.seq = item.iter.Seq()
+			item.kind = item.iter.Kind()
 			item.val = append([]byte{}, item.iter.Value()...)
 			heap.Push(h, item)
 		} else if err := item.iter.Error(); err != nil {
+			builder.cleanup()
 			return err
 		}
 	}
 
+	if err := finishOperands(true); err != nil {
+		return err
+	}
+
 	// Finalize the new merged file
 	return builder.Finish()
 }
+
+// mergeOperand is a pending merge operand and its sequence number
+type mergeOperand struct {
+	seq uint64
+	val []byte
+}
+
+// oldestFirst returns the values of operands held newest first in the order a MergeOperator takes them
+func oldestFirst(operands []mergeOperand) [][]byte {
+	res := make([][]byte, len(operands))
+	for i, op := range operands {
+		res[len(operands)-1-i] = op.val
+	}
+	return res
+}
+
+// snapshotStripe returns the index of the oldest snapshot that can see seq.
+// Versions beyond every snapshot share the final stripe.
+func snapshotStripe(snapshots []uint64, seq uint64) int {
+	return sort.Search(len(snapshots), func(i int) bool { return snapshots[i] >= seq })
+}
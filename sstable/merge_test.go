@@ -2,9 +2,14 @@ package sstable
 
 import (
 	"bytes"
+	"fmt"
 	"os"
+	"strconv"
 	"testing"
+	"time"
 
+	"github.com/stretchr/testify/assert"
+	"github.com/thomazdavis/stratago/kind"
 	"github.com/thomazdavis/stratago/memtable"
 )
 
@@ -72,3 +77,391 @@ func TestMerge_Deduplication(t *testing.T) {
 		}
 	}
 }
+
+func TestMerge_KeepsSnapshotVersions(t *testing.T) {
+	list := memtable.NewSkipList()
+	list.PutVersion([]byte("k"), []byte("v10"), 10)
+	list.PutVersion([]byte("k"), []byte("v8"), 8)
+	list.PutVersion([]byte("k"), []byte("v5"), 5)
+	list.PutVersion([]byte("k"), []byte("v2"), 2)
+
+	builder, _ := NewBuilder("test_versions.sst")
+	builder.Flush(list)
+	defer os.Remove("test_versions.sst")
+
+	src, _ := NewReader("test_versions.sst")
+	defer src.Close()
+	iter, _ := src.NewIterator()
+	defer iter.Close()
+
+	// Snapshot 6 needs v5, snapshot 3 needs v2, v8 is shadowed by v10 for everyone newer
+	merged, _ := NewBuilder("test_versions_merged.sst")
+	err := MergeWithOptions([]Source{iter}, merged, MergeOptions{Snapshots: []uint64{6, 3}})
+	if err != nil {
+		t.Fatalf("Merge failed: %v", err)
+	}
+	defer os.Remove("test_versions_merged.sst")
+
+	reader, _ := NewReader("test_versions_merged.sst")
+	defer reader.Close()
+
+	out, _ := reader.NewIterator()
+	defer out.Close()
+	var seqs []uint64
+	for out.Next() {
+		seqs = append(seqs, out.Seq())
+	}
+	assert.Equal(t, []uint64{10, 5, 2}, seqs)
+
+	val, _, found, err := reader.GetAt([]byte("k"), 7)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, []byte("v5"), val)
+	assert.Equal(t, uint64(10), reader.MaxSequence())
+}
+
+func TestMerge_SplitsOutputOnKeyBoundaries(t *testing.T) {
+	list := memtable.NewSkipList()
+	value := bytes.Repeat([]byte("v"), 100)
+	for i := range 50 {
+		key := fmt.Appendf(nil, "key%02d", i)
+		list.PutVersion(key, value, uint64(2*i+2))
+		list.PutVersion(key, value, uint64(2*i+1))
+	}
+
+	builder, _ := NewBuilder("test_split_src.sst")
+	builder.Flush(list)
+	defer os.Remove("test_split_src.sst")
+
+	src, _ := NewReader("test_split_src.sst")
+	defer src.Close()
+	iter, _ := src.NewIterator()
+	defer iter.Close()
+
+	var paths []string
+	next := func() (*Builder, error) {
+		b, err := NewBuilder(fmt.Sprintf("test_split_%d.sst", len(paths)))
+		if err == nil {
+			paths = append(paths, b.Path())
+		}
+		return b, err
+	}
+	defer func() {
+		for _, p := range paths {
+			os.Remove(p)
+		}
+	}()
+
+	first, _ := next()
+	opts := MergeOptions{Snapshots: []uint64{99}, TargetFileSize: 1000, NextBuilder: next}
+	assert.NoError(t, MergeWithOptions([]Source{iter}, first, opts))
+	assert.Greater(t, len(paths), 1)
+
+	var prevLargest []byte
+	total := 0
+	for _, p := range paths {
+		r, err := NewReader(p)
+		assert.NoError(t, err)
+		defer r.Close()
+
+		if prevLargest != nil {
+			assert.Negative(t, bytes.Compare(prevLargest, r.Smallest()), "Tables must not overlap")
+		}
+		prevLargest = r.Largest()
+
+		all, _ := r.ReadAll()
+		total += len(all)
+	}
+	assert.Equal(t, 50, total)
+	assert.Equal(t, []byte("key49"), prevLargest)
+}
+
+func TestMerge_TargetFileSizeNeedsNextBuilder(t *testing.T) {
+	builder, _ := NewBuilder("test_split_invalid.sst")
+	err := MergeWithOptions(nil, builder, MergeOptions{TargetFileSize: 10})
+	assert.Error(t, err)
+
+	_, statErr := os.Stat("test_split_invalid.sst")
+	assert.True(t, os.IsNotExist(statErr))
+}
+
+func TestMerge_DropsTombstonesAtBottom(t *testing.T) {
+	list := memtable.NewSkipList()
+	list.DeleteVersion([]byte("a"), 5)
+	list.PutVersion([]byte("a"), []byte("old"), 3)
+	list.PutVersion([]byte("b"), []byte("live"), 4)
+	list.DeleteVersion([]byte("c"), 6)
+	list.PutVersion([]byte("c"), []byte("old"), 2)
+
+	builder, _ := NewBuilder("test_gc_src.sst")
+	builder.Flush(list)
+	defer os.Remove("test_gc_src.sst")
+
+	src, _ := NewReader("test_gc_src.sst")
+	defer src.Close()
+
+	merge := func(bottommost bool) ([]string, MergeStats) {
+		iter, _ := src.NewIterator()
+		defer iter.Close()
+
+		var stats MergeStats
+		out, _ := NewBuilder("test_gc_out.sst")
+		opts := MergeOptions{Snapshots: []uint64{5}, Bottommost: bottommost, Stats: &stats}
+		assert.NoError(t, MergeWithOptions([]Source{iter}, out, opts))
+		defer os.Remove("test_gc_out.sst")
+
+		reader, _ := NewReader("test_gc_out.sst")
+		defer reader.Close()
+		it, _ := reader.NewIterator()
+		defer it.Close()
+		var entries []string
+		for it.Next() {
+			entries = append(entries, fmt.Sprintf("%s@%d", it.Key(), it.Seq()))
+		}
+		return entries, stats
+	}
+
+	// Snapshot 5 sees the tombstone of a, but still needs the value c had before its delete
+	entries, stats := merge(true)
+	assert.Equal(t, []string{"b@4", "c@6", "c@2"}, entries)
+	assert.Equal(t, 1, stats.TombstonesDropped)
+
+	entries, stats = merge(false)
+	assert.Equal(t, []string{"a@5", "b@4", "c@6", "c@2"}, entries)
+	assert.Equal(t, 0, stats.TombstonesDropped)
+}
+
+func TestMerge_CompactionFilter(t *testing.T) {
+	list := memtable.NewSkipList()
+	list.PutVersion([]byte("keep"), []byte("1"), 7)
+	list.PutVersion([]byte("session:a"), []byte("expired"), 6)
+	list.PutVersion([]byte("session:a"), []byte("old"), 2)
+	list.PutVersion([]byte("tenant:b"), []byte("v"), 5)
+	list.PutVersion([]byte("upper"), []byte("abc"), 4)
+	list.PutVersion([]byte("upper"), []byte("snap"), 1)
+
+	builder, _ := NewBuilder("test_filter_src.sst")
+	builder.Flush(list)
+	defer os.Remove("test_filter_src.sst")
+
+	src, _ := NewReader("test_filter_src.sst")
+	defer src.Close()
+
+	var seen []string
+	filter := CompactionFilterFunc(func(ctx FilterContext, key, value []byte) (FilterDecision, []byte) {
+		assert.Equal(t, 3, ctx.OutputLevel)
+		seen = append(seen, string(key))
+		switch {
+		case bytes.HasPrefix(key, []byte("session:")), bytes.HasPrefix(key, []byte("tenant:")):
+			return FilterRemove, nil
+		case bytes.Equal(key, []byte("upper")):
+			return FilterChange, bytes.ToUpper(value)
+		}
+		return FilterKeep, nil
+	})
+
+	merge := func(opts MergeOptions) []string {
+		iter, _ := src.NewIterator()
+		defer iter.Close()
+
+		out, _ := NewBuilder("test_filter_out.sst")
+		assert.NoError(t, MergeWithOptions([]Source{iter}, out, opts))
+		defer os.Remove("test_filter_out.sst")
+
+		reader, _ := NewReader("test_filter_out.sst")
+		defer reader.Close()
+		it, _ := reader.NewIterator()
+		defer it.Close()
+		var entries []string
+		for it.Next() {
+			entries = append(entries, fmt.Sprintf("%s@%d=%s", it.Key(), it.Seq(), it.Value()))
+		}
+		return entries
+	}
+
+	// Snapshot 3 still reads the old versions, so removed keys are masked by tombstones
+	var stats MergeStats
+	entries := merge(MergeOptions{Snapshots: []uint64{3}, CompactionFilter: filter, OutputLevel: 3, Stats: &stats})
+	assert.Equal(t, []string{"keep@7=1", "session:a@6=", "session:a@2=old", "tenant:b@5=", "upper@4=ABC", "upper@1=snap"}, entries)
+	assert.Equal(t, MergeStats{FilterRemoved: 2, FilterChanged: 1}, stats)
+	assert.Equal(t, []string{"keep", "session:a", "tenant:b", "upper"}, seen, "Versions visible to a snapshot are not filtered")
+
+	// A bottommost merge without snapshots leaves nothing of the removed keys
+	entries = merge(MergeOptions{Bottommost: true, CompactionFilter: filter, OutputLevel: 3})
+	assert.Equal(t, []string{"keep@7=1", "upper@4=ABC"}, entries)
+}
+
+func TestMerge_CompactionFilterRejectsUnknownDecision(t *testing.T) {
+	list := memtable.NewSkipList()
+	list.PutVersion([]byte("k"), []byte("v"), 1)
+	builder, _ := NewBuilder("test_filter_bad_src.sst")
+	builder.Flush(list)
+	defer os.Remove("test_filter_bad_src.sst")
+
+	src, _ := NewReader("test_filter_bad_src.sst")
+	defer src.Close()
+	iter, _ := src.NewIterator()
+	defer iter.Close()
+
+	filter := CompactionFilterFunc(func(FilterContext, []byte, []byte) (FilterDecision, []byte) {
+		return FilterDecision(42), nil
+	})
+	out, _ := NewBuilder("test_filter_bad_out.sst")
+	err := MergeWithOptions([]Source{iter}, out, MergeOptions{CompactionFilter: filter})
+	assert.ErrorContains(t, err, "FilterDecision(42)")
+
+	_, statErr := os.Stat("test_filter_bad_out.sst")
+	assert.True(t, os.IsNotExist(statErr))
+}
+
+// sumOperator adds up decimal operands
+type sumOperator struct{}
+
+func (sumOperator) FullMerge(_, existing []byte, operands [][]byte) ([]byte, error) {
+	total := 0
+	if existing != nil {
+		n, err := strconv.Atoi(string(existing))
+		if err != nil {
+			return nil, err
+		}
+		total = n
+	}
+	partial, _ := sumOperator{}.PartialMerge(nil, operands)
+	n, _ := strconv.Atoi(string(partial))
+	return []byte(strconv.Itoa(total + n)), nil
+}
+
+func (sumOperator) PartialMerge(_ []byte, operands [][]byte) ([]byte, bool) {
+	total := 0
+	for _, operand := range operands {
+		n, _ := strconv.Atoi(string(operand))
+		total += n
+	}
+	return []byte(strconv.Itoa(total)), true
+}
+
+func TestMerge_MergeOperands(t *testing.T) {
+	list := memtable.NewSkipList()
+	list.Add([]byte("a"), 5, kind.Merge, []byte("1"))
+	list.Add([]byte("a"), 4, kind.Merge, []byte("2"))
+	list.PutVersion([]byte("a"), []byte("10"), 3)
+	list.Add([]byte("b"), 7, kind.Merge, []byte("1"))
+	list.Add([]byte("b"), 6, kind.Merge, []byte("1"))
+	list.Add([]byte("c"), 9, kind.Merge, []byte("5"))
+	list.DeleteVersion([]byte("c"), 8)
+	list.PutVersion([]byte("c"), []byte("100"), 1)
+
+	builder, _ := NewBuilder("test_operands_src.sst")
+	builder.Flush(list)
+	defer os.Remove("test_operands_src.sst")
+
+	src, _ := NewReader("test_operands_src.sst")
+	defer src.Close()
+
+	merge := func(opts MergeOptions) []string {
+		iter, _ := src.NewIterator()
+		defer iter.Close()
+
+		out, _ := NewBuilder("test_operands_out.sst")
+		assert.NoError(t, MergeWithOptions([]Source{iter}, out, opts))
+		defer os.Remove("test_operands_out.sst")
+
+		reader, _ := NewReader("test_operands_out.sst")
+		defer reader.Close()
+		it, _ := reader.NewIterator()
+		defer it.Close()
+		var entries []string
+		for it.Next() {
+			entries = append(entries, fmt.Sprintf("%s@%d:%s=%s", it.Key(), it.Seq(), it.Kind(), it.Value()))
+		}
+		return entries
+	}
+
+	// Operands resting on a value or tombstone become a value, the others are folded into one operand
+	var stats MergeStats
+	entries := merge(MergeOptions{MergeOperator: sumOperator{}, Stats: &stats})
+	assert.Equal(t, []string{"a@5:value=13", "b@7:merge=2", "c@9:value=5"}, entries)
+	assert.Equal(t, 2, stats.OperandsMerged)
+
+	// Nothing lies below a bottommost merge, so operands without a base apply to a missing key
+	entries = merge(MergeOptions{MergeOperator: sumOperator{}, Bottommost: true})
+	assert.Equal(t, []string{"a@5:value=13", "b@7:value=2", "c@9:value=5"}, entries)
+
+	// Snapshot 4 keeps reading 12 for a and 100 for c, while the operand above a stays unresolved
+	entries = merge(MergeOptions{MergeOperator: sumOperator{}, Snapshots: []uint64{4}})
+	assert.Equal(t, []string{"a@5:merge=1", "a@4:value=12", "b@7:merge=2", "c@9:value=5", "c@1:value=100"}, entries)
+
+	// Without an operator the operands are kept along with their base, and a tombstone
+	// below them is as good as a missing key once nothing older remains
+	entries = merge(MergeOptions{})
+	assert.Equal(t, []string{"a@5:merge=1", "a@4:merge=2", "a@3:value=10", "b@7:merge=1", "b@6:merge=1", "c@9:merge=5", "c@8:delete="}, entries)
+	entries = merge(MergeOptions{Bottommost: true})
+	assert.Equal(t, []string{"a@5:merge=1", "a@4:merge=2", "a@3:value=10", "b@7:merge=1", "b@6:merge=1", "c@9:merge=5"}, entries)
+}
+
+func TestMerge_ExpiredValues(t *testing.T) {
+	now := time.Unix(1000, 0)
+	past, future := now.Add(-time.Second).UnixNano(), now.Add(time.Hour).UnixNano()
+
+	list := memtable.NewSkipList()
+	list.Add([]byte("gone"), 4, kind.Expiring, kind.EncodeExpiring(past, []byte("v")))
+	list.PutVersion([]byte("gone"), []byte("old"), 2)
+	list.Add([]byte("hits"), 7, kind.Merge, []byte("1"))
+	list.Add([]byte("hits"), 6, kind.Expiring, kind.EncodeExpiring(future, []byte("5")))
+	list.Add([]byte("live"), 5, kind.Expiring, kind.EncodeExpiring(future, []byte("v")))
+	list.Add([]byte("reset"), 9, kind.Merge, []byte("1"))
+	list.Add([]byte("reset"), 8, kind.Expiring, kind.EncodeExpiring(past, []byte("5")))
+
+	builder, _ := NewBuilder("test_expired_src.sst")
+	builder.Flush(list)
+	defer os.Remove("test_expired_src.sst")
+
+	src, _ := NewReader("test_expired_src.sst")
+	defer src.Close()
+
+	merge := func(opts MergeOptions) []string {
+		iter, _ := src.NewIterator()
+		defer iter.Close()
+
+		out, _ := NewBuilder("test_expired_out.sst")
+		assert.NoError(t, MergeWithOptions([]Source{iter}, out, opts))
+		defer os.Remove("test_expired_out.sst")
+
+		reader, _ := NewReader("test_expired_out.sst")
+		defer reader.Close()
+		it, _ := reader.NewIterator()
+		defer it.Close()
+		var entries []string
+		for it.Next() {
+			val := it.Value()
+			if it.Kind() == kind.Expiring {
+				_, val = kind.DecodeExpiring(val)
+			}
+			entries = append(entries, fmt.Sprintf("%s@%d:%s=%s", it.Key(), it.Seq(), it.Kind(), val))
+		}
+		return entries
+	}
+
+	// An expired value hides older versions as a tombstone, a live one keeps its
+	// operands apart since they merge differently once it expires
+	var stats MergeStats
+	entries := merge(MergeOptions{Now: now, MergeOperator: sumOperator{}, Stats: &stats})
+	assert.Equal(t, []string{"gone@4:delete=", "hits@7:merge=1", "hits@6:expiring=5", "live@5:expiring=v", "reset@9:value=1"}, entries)
+	assert.Equal(t, 1, stats.Expired)
+	assert.Equal(t, 0, stats.TombstonesDropped)
+
+	entries = merge(MergeOptions{Now: now, MergeOperator: sumOperator{}, Bottommost: true})
+	assert.Equal(t, []string{"hits@7:merge=1", "hits@6:expiring=5", "live@5:expiring=v", "reset@9:value=1"}, entries)
+
+	// The filter sees the value without its expiry, and a rewrite keeps the expiry
+	filter := CompactionFilterFunc(func(_ FilterContext, key, value []byte) (FilterDecision, []byte) {
+		if bytes.Equal(key, []byte("live")) {
+			return FilterChange, bytes.ToUpper(value)
+		}
+		return FilterKeep, nil
+	})
+	entries = merge(MergeOptions{Now: now.Add(2 * time.Hour), CompactionFilter: filter, Bottommost: true})
+	assert.Equal(t, []string{"hits@7:merge=1", "reset@9:merge=1"}, entries)
+	entries = merge(MergeOptions{Now: now, CompactionFilter: filter})
+	assert.Contains(t, entries, "live@5:expiring=V")
+}
@@ -0,0 +1,15 @@
+package sstable
+
+// MergeOperator combines the operands written by db.Merge with the value they apply to,
+// so read-modify-write updates such as counters need no read before the write. Operands
+// are passed oldest first, and combining them must be deterministic: the same operands
+// may be merged by a read and again by a compaction.
+type MergeOperator interface {
+	// FullMerge applies operands to existing, the value key had before them,
+	// which is nil if the key had no value or was deleted
+	FullMerge(key, existing []byte, operands [][]byte) ([]byte, error)
+
+	// PartialMerge folds operands into a single operand without the value they apply to.
+	// It returns false if they cannot be combined that way, and they are then kept as they are.
+	PartialMerge(key []byte, operands [][]byte) ([]byte, bool)
+}
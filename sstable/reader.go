@@ -1,26 +1,54 @@
 package sstable
 
 import (
-	"bytes"
 	"encoding/binary"
+	"fmt"
 	"io"
+	"math"
 	"os"
-	"sync"
+
+	"github.com/thomazdavis/stratago/comparator"
+	"github.com/thomazdavis/stratago/kind"
 )
 
+// ReaderOptions controls how a Reader accesses its table
+type ReaderOptions struct {
+	// BlockCache holds recently read data blocks. It may be shared by any number
+	// of readers. Nil reads every block from the file.
+	BlockCache *BlockCache
+
+	// Comparator must be the one the table was written with, or opening it fails
+	// with comparator.ErrMismatch. Nil means Bytewise.
+	Comparator comparator.Comparator
+}
+
+// Reader serves lookups on one SSTable. Every read uses positional I/O on the
+// shared file handle, so a Reader is safe for concurrent use without locking.
 type Reader struct {
-	file  *os.File
-	index []IndexEntry
-	mu    sync.Mutex
+	file     *os.File
+	fileID   uint64 // Identifies the table in the block cache
+	cache    *BlockCache
+	cmp      comparator.Comparator
+	index    []IndexEntry
+	footer   footer
+	filter   *bloomFilter // nil if the table has no filter
+	size     int64
+	smallest []byte
+	largest  []byte
 }
 
 // Opens an existing SSTable for reading
 func NewReader(filename string) (*Reader, error) {
+	return NewReaderWithOptions(filename, ReaderOptions{})
+}
+
+// NewReaderWithOptions opens an existing SSTable for reading with the given options
+func NewReaderWithOptions(filename string, opts ReaderOptions) (*Reader, error) {
 	file, err := os.Open(filename)
 	if err != nil {
 		return nil, err
 	}
-	r := &Reader{file: file}
+	r := &Reader{file: file, fileID: nextFileID.Add(1), cache: opts.BlockCache, cmp: orBytewise(opts.Comparator)}
 	if err := r.loadIndex(); err != nil {
 		file.Close()
 		return nil, err
@@ -35,178 +63,357 @@ func (r *Reader) loadIndex() error {
 		return err
 	}
 	fileSize := stat.Size()
+	r.size = fileSize
 
-	if fileSize < 8 { // Footer fixed 8 bytes
+	// Read Footer
+	r.footer, err = readFooter(r.file, fileSize)
+	if err != nil {
+		return err
+	}
+	if fileSize < v0FooterSize {
 		return nil
 	}
 
-	// Read Footer
-	footer := make([]byte, 8)
-	if _, err := r.file.ReadAt(footer, fileSize-8); err != nil {
+	// Read Index block, which ends where the filter block starts. The comparator
+	// block, if any, ends where the footer starts.
+	blocksEnd := fileSize
+	if r.footer.version >= formatComparator {
+		blocksEnd = fileSize - footerSize
+	}
+	if r.footer.indexOffset < 0 || r.footer.filterOffset < r.footer.indexOffset ||
+		r.footer.comparatorOffset < r.footer.filterOffset || r.footer.comparatorOffset > blocksEnd {
+		return fmt.Errorf("%w: invalid footer offsets", ErrCorrupt)
+	}
+	if err := r.checkComparator(); err != nil {
 		return err
 	}
-	indexOffset := int64(binary.LittleEndian.Uint64(footer))
-
-	// Read Index block
-	if _, err := r.file.Seek(indexOffset, 0); err != nil {
+	buf := make([]byte, r.footer.filterOffset-r.footer.indexOffset)
+	if _, err := r.file.ReadAt(buf, r.footer.indexOffset); err != nil {
+		return err
+	}
+	if r.footer.version >= formatBlocks {
+		if buf, err = checkBlock(buf, r.footer.indexOffset); err != nil {
+			return err
+		}
+	}
+	if r.index, err = r.decodeIndex(buf); err != nil {
 		return err
 	}
 
-	var numEntries uint32
-	if err := binary.Read(r.file, binary.LittleEndian, &numEntries); err != nil {
+	if err := r.loadFilter(); err != nil {
 		return err
 	}
-	r.index = make([]IndexEntry, numEntries)
+	return r.loadKeyRange()
+}
 
-	for i := 0; i < int(numEntries); i++ {
-		var keyLen uint32
-		if err := binary.Read(r.file, binary.LittleEndian, &keyLen); err != nil {
-			return err
+// decodeIndex parses the index block. Older formats store bare offsets,
+// so each block is taken to run up to the start of the next one.
+func (r *Reader) decodeIndex(buf []byte) ([]IndexEntry, error) {
+	truncated := fmt.Errorf("%w: truncated index block", ErrCorrupt)
+	if len(buf) < 4 {
+		return nil, truncated
+	}
+	numEntries := binary.LittleEndian.Uint32(buf)
+	buf = buf[4:]
+
+	handleSize := 8
+	if r.footer.version >= formatBlocks {
+		handleSize = 12
+	}
+
+	var index []IndexEntry
+	for range numEntries {
+		if len(buf) < 4 {
+			return nil, truncated
+		}
+		keyLen := int(binary.LittleEndian.Uint32(buf))
+		if len(buf) < 4+keyLen+handleSize {
+			return nil, truncated
+		}
+		entry := IndexEntry{
+			Key:    append([]byte{}, buf[4:4+keyLen]...),
+			Offset: int64(binary.LittleEndian.Uint64(buf[4+keyLen:])),
+		}
+		if handleSize == 12 {
+			entry.Size = int64(binary.LittleEndian.Uint32(buf[12+keyLen:]))
+		}
+		index = append(index, entry)
+		buf = buf[4+keyLen+handleSize:]
+	}
+
+	if r.footer.version < formatBlocks {
+		for i := range index {
+			end := r.footer.indexOffset
+			if i+1 < len(index) {
+				end = index[i+1].Offset
+			}
+			index[i].Size = end - index[i].Offset
+		}
+	}
+
+	for _, entry := range index {
+		if entry.Offset < 0 || entry.Size < 0 || entry.Offset+entry.Size > r.footer.indexOffset {
+			return nil, fmt.Errorf("%w: block handle out of range", ErrCorrupt)
 		}
-		key := make([]byte, keyLen)
-		if _, err := io.ReadFull(r.file, key); err != nil {
+	}
+	return index, nil
+}
+
+// checkComparator fails unless the table was written with the reader's comparator.
+// Tables that predate the comparator block were all written in Bytewise order.
+func (r *Reader) checkComparator() error {
+	name := comparator.Bytewise.Name()
+	if r.footer.version >= formatComparator {
+		block := make([]byte, r.size-footerSize-r.footer.comparatorOffset)
+		if _, err := r.file.ReadAt(block, r.footer.comparatorOffset); err != nil {
 			return err
 		}
-		var offset int64
-		if err := binary.Read(r.file, binary.LittleEndian, &offset); err != nil {
+		contents, err := checkBlock(block, r.footer.comparatorOffset)
+		if err != nil {
 			return err
 		}
-		r.index[i] = IndexEntry{Key: key, Offset: offset}
+		name = string(contents)
+	}
+	if name != r.cmp.Name() {
+		return fmt.Errorf("%w: %s was written with %q, opened with %q", comparator.ErrMismatch, r.file.Name(), name, r.cmp.Name())
 	}
 	return nil
 }
 
-func (r *Reader) Close() error {
-	return r.file.Close()
+// loadFilter reads the Bloom filter block between the index and the comparator block
+func (r *Reader) loadFilter() error {
+	filterLen := r.footer.comparatorOffset - r.footer.filterOffset
+	if r.footer.version < formatFilter || filterLen <= 0 {
+		return nil
+	}
+
+	block := make([]byte, filterLen)
+	if _, err := r.file.ReadAt(block, r.footer.filterOffset); err != nil {
+		return err
+	}
+	if r.footer.version >= formatBlocks {
+		var err error
+		if block, err = checkBlock(block, r.footer.filterOffset); err != nil {
+			return err
+		}
+	}
+	r.filter = decodeBloomFilter(block)
+	return nil
 }
 
-// Get searches for a key in the SSTable
-func (r *Reader) Get(searchKey []byte) ([]byte, bool) {
-	r.mu.Lock()
-	defer r.mu.Unlock()
+// MayContain reports whether the table may hold key. False is definite and
+// costs no disk access; tables without a filter always return true.
+func (r *Reader) MayContain(key []byte) bool {
+	return r.filter == nil || r.filter.mayContain(key)
+}
 
-	startOffset := r.findIndexEntry(searchKey)
+// loadKeyRange records the smallest and largest keys in the table.
+// The smallest is the first index key; the largest is the last key of the last block.
+func (r *Reader) loadKeyRange() error {
+	if len(r.index) == 0 {
+		return nil
+	}
+	r.smallest = r.index[0].Key
 
-	_, err := r.file.Seek(startOffset, 0)
+	block, err := r.readBlock(r.file, len(r.index)-1)
 	if err != nil {
-		return nil, false
+		return err
 	}
-
-	for {
-		// Read key size (4 bytes)
-		var keySize uint32
-		err := binary.Read(r.file, binary.LittleEndian, &keySize)
-		if err == io.EOF {
-			break // Key not found
-		}
-		if err != nil {
-			return nil, false // file corrupted
+	for len(block) > 0 {
+		var key []byte
+		if _, key, _, block, err = decodeEntry(r.footer.version, block); err != nil {
+			return err
 		}
+		r.largest = append(r.largest[:0], key...)
+	}
+	return nil
+}
 
-		var valSize uint32
-		err = binary.Read(r.file, binary.LittleEndian, &valSize)
-		if err != nil {
-			return nil, false
-		}
+// readBlock returns the i-th data block from the cache, or reads it through f.
+// Blocks may be shared with other callers and must not be modified.
+func (r *Reader) readBlock(f io.ReaderAt, i int) ([]byte, error) {
+	if r.cache == nil {
+		return r.loadBlock(f, i)
+	}
 
-		// Read Key Payload
-		key := make([]byte, keySize)
-		if _, err := io.ReadFull(r.file, key); err != nil {
-			return nil, false
-		}
+	key := cacheKey{fileID: r.fileID, offset: r.index[i].Offset}
+	if block, ok := r.cache.get(key); ok {
+		return block, nil
+	}
+	block, err := r.loadBlock(f, i)
+	if err != nil {
+		return nil, err
+	}
+	r.cache.put(key, block)
+	return block, nil
+}
 
-		cmp := bytes.Compare(key, searchKey)
+// loadBlock reads the i-th data block through f, verifies its checksum and decompresses it
+func (r *Reader) loadBlock(f io.ReaderAt, i int) ([]byte, error) {
+	h := r.index[i]
+	trailer := int64(0)
+	switch {
+	case r.footer.version >= formatCompressed:
+		trailer = 1 + blockTrailerSize
+	case r.footer.version >= formatBlocks:
+		trailer = blockTrailerSize
+	}
 
-		if cmp == 0 {
-			val := make([]byte, valSize)
-			if _, err := io.ReadFull(r.file, val); err != nil {
-				return nil, false
-			}
-			return val, true
-		} else if cmp > 0 {
-			return nil, false
+	buf := make([]byte, h.Size+trailer)
+	if _, err := f.ReadAt(buf, h.Offset); err != nil {
+		if err == io.EOF {
+			return nil, fmt.Errorf("%w: block at offset %d is truncated", ErrCorrupt, h.Offset)
 		}
+		return nil, err
+	}
+	if trailer == 0 {
+		return buf, nil
+	}
 
-		_, err = r.file.Seek(int64(valSize), 1)
-		if err != nil {
-			return nil, false
-		}
+	contents, err := checkBlock(buf, h.Offset)
+	if err != nil || r.footer.version < formatCompressed {
+		return contents, err
 	}
-	return nil, false
+	codec := Compression(contents[len(contents)-1])
+	return decompressBlock(codec, contents[:len(contents)-1])
 }
 
-func (r *Reader) Path() string {
-	return r.file.Name()
+// Smallest returns the first key in the table, or nil if it is empty
+func (r *Reader) Smallest() []byte {
+	return r.smallest
 }
 
-// ReadAll retrieves all key-value pairs from the SSTable file.
-func (r *Reader) ReadAll() (map[string][]byte, error) {
-	r.mu.Lock()
-	defer r.mu.Unlock()
+// Largest returns the last key in the table, or nil if it is empty
+func (r *Reader) Largest() []byte {
+	return r.largest
+}
 
-	if _, err := r.file.Seek(0, 0); err != nil {
-		return nil, err
-	}
+// Size returns the size of the table file in bytes
+func (r *Reader) Size() int64 {
+	return r.size
+}
 
-	data := make(map[string][]byte)
+func (r *Reader) Close() error {
+	return r.file.Close()
+}
 
-	stat, _ := r.file.Stat()
-	fileSize := stat.Size()
-	limit := fileSize
+// Get searches for the newest value of a key in the SSTable.
+// A deleted key, or one in a corrupt block, is not found.
+func (r *Reader) Get(searchKey []byte) ([]byte, bool) {
+	val, k, found, err := r.GetAt(searchKey, math.MaxUint64)
+	if err != nil || !found || k == kind.Delete {
+		return nil, false
+	}
+	return val, true
+}
+
+// GetAt searches for the newest version of a key with a sequence number <= seq.
+// A tombstone is found with kind.Delete. Blocks failing their checksum return ErrCorrupt.
+func (r *Reader) GetAt(searchKey []byte, seq uint64) ([]byte, kind.Kind, bool, error) {
+	var val []byte
+	k, found := kind.Delete, false
+	err := r.Versions(searchKey, seq, func(_ uint64, vk kind.Kind, v []byte) bool {
+		val, k, found = append([]byte{}, v...), vk, true
+		return false
+	})
+	if err != nil {
+		return nil, kind.Delete, false, err
+	}
+	return val, k, found, nil
+}
 
-	if fileSize > 8 {
-		footer := make([]byte, 8)
-		r.file.ReadAt(footer, fileSize-8)
-		limit = int64(binary.LittleEndian.Uint64(footer))
+// Versions calls fn with every version of a key whose sequence number is <= seq,
+// newest first, until fn returns false. value is only valid during the call.
+func (r *Reader) Versions(searchKey []byte, seq uint64, fn func(seq uint64, k kind.Kind, value []byte) bool) error {
+	// The filter rules out most missing keys without touching the data section
+	if !r.MayContain(searchKey) {
+		return nil
 	}
 
-	// Reset seek
-	r.file.Seek(0, 0)
-	currentPos := int64(0)
+	// Versions of a key may continue into the following blocks
+	for i := r.findBlock(searchKey); i < len(r.index); i++ {
+		block, err := r.readBlock(r.file, i)
+		if err != nil {
+			return err
+		}
 
-	for currentPos < limit {
-		var keySize, valSize uint32
-		if err := binary.Read(r.file, binary.LittleEndian, &keySize); err != nil {
-			if err == io.EOF {
-				break
+		for len(block) > 0 {
+			var header entryHeader
+			var key, val []byte
+			if header, key, val, block, err = decodeEntry(r.footer.version, block); err != nil {
+				return err
 			}
-			return nil, err
-		}
-		if err := binary.Read(r.file, binary.LittleEndian, &valSize); err != nil {
-			return nil, err
-		}
 
-		key := make([]byte, keySize)
-		if _, err := io.ReadFull(r.file, key); err != nil {
-			return nil, err
+			cmp := r.cmp.Compare(key, searchKey)
+			if cmp == 0 && header.seq <= seq {
+				if !fn(header.seq, header.kind, val) {
+					return nil
+				}
+			} else if cmp > 0 {
+				return nil
+			}
 		}
+	}
+	return nil
+}
+
+// MaxSequence returns the highest sequence number stored in the SSTable
+func (r *Reader) MaxSequence() uint64 {
+	return r.footer.maxSeq
+}
+
+func (r *Reader) Path() string {
+	return r.file.Name()
+}
 
-		val := make([]byte, valSize)
-		if _, err := io.ReadFull(r.file, val); err != nil {
+// ReadAll retrieves the newest version of every key in the SSTable file.
+// Deleted keys are present with a nil value.
+func (r *Reader) ReadAll() (map[string][]byte, error) {
+	data := make(map[string][]byte)
+	for i := range r.index {
+		block, err := r.readBlock(r.file, i)
+		if err != nil {
 			return nil, err
 		}
 
-		data[string(key)] = val
-		currentPos += int64(8 + keySize + valSize)
+		for len(block) > 0 {
+			var header entryHeader
+			var key, val []byte
+			if header, key, val, block, err = decodeEntry(r.footer.version, block); err != nil {
+				return nil, err
+			}
+			if header.kind == kind.Delete {
+				val = nil
+			} else {
+				val = append([]byte{}, val...)
+			}
+
+			// Versions are stored newest first, keep the first one we see
+			if _, exists := data[string(key)]; !exists {
+				data[string(key)] = val
+			}
+		}
 	}
 	return data, nil
 }
 
-func (r *Reader) findIndexEntry(searchKey []byte) int64 {
-	if len(r.index) == 0 {
-		return 0
-	}
+// findBlock returns the position of the block a search for searchKey starts in
+func (r *Reader) findBlock(searchKey []byte) int {
+	return findIndexEntry(r.cmp, r.index, searchKey)
+}
 
+// findIndexEntry returns the position of the last index entry whose key is < searchKey.
+// Versions of one key can straddle blocks, so a block starting with searchKey
+// may already be past the newest version and cannot be used as the start.
+func findIndexEntry(cmp comparator.Comparator, index []IndexEntry, searchKey []byte) int {
 	// Binary search
-	left, right := 0, len(r.index)-1
-	result := int64(0)
+	left, right := 0, len(index)-1
+	result := 0
 
 	for left <= right {
 		mid := (left + right) / 2
-		cmp := bytes.Compare(r.index[mid].Key, searchKey)
-
-		if cmp <= 0 {
-			result = r.index[mid].Offset
+		if cmp.Compare(index[mid].Key, searchKey) < 0 {
+			result = mid
 			left = mid + 1
 		} else {
 			right = mid - 1
@@ -214,3 +421,11 @@ func (r *Reader) findIndexEntry(searchKey []byte) int64 {
 	}
 	return result
 }
+
+// orBytewise returns c, or Bytewise if c is nil
+func orBytewise(c comparator.Comparator) comparator.Comparator {
+	if c == nil {
+		return comparator.Bytewise
+	}
+	return c
+}
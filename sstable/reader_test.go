@@ -3,9 +3,11 @@ package sstable
 import (
 	"fmt"
 	"os"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/thomazdavis/stratago/comparator"
 	"github.com/thomazdavis/stratago/memtable"
 )
 
@@ -83,15 +85,14 @@ func TestReader_SparseIndex_LargeFile(t *testing.T) {
 	assert.Equal(t, []byte("val-050"), val)
 }
 
-func TestReader_EarlyExit_CheckOffset(t *testing.T) {
+func TestReader_DoesNotMoveFileOffset(t *testing.T) {
 	filename := "test_offset_check.sst"
 	defer os.Remove(filename)
 
 	list := memtable.NewSkipList()
-	list.Put([]byte("A"), []byte("val")) // Read 1
-	list.Put([]byte("C"), []byte("val")) // Read 2 (Stop here)
-	list.Put([]byte("E"), []byte("val")) // Should NOT Read
-	list.Put([]byte("G"), []byte("val")) // Should NOT Read
+	list.Put([]byte("A"), []byte("val"))
+	list.Put([]byte("C"), []byte("val"))
+	list.Put([]byte("E"), []byte("val"))
 
 	builder, _ := NewBuilder(filename)
 	builder.Flush(list)
@@ -99,15 +100,43 @@ func TestReader_EarlyExit_CheckOffset(t *testing.T) {
 	reader, _ := NewReader(filename)
 	defer reader.Close()
 
-	// Search for "B" (Between A and C)
+	// Every read is positional, so the shared offset never leaves the start
 	reader.Get([]byte("B"))
+	reader.Get([]byte("E"))
+	reader.ReadAll()
 
-	// Check where the file pointer stopped
 	currentPos, _ := reader.file.Seek(0, 1) // 1 = SeekCurrent
+	assert.Equal(t, int64(0), currentPos)
+}
+
+func TestReader_ConcurrentGets(t *testing.T) {
+	filename := "test_concurrent_read.sst"
+	defer os.Remove(filename)
+
+	list := memtable.NewSkipList()
+	for i := range 1000 {
+		list.Put([]byte(fmt.Sprintf("key-%04d", i)), []byte(fmt.Sprintf("val-%04d", i)))
+	}
+	builder, _ := NewBuilder(filename)
+	assert.NoError(t, builder.Flush(list))
 
-	stat, _ := reader.file.Stat()
+	reader, err := NewReader(filename)
+	assert.NoError(t, err)
+	defer reader.Close()
 
-	assert.True(t, currentPos < stat.Size()-8, "File pointer should assume early exit and not reach EOF")
+	var wg sync.WaitGroup
+	for g := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := g; i < 1000; i += 8 {
+				val, found := reader.Get([]byte(fmt.Sprintf("key-%04d", i)))
+				assert.True(t, found)
+				assert.Equal(t, []byte(fmt.Sprintf("val-%04d", i)), val)
+			}
+		}()
+	}
+	wg.Wait()
 }
 
 func BenchmarkReader_Get(b *testing.B) {
@@ -132,3 +161,72 @@ func BenchmarkReader_Get(b *testing.B) {
 		reader.Get([]byte("key-09999"))
 	}
 }
+
+func BenchmarkReader_GetParallel(b *testing.B) {
+	filename := "bench_read_parallel.sst"
+	defer os.Remove(filename)
+
+	list := memtable.NewSkipList()
+	for i := 0; i < 10000; i++ {
+		list.Put([]byte(fmt.Sprintf("key-%05d", i)), []byte("value"))
+	}
+
+	builder, _ := NewBuilder(filename)
+	builder.Flush(list)
+
+	reader, _ := NewReader(filename)
+	defer reader.Close()
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			reader.Get([]byte(fmt.Sprintf("key-%05d", i%10000)))
+			i += 7
+		}
+	})
+}
+
+func TestReader_Comparator(t *testing.T) {
+	filename := "test_comparator.sst"
+	defer os.Remove(filename)
+
+	list := memtable.NewSkipListWithComparator(comparator.Numeric)
+	for i := range 200 {
+		list.Put(fmt.Appendf(nil, "%d", i), fmt.Appendf(nil, "val%d", i))
+	}
+
+	// Small blocks so lookups have to search the index in numeric order
+	builder, err := NewBuilderWithOptions(filename, Options{IndexInterval: 64, Comparator: comparator.Numeric})
+	assert.NoError(t, err)
+	assert.NoError(t, builder.Flush(list))
+
+	reader, err := NewReaderWithOptions(filename, ReaderOptions{Comparator: comparator.Numeric})
+	assert.NoError(t, err)
+	defer reader.Close()
+	assert.Equal(t, []byte("0"), reader.Smallest())
+	assert.Equal(t, []byte("199"), reader.Largest())
+	for i := range 200 {
+		val, found := reader.Get(fmt.Appendf(nil, "%d", i))
+		assert.True(t, found, i)
+		assert.Equal(t, fmt.Appendf(nil, "val%d", i), val)
+	}
+
+	it, err := reader.NewIterator()
+	assert.NoError(t, err)
+	defer it.Close()
+	assert.True(t, it.SeekGE([]byte("99")))
+	assert.Equal(t, []byte("99"), it.Key())
+	assert.True(t, it.Next())
+	assert.Equal(t, []byte("100"), it.Key())
+
+	// The table remembers its order, a reader with another one is refused
+	_, err = NewReader(filename)
+	assert.ErrorIs(t, err, comparator.ErrMismatch)
+
+	legacy := "test_comparator_legacy.sst"
+	defer os.Remove(legacy)
+	writeLegacyTable(t, legacy)
+	_, err = NewReaderWithOptions(legacy, ReaderOptions{Comparator: comparator.Numeric})
+	assert.ErrorIs(t, err, comparator.ErrMismatch, "Tables without a comparator block are bytewise")
+}
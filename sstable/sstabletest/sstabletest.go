@@ -0,0 +1,35 @@
+// Package sstabletest writes SSTables in layouts the current Builder no longer produces,
+// so tests can check that tables written by older releases are still readable.
+package sstabletest
+
+import (
+	"encoding/binary"
+	"os"
+)
+
+// WriteV0Table writes sorted key-value pairs the way the Builder of the original release did:
+// [KeySize(4B)][ValSize(4B)][Key][Value] entries without sequence numbers, empty values as
+// tombstones, an index entry every interval bytes and an [IndexOffset(8B)] footer
+func WriteV0Table(filename string, interval int, kvs ...string) error {
+	var data, index []byte
+	entries, lastIndexPos := 0, 0
+	for i := 0; i < len(kvs); i += 2 {
+		if len(data) == 0 || len(data)-lastIndexPos >= interval {
+			index = binary.LittleEndian.AppendUint32(index, uint32(len(kvs[i])))
+			index = append(index, kvs[i]...)
+			index = binary.LittleEndian.AppendUint64(index, uint64(len(data)))
+			lastIndexPos = len(data)
+			entries++
+		}
+		data = binary.LittleEndian.AppendUint32(data, uint32(len(kvs[i])))
+		data = binary.LittleEndian.AppendUint32(data, uint32(len(kvs[i+1])))
+		data = append(data, kvs[i]...)
+		data = append(data, kvs[i+1]...)
+	}
+
+	indexOffset := len(data)
+	data = binary.LittleEndian.AppendUint32(data, uint32(entries))
+	data = append(data, index...)
+	data = binary.LittleEndian.AppendUint64(data, uint64(indexOffset))
+	return os.WriteFile(filename, data, 0644)
+}
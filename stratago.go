@@ -4,10 +4,12 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
-	"sort"
-	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/thomazdavis/stratago/kind"
+	"github.com/thomazdavis/stratago/manifest"
 	"github.com/thomazdavis/stratago/memtable"
 	"github.com/thomazdavis/stratago/sstable"
 	"github.com/thomazdavis/stratago/wal"
@@ -16,204 +18,317 @@ import (
 const DefaultMemtableThreshold = 4 * 1024 * 1024 // 4MB
 
 type StrataGo struct {
-	mu                sync.RWMutex
-	activeMemtable    *memtable.SkipList
-	immutableMemtable *memtable.SkipList
-	wal               *wal.WAL
-	sstReaders        []*sstable.Reader
-	dataDir           string
-	flushChan         chan struct{}
-	closeChan         chan struct{}
-	wg                sync.WaitGroup
-	closed            bool
+	mu         sync.RWMutex
+	writeMu    sync.Mutex    // Held by the commit leader and by WAL rotation
+	queueMu    sync.Mutex    // Guards the commit queue
+	writers    []*writer     // Commit queue, the head is the leader
+	lastSeq    atomic.Uint64 // Sequence number of the last published write
+	snapshots  map[uint64]int
+	families   map[uint32]*ColumnFamily // Live column families by id, guarded by mu
+	defaultCF  *ColumnFamily
+	wal        *wal.WAL            // Current WAL segment
+	recovery   wal.RecoveryReport  // What Open discarded from damaged WAL segments
+	mergeStats sstable.MergeStats  // Totals of every compaction since Open, guarded by mu
+	blockCache *sstable.BlockCache // Shared by every reader, nil if disabled
+	manifest   *manifest.Manifest  // Records which tables are live
+	compactMu  sync.Mutex          // Serializes compactions
+	dataDir    string
+	opts       *Options
+	now        func() time.Time // Clock that expiring values are checked against
+	flushChan  chan struct{}
+	closeChan  chan struct{}
+	wg         sync.WaitGroup
+	closed     bool
 }
 
-type sstableInfo struct {
-	path      string
-	timestamp int64
+// Open opens the database in dataDir with DefaultOptions
+func Open(dataDir string) (*StrataGo, error) {
+	return OpenWithOptions(dataDir, nil)
 }
 
-func Open(dataDir string) (*StrataGo, error) {
+// OpenWithOptions opens the database in dataDir. Unset fields in opts fall back
+// to their defaults, and a nil opts is the same as calling Open.
+func OpenWithOptions(dataDir string, opts *Options) (*StrataGo, error) {
+	opts = opts.withDefaults()
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+
 	if err := os.MkdirAll(dataDir, 0755); err != nil {
 		return nil, err
 	}
 
-	mem := memtable.NewSkipList()
-	walPath := filepath.Join(dataDir, "wal.log")
-	walLog, err := wal.NewWAL(walPath)
+	m, err := openManifest(dataDir, opts.Comparator)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to open manifest: %w", err)
 	}
 
-	// Crash protection
-	flushingPath := filepath.Join(dataDir, "wal.log.flushing")
-	if _, err := os.Stat(flushingPath); err == nil {
-		// Found an abandoned log, recovering it into the memory
-		tempWAL, err := wal.NewWAL(flushingPath)
-		if err == nil {
-			restored, err := tempWAL.Recover()
-			if err != nil {
-				tempWAL.Close()
-				fmt.Printf("Warning: partial recovery from flushing WAL: %v\n", err)
-			}
-			for k, v := range restored {
-				mem.Put([]byte(k), v)
+	// Tables and WAL segments the manifest no longer needs are leftovers of a crash
+	removeObsoleteFiles(dataDir, m)
 
-				if err := walLog.WriteEntry([]byte(k), v); err != nil {
-					return nil, fmt.Errorf("failed to persist recovered data: %w", err)
-				}
-			}
-			tempWAL.Close()
-			os.Remove(flushingPath)
-		}
+	// Every family gets its own memtable, and the writes of dropped ones are skipped
+	families := m.Families()
+	mems := map[uint32]*memtable.SkipList{0: memtable.NewSkipListWithComparator(opts.Comparator)}
+	logNumbers := map[uint32]uint64{0: m.LogNumber()}
+	for _, f := range families {
+		mems[f.ID] = memtable.NewSkipListWithComparator(opts.Comparator)
+		logNumbers[f.ID] = f.LogNumber
 	}
-
-	restored, err := walLog.Recover()
+	segments, walSeq, report, err := recoverLogs(dataDir, m, mems, opts.WALRecoveryMode)
 	if err != nil {
+		m.Close()
 		return nil, fmt.Errorf("WAL recovery failed: %w", err)
 	}
-
-	for k, v := range restored {
-		mem.Put([]byte(k), v)
+	for _, c := range report.Corruptions {
+		fmt.Printf("Warning: discarded %d damaged WAL bytes at offset %d of %s\n", c.Size, c.Offset, c.Path)
 	}
 
-	files, err := os.ReadDir(dataDir)
+	// New writes go to a fresh segment rather than after a possibly torn tail
+	walNumber := m.NewFileNumber()
+	walLog, err := wal.NewWAL(filepath.Join(dataDir, logFileName(walNumber)))
 	if err != nil {
-		return nil, fmt.Errorf("failed to read data directory: %w", err)
-	}
-	var sstables []sstableInfo
-
-	for _, f := range files {
-		if strings.HasSuffix(f.Name(), ".sst") {
-			var ts int64
-			fmt.Sscanf(f.Name(), "data_%d.sst", &ts)
-			sstables = append(sstables, sstableInfo{
-				path:      filepath.Join(dataDir, f.Name()),
-				timestamp: ts,
-			})
+		m.Close()
+		return nil, err
+	}
+	empty := true
+	for id, mem := range mems {
+		if mem.Size == 0 {
+			logNumbers[id] = walNumber
+		} else {
+			empty = false
+			if len(segments) > 0 {
+				logNumbers[id] = max(logNumbers[id], segments[0])
+			}
+		}
+	}
+	if empty {
+		// Nothing to flush, the recovered segments can go right away
+		for _, number := range segments {
+			os.Remove(filepath.Join(dataDir, logFileName(number)))
 		}
 	}
 
-	sort.Slice(sstables, func(i, j int) bool {
-		return sstables[i].timestamp < sstables[j].timestamp
-	})
+	blockCache := opts.newBlockCache()
+	familyOpts := map[uint32]*Options{0: opts}
+	for _, f := range families {
+		familyOpts[f.ID] = opts.familyOptions(f.Name)
+	}
+	familyLevels := make(map[uint32][][]*sstable.Reader)
+	for id, o := range familyOpts {
+		familyLevels[id] = make([][]*sstable.Reader, o.MaxLevels)
+	}
+	closeAll := func() {
+		for _, levels := range familyLevels {
+			for _, level := range levels {
+				for _, r := range level {
+					r.Close()
+				}
+			}
+		}
+	}
 
-	var readers []*sstable.Reader
-	for _, sst := range sstables {
-		r, err := sstable.NewReader(sst.path)
-		if err == nil {
-			readers = append(readers, r)
+	lastSeq := max(walSeq, m.LastSequence())
+	for _, f := range m.Files() {
+		r, err := sstable.NewReaderWithOptions(filepath.Join(dataDir, tableFileName(f.Number)), sstable.ReaderOptions{BlockCache: blockCache, Comparator: opts.Comparator})
+		if err != nil {
+			closeAll()
+			walLog.Close()
+			m.Close()
+			return nil, fmt.Errorf("failed to open table %d: %w", f.Number, err)
+		}
+		levels := familyLevels[f.Family]
+		for f.Level >= len(levels) {
+			levels = append(levels, nil)
+		}
+		levels[f.Level] = append(levels[f.Level], r)
+		familyLevels[f.Family] = levels
+		lastSeq = max(lastSeq, r.MaxSequence())
+	}
+	for _, levels := range familyLevels {
+		sortBySequence(levels[0])
+		for level := 1; level < len(levels); level++ {
+			sortByKey(opts.Comparator, levels[level])
 		}
 	}
 
 	db := &StrataGo{
-		activeMemtable: mem,
-		wal:            walLog,
-		sstReaders:     readers,
-		dataDir:        dataDir,
-		flushChan:      make(chan struct{}, 1),
-		closeChan:      make(chan struct{}),
-		closed:         false,
+		families:   make(map[uint32]*ColumnFamily),
+		wal:        walLog,
+		recovery:   report,
+		blockCache: blockCache,
+		manifest:   m,
+		dataDir:    dataDir,
+		opts:       opts,
+		now:        time.Now,
+		snapshots:  make(map[uint64]int),
+		flushChan:  make(chan struct{}, 1),
+		closeChan:  make(chan struct{}),
+		closed:     false,
+	}
+	db.defaultCF = db.newColumnFamily(0, DefaultColumnFamilyName, opts, mems[0], familyLevels[0])
+	db.families[0] = db.defaultCF
+	for _, f := range families {
+		db.families[f.ID] = db.newColumnFamily(f.ID, f.Name, familyOpts[f.ID], mems[f.ID], familyLevels[f.ID])
+	}
+	for id, cf := range db.families {
+		cf.logNumber, cf.keepLog = logNumbers[id], logNumbers[id]
 	}
+	db.lastSeq.Store(lastSeq)
 
-	db.wg.Add(2) // two worker - flush + compaction
-	go db.flushWorker()
-	go db.compactionWorker()
+	db.startWorkers()
 
 	return db, nil
 }
 
 func (db *StrataGo) Put(key, value []byte) error {
+	return db.PutWithOptions(key, value, nil)
+}
 
-	db.mu.Lock()
-	if db.closed {
-		db.mu.Unlock()
-		return fmt.Errorf("database is closed")
-	}
-	db.mu.Unlock()
+// PutWithOptions writes a key-value pair with per-write durability options.
+// A nil wo behaves like Put.
+func (db *StrataGo) PutWithOptions(key, value []byte, wo *WriteOptions) error {
+	return db.apply([]wal.Entry{{Kind: kind.Value, Key: key, Value: value}}, wo)
+}
 
-	if err := db.wal.WriteEntry(key, value); err != nil {
-		return err
+// PutWithTTL writes a key-value pair that expires ttl from now. Once it has
+// expired the key reads as deleted, and compactions drop it.
+func (db *StrataGo) PutWithTTL(key, value []byte, ttl time.Duration) error {
+	if ttl <= 0 {
+		return fmt.Errorf("invalid TTL %v: must be positive", ttl)
 	}
+	expiry := db.now().Add(ttl).UnixNano()
+	return db.apply([]wal.Entry{{Kind: kind.Expiring, Key: key, Value: kind.EncodeExpiring(expiry, value)}}, nil)
+}
 
-	db.mu.Lock()
-	db.activeMemtable.Put(key, value)
+// Get returns the value of key. A key whose lookup fails, such as on a damaged
+// table, reads as missing; GetWithOptions reports the error instead.
+func (db *StrataGo) Get(key []byte) ([]byte, bool) {
+	val, found, _ := db.GetWithOptions(key, nil)
+	return val, found
+}
 
-	needsFlush := db.activeMemtable.SizeBytes >= DefaultMemtableThreshold
+// GetWithOptions returns the value of key, or the error that kept it from being read.
+// A table failing its checksum is reported as sstable.ErrCorrupt. A nil ro behaves like Get.
+func (db *StrataGo) GetWithOptions(key []byte, ro *ReadOptions) ([]byte, bool, error) {
+	return db.GetCFWithOptions(db.defaultCF, key, ro)
+}
 
-	if needsFlush {
-		select {
-		case db.flushChan <- struct{}{}:
-			// Signal to flush sent
-		default:
-			// Channel is full (Flush already pending/running)
-			// Ignoring request
-		}
+// readSequence returns the sequence number a lookup with ro reads as of. Without a
+// snapshot it is the last published write, so a group that is still being applied
+// to the memtables stays invisible until all of it is.
+func (db *StrataGo) readSequence(ro *ReadOptions) uint64 {
+	if ro != nil && ro.Snapshot != nil {
+		return ro.Snapshot.seq
 	}
-	db.mu.Unlock()
-
-	return nil
+	return db.lastSeq.Load()
 }
 
-func (db *StrataGo) Get(key []byte) ([]byte, bool) {
-	db.mu.RLock()
-	defer db.mu.RUnlock()
+// get returns the newest version of key in cf with a sequence number <= seq, with
+// the merge operands on top of it applied. An expired value reads as deleted. It
+// fails if cf is not a live family of the DB or a table cannot be read.
+func (db *StrataGo) get(cf *ColumnFamily, key []byte, seq uint64) ([]byte, bool, error) {
+	// The pinned version keeps its tables open even if a compaction replaces them
+	v, err := db.familyVersion(cf)
+	if err != nil {
+		return nil, false, err
+	}
+	defer v.unref()
 
-	if val, found := db.activeMemtable.Get(key); found {
-		if val == nil {
-			return nil, false
-		}
-		return val, true
+	// Versions are collected newest first until one that no merge operand applies on top of
+	lookup := newKeyLookup(key, db.now())
+	v.active.Versions(key, seq, lookup.add)
+	if !lookup.done && v.immutable != nil {
+		v.immutable.Versions(key, seq, lookup.add)
 	}
 
-	if db.immutableMemtable != nil {
-		if val, found := db.immutableMemtable.Get(key); found {
-			if val == nil {
-				return nil, false
-			}
-			return val, true
+	// Level 0 tables may overlap, so probe them newest first
+	var candidates []*sstable.Reader
+	for i := len(v.levels[0]) - 1; i >= 0; i-- {
+		candidates = append(candidates, v.levels[0][i])
+	}
+
+	// Deeper levels hold disjoint key ranges, at most one table per level can match
+	for level := 1; level < len(v.levels); level++ {
+		if r := findTable(db.opts.Comparator, v.levels[level], key); r != nil {
+			candidates = append(candidates, r)
 		}
 	}
 
-	for i := len(db.sstReaders) - 1; i >= 0; i-- {
-		if val, found := db.sstReaders[i].Get(key); found {
-			if len(val) == 0 {
-				return nil, false
-			}
-			return val, true
+	for _, r := range candidates {
+		if lookup.done {
+			break
 		}
+		if err := r.Versions(key, seq, lookup.add); err != nil {
+			// Older tables may hold a stale version, so stop instead of falling through
+			return nil, false, fmt.Errorf("failed to read %s: %w", r.Path(), err)
+		}
+	}
+
+	val, found, err := lookup.result(cf.opts.MergeOperator)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to merge %q: %w", key, err)
 	}
-	return nil, false
+	return val, found, nil
+}
+
+// BlockCacheStats returns the counters of the block cache, which are zero if it is disabled.
+// A cache shared by several DBs reports their combined traffic.
+func (db *StrataGo) BlockCacheStats() sstable.CacheStats {
+	if db.blockCache == nil {
+		return sstable.CacheStats{}
+	}
+	return db.blockCache.Stats()
+}
+
+// CompactionStats returns the counters of every compaction since the DB was opened,
+// such as the number of tombstones they garbage collected
+func (db *StrataGo) CompactionStats() sstable.MergeStats {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.mergeStats
+}
+
+// openTable opens an SSTable reader backed by the DB's block cache
+func (db *StrataGo) openTable(path string) (*sstable.Reader, error) {
+	return sstable.NewReaderWithOptions(path, sstable.ReaderOptions{BlockCache: db.blockCache, Comparator: db.opts.Comparator})
+}
+
+// newMemtable returns an empty memtable ordered by the DB's comparator
+func (db *StrataGo) newMemtable() *memtable.SkipList {
+	return memtable.NewSkipListWithComparator(db.opts.Comparator)
 }
 
 // Delete marks a key as deleted by inserting a tombstone
 func (db *StrataGo) Delete(key []byte) error {
+	return db.DeleteWithOptions(key, nil)
+}
 
-	db.mu.Lock()
-	if db.closed {
-		db.mu.Unlock()
-		return fmt.Errorf("database is closed")
-	}
-	db.mu.Unlock()
-
-	// Writing the deletion to the WAL with value nil
-	if err := db.wal.WriteEntry(key, nil); err != nil {
-		return err
-	}
+// DeleteWithOptions deletes a key with per-write durability options
+func (db *StrataGo) DeleteWithOptions(key []byte, wo *WriteOptions) error {
+	return db.apply([]wal.Entry{{Kind: kind.Delete, Key: key}}, wo)
+}
 
-	db.mu.Lock()
-	db.activeMemtable.Put(key, nil)
+// Merge records operand to be combined with the current value of key by the
+// configured MergeOperator, without reading that value first
+func (db *StrataGo) Merge(key, operand []byte) error {
+	return db.MergeWithOptions(key, operand, nil)
+}
 
-	needsFlush := db.activeMemtable.SizeBytes >= DefaultMemtableThreshold
-	if needsFlush {
-		select {
-		case db.flushChan <- struct{}{}:
-		default:
-		}
+// MergeWithOptions merges an operand into a key with per-write durability options
+func (db *StrataGo) MergeWithOptions(key, operand []byte, wo *WriteOptions) error {
+	if db.opts.MergeOperator == nil {
+		return errNoMergeOperator
 	}
-	db.mu.Unlock()
+	return db.apply([]wal.Entry{{Kind: kind.Merge, Key: key, Value: operand}}, wo)
+}
 
-	return nil
+// SyncWAL forces every write logged so far to stable storage. It is an explicit
+// durability point for writes made with SyncPeriodic, SyncNever or unsynced WriteOptions.
+func (db *StrataGo) SyncWAL() error {
+	db.mu.RLock()
+	w := db.wal
+	db.mu.RUnlock()
+	return w.Sync()
 }
 
 func (db *StrataGo) Close() error {
@@ -242,8 +357,15 @@ func (db *StrataGo) Close() error {
 	defer db.mu.Unlock()
 
 	db.wal.Close()
-	for _, r := range db.sstReaders {
-		r.Close()
+	db.manifest.Close()
+
+	// Tables close now, or once the last iterator pinning them is closed
+	for _, cf := range db.families {
+		cf.installVersion(&version{
+			active: db.newMemtable(),
+			levels: make([][]*sstable.Reader, len(cf.current.levels)),
+			tables: cf.current.tables,
+		})
 	}
 	return nil
 }
@@ -264,34 +386,82 @@ func (db *StrataGo) Purge() error {
 		return err
 	}
 
-	// Re-initialize Memory and WAL
-	db.activeMemtable = memtable.NewSkipList()
-	db.immutableMemtable = nil
-	db.sstReaders = nil // Reset readers slice
+	// Re-initialize Memory and WAL, leaving only the default family
+	for _, cf := range db.families {
+		if cf != db.defaultCF {
+			cf.dropped = true
+		}
+	}
+	db.defaultCF.installVersion(&version{
+		active: db.newMemtable(),
+		levels: make([][]*sstable.Reader, db.opts.MaxLevels), // Reset readers
+		tables: newTableRefs(),
+	})
+	db.families = map[uint32]*ColumnFamily{0: db.defaultCF}
+	db.snapshots = make(map[uint64]int)
+	db.lastSeq.Store(0)
+	db.recovery = wal.RecoveryReport{}
+	db.mergeStats = sstable.MergeStats{}
 
-	walPath := filepath.Join(db.dataDir, "wal.log")
-	newWal, err := wal.NewWAL(walPath)
+	m, err := openManifest(db.dataDir, db.opts.Comparator)
+	if err != nil {
+		return err
+	}
+	db.manifest = m
+
+	walNumber := m.NewFileNumber()
+	newWal, err := wal.NewWAL(filepath.Join(db.dataDir, logFileName(walNumber)))
 	if err != nil {
 		return err
 	}
 	db.wal = newWal
+	db.defaultCF.logNumber, db.defaultCF.keepLog = walNumber, walNumber
 
 	// Restarting worker
 	db.flushChan = make(chan struct{}, 1)
 	db.closeChan = make(chan struct{})
 	db.closed = false
-	db.wg.Add(2)
+	db.startWorkers()
+
+	return nil
+}
+
+// startWorkers launches the background flush and compaction workers, and the WAL syncer in SyncPeriodic mode
+func (db *StrataGo) startWorkers() {
+	db.wg.Add(2) // two worker - flush + compaction
 	go db.flushWorker()
 	go db.compactionWorker()
 
-	return nil
+	if db.opts.SyncMode == SyncPeriodic {
+		db.wg.Add(1)
+		go db.syncWorker()
+	}
+}
+
+// syncWorker fsyncs the WAL every SyncInterval
+func (db *StrataGo) syncWorker() {
+	defer db.wg.Done()
+
+	ticker := time.NewTicker(db.opts.SyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := db.SyncWAL(); err != nil {
+				fmt.Printf("Warning: WAL sync failed: %v\n", err)
+			}
+		case <-db.closeChan:
+			return
+		}
+	}
 }
 
 func (db *StrataGo) flushWorker() {
 	defer db.wg.Done()
 
 	for range db.flushChan {
-		if err := db.Flush(); err != nil {
+		if err := db.flushFull(); err != nil {
 			fmt.Printf("Background flush failed: %v\n", err)
 		}
 	}
@@ -1,12 +1,18 @@
 package stratago
 
 import (
+	"encoding/binary"
 	"fmt"
+	"math"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/thomazdavis/stratago/comparator"
+	"github.com/thomazdavis/stratago/sstable"
+	"github.com/thomazdavis/stratago/sstable/sstabletest"
 )
 
 func TestStrataGo_Integration(t *testing.T) {
@@ -97,7 +103,7 @@ func TestStrataGo_AutoFlush(t *testing.T) {
 	assert.Eventually(t, func() bool {
 		db.mu.RLock()
 		defer db.mu.RUnlock()
-		return len(db.sstReaders) > 0
+		return len(db.defaultCF.current.levels[0]) > 0
 	}, 10*time.Second, 100*time.Millisecond)
 
 	val, found := db.Get(targetKey)
@@ -133,3 +139,233 @@ func TestStrataGo_Delete(t *testing.T) {
 	_, found = db2.Get(key)
 	assert.False(t, found, "Key should stay deleted after restart")
 }
+
+func TestOpen_ReadsOriginalTables(t *testing.T) {
+	dataDir := "test_v0_tables"
+	defer os.RemoveAll(dataDir)
+	os.MkdirAll(dataDir, 0755)
+
+	assert.NoError(t, sstabletest.WriteV0Table(filepath.Join(dataDir, "data_100.sst"), sstable.IndexInterval, "a", "old", "b", "kept", "c", "gone"))
+	assert.NoError(t, sstabletest.WriteV0Table(filepath.Join(dataDir, "data_200.sst"), sstable.IndexInterval, "a", "new", "c", ""))
+
+	db, err := Open(dataDir)
+	assert.NoError(t, err)
+
+	// Without sequence numbers, the newer table wins
+	val, found := db.Get([]byte("a"))
+	assert.True(t, found)
+	assert.Equal(t, []byte("new"), val)
+	val, found = db.Get([]byte("b"))
+	assert.True(t, found)
+	assert.Equal(t, []byte("kept"), val)
+	_, found = db.Get([]byte("c"))
+	assert.False(t, found, "An empty value was a tombstone")
+
+	// New writes shadow the old tables, and compaction keeps the newest table's versions
+	assert.NoError(t, db.Put([]byte("b"), []byte("updated")))
+	assert.NoError(t, db.CompactRange(nil, nil))
+	val, _ = db.Get([]byte("a"))
+	assert.Equal(t, []byte("new"), val)
+	val, _ = db.Get([]byte("b"))
+	assert.Equal(t, []byte("updated"), val)
+	_, found = db.Get([]byte("c"))
+	assert.False(t, found)
+	assert.NoError(t, db.Close())
+
+	db, err = Open(dataDir)
+	assert.NoError(t, err)
+	defer db.Close()
+	val, _ = db.Get([]byte("a"))
+	assert.Equal(t, []byte("new"), val)
+	val, _ = db.Get([]byte("b"))
+	assert.Equal(t, []byte("updated"), val)
+}
+
+func TestStrataGo_EmptyValueIsNotDelete(t *testing.T) {
+	dataDir := "test_empty_value"
+	defer os.RemoveAll(dataDir)
+
+	db, _ := Open(dataDir)
+	db.Put([]byte("empty"), []byte{})
+	db.Put([]byte("nil"), nil)
+	db.Put([]byte("gone"), []byte("v"))
+	db.Delete([]byte("gone"))
+
+	check := func(db *StrataGo, stage string) {
+		val, found := db.Get([]byte("empty"))
+		assert.True(t, found, "Empty value should be found %s", stage)
+		assert.Empty(t, val)
+
+		_, found = db.Get([]byte("nil"))
+		assert.True(t, found, "Nil value should be found %s", stage)
+
+		_, found = db.Get([]byte("gone"))
+		assert.False(t, found, "Deleted key should stay deleted %s", stage)
+	}
+
+	check(db, "in memtable")
+
+	// Crash before a flush, recovery goes through the WAL
+	db.wal.Close()
+	db2, _ := Open(dataDir)
+	check(db2, "after WAL recovery")
+
+	db2.Flush()
+	check(db2, "after flush")
+
+	db2.Close()
+	db3, _ := Open(dataDir)
+	defer db3.Close()
+	check(db3, "after restart")
+
+	it, err := db3.NewIterator(nil, nil)
+	assert.NoError(t, err)
+	defer it.Close()
+	assert.Equal(t, []string{"empty", "nil"}, collectKeys(t, it))
+}
+
+func TestStrataGo_GetReportsCorruption(t *testing.T) {
+	dataDir := "test_get_corruption"
+	defer os.RemoveAll(dataDir)
+
+	// Without a block cache every lookup reads the file
+	db, err := OpenWithOptions(dataDir, &Options{BlockCacheSize: -1})
+	assert.NoError(t, err)
+	defer db.Close()
+
+	db.Put([]byte("k"), []byte("old"))
+	assert.NoError(t, db.Flush())
+	db.Put([]byte("k"), []byte("new"))
+	assert.NoError(t, db.Flush())
+
+	v := db.defaultCF.currentVersion()
+	path := v.levels[0][1].Path()
+	v.unref()
+	data, _ := os.ReadFile(path)
+	data[0] ^= 0xFF
+	assert.NoError(t, os.WriteFile(path, data, 0644))
+
+	// The older table must not answer for the damaged newer one
+	_, found, err := db.GetWithOptions([]byte("k"), nil)
+	assert.ErrorIs(t, err, sstable.ErrCorrupt)
+	assert.False(t, found)
+	_, found = db.Get([]byte("k"))
+	assert.False(t, found)
+
+	_, _, err = db.GetCFWithOptions(nil, []byte("k"), nil)
+	assert.Error(t, err)
+}
+
+func TestStrataGo_SharedBlockCache(t *testing.T) {
+	cache := sstable.NewBlockCache(1024 * 1024)
+	defer os.RemoveAll("test_cache_a")
+	defer os.RemoveAll("test_cache_b")
+
+	dbA, err := OpenWithOptions("test_cache_a", &Options{BlockCache: cache})
+	assert.NoError(t, err)
+	defer dbA.Close()
+	dbB, err := OpenWithOptions("test_cache_b", &Options{BlockCache: cache})
+	assert.NoError(t, err)
+	defer dbB.Close()
+
+	dbA.Put([]byte("k"), []byte("a"))
+	dbB.Put([]byte("k"), []byte("b"))
+	assert.NoError(t, dbA.Flush())
+	assert.NoError(t, dbB.Flush())
+	before := cache.Stats()
+
+	for range 5 {
+		val, _ := dbA.Get([]byte("k"))
+		assert.Equal(t, []byte("a"), val)
+		val, _ = dbB.Get([]byte("k"))
+		assert.Equal(t, []byte("b"), val)
+	}
+
+	stats := dbA.BlockCacheStats()
+	assert.Equal(t, stats, dbB.BlockCacheStats())
+	// Flush verification already read each table once, so every lookup is a hit
+	assert.Equal(t, uint64(10), stats.Hits-before.Hits)
+	assert.Equal(t, before.Misses, stats.Misses)
+
+	disabled, err := OpenWithOptions("test_cache_disabled", &Options{BlockCacheSize: -1})
+	assert.NoError(t, err)
+	defer os.RemoveAll("test_cache_disabled")
+	defer disabled.Close()
+	assert.Equal(t, sstable.CacheStats{}, disabled.BlockCacheStats())
+}
+
+func TestStrataGo_Comparator(t *testing.T) {
+	dataDir := "test_comparator"
+	defer os.RemoveAll(dataDir)
+
+	// Small output tables so level 1 holds several, which must be kept in numeric order
+	opts := &Options{Comparator: comparator.Numeric, CompactionStrategy: Leveled, TargetFileSize: 256}
+	db, err := OpenWithOptions(dataDir, opts)
+	assert.NoError(t, err)
+
+	for round := range 3 {
+		for i := round; i < 120; i += 3 {
+			assert.NoError(t, db.Put(fmt.Appendf(nil, "%d", i), fmt.Appendf(nil, "v%d", i)))
+		}
+		assert.NoError(t, db.Flush())
+	}
+	assert.NoError(t, db.CompactRange(nil, nil))
+	assert.Greater(t, len(db.defaultCF.currentVersion().levels[1]), 1)
+	assert.NoError(t, db.Put([]byte("7"), []byte("new")))
+
+	iter, err := db.NewIterator([]byte("9"), []byte("12"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"9", "10", "11"}, collectKeys(t, iter))
+	assert.NoError(t, iter.Close())
+	assert.NoError(t, db.Close())
+
+	// Reopening with the same comparator finds every key
+	db, err = OpenWithOptions(dataDir, opts)
+	assert.NoError(t, err)
+	assertValue(t, db, "7", "new")
+	for i := range 120 {
+		if i != 7 {
+			assertValue(t, db, fmt.Sprint(i), fmt.Sprint("v", i))
+		}
+	}
+	assert.NoError(t, db.Close())
+
+	_, err = Open(dataDir)
+	assert.ErrorIs(t, err, comparator.ErrMismatch)
+}
+
+func TestStrataGo_ComparatorRecordedInManifest(t *testing.T) {
+	dataDir := "test_comparator_manifest"
+	defer os.RemoveAll(dataDir)
+
+	// Without a single table, the manifest alone catches the mismatch
+	db, err := OpenWithOptions(dataDir, &Options{Comparator: comparator.ReverseTimestamp})
+	assert.NoError(t, err)
+	assert.NoError(t, db.Close())
+
+	_, err = OpenWithOptions(dataDir, &Options{Comparator: comparator.Numeric})
+	assert.ErrorIs(t, err, comparator.ErrMismatch)
+
+	db, err = OpenWithOptions(dataDir, &Options{Comparator: comparator.ReverseTimestamp})
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// Each series reads newest first
+	key := func(series string, ts uint64) []byte {
+		return binary.BigEndian.AppendUint64([]byte(series), ts)
+	}
+	for ts := uint64(1); ts <= 3; ts++ {
+		assert.NoError(t, db.Put(key("cpu", ts), fmt.Appendf(nil, "cpu%d", ts)))
+		assert.NoError(t, db.Put(key("mem", ts), fmt.Appendf(nil, "mem%d", ts)))
+	}
+	assert.NoError(t, db.Flush())
+
+	iter, err := db.NewIterator(key("mem", math.MaxUint64), nil)
+	assert.NoError(t, err)
+	defer iter.Close()
+	var values []string
+	for iter.First(); iter.Valid(); iter.Next() {
+		values = append(values, string(iter.Value()))
+	}
+	assert.Equal(t, []string{"mem3", "mem2", "mem1"}, values)
+}
@@ -0,0 +1,310 @@
+package stratago
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/thomazdavis/stratago/comparator"
+	"github.com/thomazdavis/stratago/manifest"
+	"github.com/thomazdavis/stratago/memtable"
+	"github.com/thomazdavis/stratago/sstable"
+)
+
+// tableFileName returns the name of the table with the given file number
+func tableFileName(number uint64) string {
+	return fmt.Sprintf("%06d.sst", number)
+}
+
+// parseTableFileName returns the file number of a table name, or false if it is not one
+func parseTableFileName(name string) (uint64, bool) {
+	var number uint64
+	if !strings.HasSuffix(name, ".sst") || strings.Contains(name, "_") {
+		return 0, false
+	}
+	if n, _ := fmt.Sscanf(name, "%d.sst", &number); n != 1 {
+		return 0, false
+	}
+	return number, true
+}
+
+// tableNumber returns the file number of an open table
+func tableNumber(r *sstable.Reader) uint64 {
+	number, _ := parseTableFileName(filepath.Base(r.Path()))
+	return number
+}
+
+// newTable reserves a file number for a new table and returns it with the table path
+func (db *StrataGo) newTable() (uint64, string) {
+	number := db.manifest.NewFileNumber()
+	return number, filepath.Join(db.dataDir, tableFileName(number))
+}
+
+// tableMeta describes an open table of a column family for a version edit
+func tableMeta(family uint32, level int, r *sstable.Reader) manifest.FileMeta {
+	return manifest.FileMeta{
+		Family:   family,
+		Number:   tableNumber(r),
+		Level:    level,
+		Size:     r.Size(),
+		Smallest: r.Smallest(),
+		Largest:  r.Largest(),
+	}
+}
+
+// deletedTables lists tables of one level for removal in a version edit
+func deletedTables(level int, readers []*sstable.Reader) []manifest.DeletedFile {
+	res := make([]manifest.DeletedFile, len(readers))
+	for i, r := range readers {
+		res[i] = manifest.DeletedFile{Level: level, Number: tableNumber(r)}
+	}
+	return res
+}
+
+// openManifest loads the manifest of dataDir, creating one that records cmp if there is none.
+// Tables written before the manifest existed are adopted under new file numbers.
+// A DB created with another comparator fails with comparator.ErrMismatch.
+func openManifest(dataDir string, cmp comparator.Comparator) (*manifest.Manifest, error) {
+	if manifest.Exists(dataDir) {
+		m, err := manifest.Load(dataDir)
+		if err != nil {
+			return nil, err
+		}
+		// Manifests that predate the comparator record were always bytewise
+		name := m.Comparator()
+		if name == "" {
+			name = comparator.Bytewise.Name()
+		}
+		if name != cmp.Name() {
+			m.Close()
+			return nil, fmt.Errorf("%w: database was created with %q, opened with %q", comparator.ErrMismatch, name, cmp.Name())
+		}
+		return m, nil
+	}
+
+	initial, legacy, err := adoptLegacyTables(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	initial.Comparator = cmp.Name()
+	if len(initial.NewFiles) > 0 {
+		// Legacy tables are bytewise, opening them will fail with any other order
+		initial.Comparator = comparator.Bytewise.Name()
+	}
+	m, err := manifest.Create(dataDir, initial)
+	if err != nil {
+		return nil, err
+	}
+
+	// The tables are now live under their new names
+	for _, path := range legacy {
+		os.Remove(path)
+	}
+	return m, nil
+}
+
+// adoptLegacyTables hard links every data_<ts>[_L<n>].sst table to a numbered name,
+// oldest first, and returns the edit that makes them live along with the old paths.
+// The old names stay in place until the manifest is written, so a crash midway
+// simply restarts the adoption.
+func adoptLegacyTables(dataDir string) (*manifest.VersionEdit, []string, error) {
+	files, err := os.ReadDir(dataDir)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read data directory: %w", err)
+	}
+
+	type legacyTable struct {
+		path      string
+		timestamp int64
+		level     int
+	}
+	var tables []legacyTable
+	for _, f := range files {
+		var ts int64
+		var level int
+		if n, _ := fmt.Sscanf(f.Name(), "data_%d_L%d.sst", &ts, &level); n == 2 && level >= 0 {
+			tables = append(tables, legacyTable{filepath.Join(dataDir, f.Name()), ts, level})
+		} else if n, _ := fmt.Sscanf(f.Name(), "data_%d.sst", &ts); n == 1 && strings.HasSuffix(f.Name(), ".sst") {
+			tables = append(tables, legacyTable{filepath.Join(dataDir, f.Name()), ts, 0})
+		}
+	}
+	sort.Slice(tables, func(i, j int) bool {
+		return tables[i].timestamp < tables[j].timestamp
+	})
+
+	edit := &manifest.VersionEdit{}
+	var adopted []string
+	number := uint64(1)
+	for _, t := range tables {
+		// Leaving a table out would lose its data, so an unreadable one stops the adoption
+		r, err := sstable.NewReader(t.path)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to adopt %s: %w", t.path, err)
+		}
+		maxSeq := r.MaxSequence()
+		meta := tableMeta(0, t.level, r)
+		r.Close()
+
+		newPath := filepath.Join(dataDir, tableFileName(number))
+		os.Remove(newPath) // Left over from an interrupted adoption
+		if err := os.Link(t.path, newPath); err != nil {
+			return nil, nil, fmt.Errorf("failed to adopt %s: %w", t.path, err)
+		}
+
+		meta.Number = number
+		edit.NewFiles = append(edit.NewFiles, meta)
+		edit.LastSequence = max(edit.LastSequence, maxSeq)
+		adopted = append(adopted, t.path)
+		number++
+	}
+	edit.NextFileNumber = number
+	return edit, adopted, nil
+}
+
+// removeObsoleteFiles deletes tables and manifests the manifest does not refer to and
+// flushed WAL segments, along with temporary files left behind by interrupted writes
+func removeObsoleteFiles(dataDir string, m *manifest.Manifest) {
+	live := make(map[uint64]bool)
+	for _, f := range m.Files() {
+		live[f.Number] = true
+	}
+	current := manifest.FileName(m.FileNumber())
+	logNumber := m.MinLogNumber()
+
+	files, err := os.ReadDir(dataDir)
+	if err != nil {
+		return
+	}
+	for _, f := range files {
+		name := f.Name()
+		obsolete := strings.Contains(name, ".tmp.") ||
+			(strings.HasPrefix(name, "MANIFEST-") && name != current)
+		if number, ok := parseTableFileName(name); ok && !live[number] {
+			obsolete = true
+		}
+		if number, ok := parseLogFileName(name); ok && number < logNumber {
+			obsolete = true
+		}
+		if obsolete {
+			os.Remove(filepath.Join(dataDir, name))
+		}
+	}
+}
+
+// version is an immutable view of the memtables and tables that make up the DB.
+// Flushes and compactions install a new version instead of changing the current
+// one, and every reader pins the version it started on, so tables stay open for
+// as long as any version that lists them is referenced.
+type version struct {
+	active    *memtable.SkipList // Still receives writes, but is never swapped out of this version
+	immutable *memtable.SkipList
+	levels    [][]*sstable.Reader
+	refs      atomic.Int32
+	tables    *tableRefs
+}
+
+// clone returns a copy of v that can be changed without affecting v
+func (v *version) clone() *version {
+	levels := make([][]*sstable.Reader, len(v.levels))
+	for i, level := range v.levels {
+		levels[i] = append([]*sstable.Reader{}, level...)
+	}
+	return &version{active: v.active, immutable: v.immutable, levels: levels, tables: v.tables}
+}
+
+// readers returns every table of the version
+func (v *version) readers() []*sstable.Reader {
+	var res []*sstable.Reader
+	for _, level := range v.levels {
+		res = append(res, level...)
+	}
+	return res
+}
+
+func (v *version) ref() {
+	v.refs.Add(1)
+}
+
+// unref releases a reference, releasing the tables once nothing uses the version
+func (v *version) unref() {
+	if v.refs.Add(-1) == 0 {
+		v.tables.unref(v.readers())
+	}
+}
+
+// tableRefs counts the live versions that list each table. A table is closed
+// when its count drops to zero, and its file removed if compaction replaced it.
+type tableRefs struct {
+	mu       sync.Mutex
+	refs     map[*sstable.Reader]int
+	obsolete map[*sstable.Reader]bool
+}
+
+func newTableRefs() *tableRefs {
+	return &tableRefs{
+		refs:     make(map[*sstable.Reader]int),
+		obsolete: make(map[*sstable.Reader]bool),
+	}
+}
+
+func (t *tableRefs) ref(readers []*sstable.Reader) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, r := range readers {
+		t.refs[r]++
+	}
+}
+
+func (t *tableRefs) unref(readers []*sstable.Reader) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, r := range readers {
+		if _, ok := t.refs[r]; !ok {
+			continue
+		}
+		t.refs[r]--
+		if t.refs[r] > 0 {
+			continue
+		}
+
+		delete(t.refs, r)
+		path := r.Path()
+		r.Close()
+		if t.obsolete[r] {
+			delete(t.obsolete, r)
+			os.Remove(path)
+		}
+	}
+}
+
+// markObsolete flags tables whose files are deleted once no version uses them
+func (t *tableRefs) markObsolete(readers []*sstable.Reader) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, r := range readers {
+		t.obsolete[r] = true
+	}
+}
+
+// installVersion makes next the current version of the family. Callers must hold db.mu.
+func (cf *ColumnFamily) installVersion(next *version) {
+	next.tables.ref(next.readers())
+	next.refs.Store(1) // Held by the family while current
+	prev := cf.current
+	cf.current = next
+	if prev != nil {
+		prev.unref()
+	}
+}
+
+// currentVersion returns the current version of the family with a reference the caller must release
+func (cf *ColumnFamily) currentVersion() *version {
+	cf.db.mu.RLock()
+	defer cf.db.mu.RUnlock()
+	cf.current.ref()
+	return cf.current
+}
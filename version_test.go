@@ -0,0 +1,265 @@
+package stratago
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/thomazdavis/stratago/kind"
+	"github.com/thomazdavis/stratago/manifest"
+	"github.com/thomazdavis/stratago/sstable"
+	"github.com/thomazdavis/stratago/sstable/sstabletest"
+)
+
+func TestParseTableFileName(t *testing.T) {
+	number, ok := parseTableFileName(tableFileName(42))
+	assert.True(t, ok)
+	assert.Equal(t, uint64(42), number)
+
+	for _, name := range []string{"data_42.sst", "000042.sst.tmp.1", "MANIFEST-000001", "wal.log"} {
+		_, ok := parseTableFileName(name)
+		assert.False(t, ok, name)
+	}
+}
+
+func TestOpen_AdoptsLegacyTables(t *testing.T) {
+	dataDir := "test_legacy_tables"
+	defer os.RemoveAll(dataDir)
+	os.MkdirAll(dataDir, 0755)
+
+	write := func(name string, seq uint64, key, value string) {
+		builder, err := sstable.NewBuilder(filepath.Join(dataDir, name))
+		assert.NoError(t, err)
+		builder.Add([]byte(key), seq, kind.Value, []byte(value))
+		assert.NoError(t, builder.Finish())
+	}
+	write("data_100.sst", 1, "a", "old")
+	write("data_200.sst", 2, "a", "new")
+	write("data_150_L1.sst", 3, "b", "deep")
+
+	db, err := Open(dataDir)
+	assert.NoError(t, err)
+
+	val, _ := db.Get([]byte("a"))
+	assert.Equal(t, []byte("new"), val)
+	val, _ = db.Get([]byte("b"))
+	assert.Equal(t, []byte("deep"), val)
+
+	db.mu.RLock()
+	assert.Len(t, db.defaultCF.current.levels[0], 2)
+	assert.Len(t, db.defaultCF.current.levels[1], 1)
+	db.mu.RUnlock()
+	assert.NoError(t, db.Close())
+
+	// The legacy names are gone and the manifest alone describes the tables
+	legacy, _ := filepath.Glob(filepath.Join(dataDir, "data_*"))
+	assert.Empty(t, legacy)
+	assert.True(t, manifest.Exists(dataDir))
+
+	db, err = Open(dataDir)
+	assert.NoError(t, err)
+	defer db.Close()
+	val, _ = db.Get([]byte("a"))
+	assert.Equal(t, []byte("new"), val)
+	assert.Equal(t, uint64(3), db.lastSeq.Load())
+}
+
+func TestOpen_FailsOnUnreadableLegacyTable(t *testing.T) {
+	dataDir := "test_legacy_unreadable"
+	defer os.RemoveAll(dataDir)
+	os.MkdirAll(dataDir, 0755)
+
+	assert.NoError(t, sstabletest.WriteV0Table(filepath.Join(dataDir, "data_100.sst"), sstable.IndexInterval, "a", "1"))
+	bad := filepath.Join(dataDir, "data_200.sst")
+	assert.NoError(t, os.WriteFile(bad, []byte("not a table at all"), 0644))
+
+	_, err := Open(dataDir)
+	assert.Error(t, err)
+	assert.False(t, manifest.Exists(dataDir), "No manifest is written without the table")
+	_, err = os.Stat(bad)
+	assert.NoError(t, err)
+
+	// Once the table is dealt with, the adoption starts over
+	assert.NoError(t, os.Remove(bad))
+	db, err := Open(dataDir)
+	assert.NoError(t, err)
+	defer db.Close()
+	val, found := db.Get([]byte("a"))
+	assert.True(t, found)
+	assert.Equal(t, []byte("1"), val)
+}
+
+func TestOpen_RemovesObsoleteFiles(t *testing.T) {
+	dataDir := "test_obsolete_files"
+	defer os.RemoveAll(dataDir)
+
+	db, err := Open(dataDir)
+	assert.NoError(t, err)
+	db.Put([]byte("k"), []byte("v"))
+	assert.NoError(t, db.Close())
+
+	// Leftovers of a flush and a compaction that crashed before their manifest edit
+	orphans := []string{tableFileName(999), "000998.sst.tmp.12345"}
+	for _, name := range orphans {
+		assert.NoError(t, os.WriteFile(filepath.Join(dataDir, name), []byte("junk"), 0644))
+	}
+
+	db, err = Open(dataDir)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	for _, name := range orphans {
+		_, err := os.Stat(filepath.Join(dataDir, name))
+		assert.True(t, os.IsNotExist(err), "%s should be removed", name)
+	}
+	val, found := db.Get([]byte("k"))
+	assert.True(t, found)
+	assert.Equal(t, []byte("v"), val)
+}
+
+func TestOpen_FailsOnCorruptManifest(t *testing.T) {
+	dataDir := "test_corrupt_manifest"
+	defer os.RemoveAll(dataDir)
+
+	db, err := Open(dataDir)
+	assert.NoError(t, err)
+	path := filepath.Join(dataDir, manifest.FileName(db.manifest.FileNumber()))
+	stat, _ := os.Stat(path)
+	for _, key := range []string{"a", "b"} {
+		db.Put([]byte(key), []byte("v"))
+		assert.NoError(t, db.Flush())
+	}
+	tables, _ := filepath.Glob(filepath.Join(dataDir, "*.sst"))
+	assert.Len(t, tables, 2)
+	db.wal.Close()
+
+	// Damage the edit adding the first table, the second one is intact
+	data, _ := os.ReadFile(path)
+	data[stat.Size()+10] ^= 0xFF
+	assert.NoError(t, os.WriteFile(path, data, 0644))
+
+	_, err = Open(dataDir)
+	assert.ErrorIs(t, err, manifest.ErrCorrupt)
+	for _, table := range tables {
+		_, err := os.Stat(table)
+		assert.NoError(t, err, "Tables of the edits after the damage are kept")
+	}
+}
+
+func TestRunCompaction_SurvivesReopen(t *testing.T) {
+	dataDir := "test_compaction_reopen"
+	defer os.RemoveAll(dataDir)
+
+	opts := &Options{CompactionInterval: time.Hour}
+	db, err := OpenWithOptions(dataDir, opts)
+	assert.NoError(t, err)
+
+	for i := range CompactionThreshold {
+		db.Put(fmt.Appendf(nil, "key%d", i), fmt.Appendf(nil, "val%d", i))
+		db.Put([]byte("shared"), fmt.Appendf(nil, "v%d", i))
+		assert.NoError(t, db.Flush())
+	}
+	assert.NoError(t, db.RunCompaction())
+
+	// A table flushed after the compaction must still be newer than its output
+	db.Put([]byte("shared"), []byte("latest"))
+	assert.NoError(t, db.Flush())
+	assert.NoError(t, db.Close())
+
+	db, err = OpenWithOptions(dataDir, opts)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	db.mu.RLock()
+	assert.Len(t, db.defaultCF.current.levels[0], 2)
+	db.mu.RUnlock()
+
+	for i := range CompactionThreshold {
+		val, found := db.Get(fmt.Appendf(nil, "key%d", i))
+		assert.True(t, found)
+		assert.Equal(t, fmt.Appendf(nil, "val%d", i), val)
+	}
+	val, _ := db.Get([]byte("shared"))
+	assert.Equal(t, []byte("latest"), val)
+
+	files, _ := filepath.Glob(filepath.Join(dataDir, "*.sst"))
+	assert.Len(t, files, 2, "Compaction inputs should be deleted")
+}
+
+func TestVersion_IteratorPinsCompactedTables(t *testing.T) {
+	dataDir := "test_version_pinning"
+	defer os.RemoveAll(dataDir)
+
+	db, err := OpenWithOptions(dataDir, &Options{CompactionInterval: time.Hour})
+	assert.NoError(t, err)
+	defer db.Close()
+
+	for i := range CompactionThreshold {
+		db.Put(fmt.Appendf(nil, "key%d", i), fmt.Appendf(nil, "val%d", i))
+		assert.NoError(t, db.Flush())
+	}
+
+	it, err := db.NewIterator(nil, nil)
+	assert.NoError(t, err)
+	assert.True(t, it.First())
+
+	assert.NoError(t, db.RunCompaction())
+	db.mu.RLock()
+	assert.Len(t, db.defaultCF.current.levels[0], 1)
+	db.mu.RUnlock()
+
+	// The iterator still reads the tables of the version it started on
+	files, _ := filepath.Glob(filepath.Join(dataDir, "*.sst"))
+	assert.Len(t, files, CompactionThreshold+1, "Pinned inputs must not be deleted")
+
+	count := 1
+	for it.Next() {
+		count++
+	}
+	assert.NoError(t, it.Error())
+	assert.Equal(t, CompactionThreshold, count)
+
+	assert.NoError(t, it.Close())
+	files, _ = filepath.Glob(filepath.Join(dataDir, "*.sst"))
+	assert.Len(t, files, 1, "Inputs are deleted once the last iterator is closed")
+
+	val, found := db.Get([]byte("key0"))
+	assert.True(t, found)
+	assert.Equal(t, []byte("val0"), val)
+}
+
+func TestVersion_ConcurrentReadsDuringCompaction(t *testing.T) {
+	dataDir := "test_version_concurrent"
+	defer os.RemoveAll(dataDir)
+
+	db, err := OpenWithOptions(dataDir, &Options{CompactionInterval: time.Hour})
+	assert.NoError(t, err)
+	defer db.Close()
+
+	for round := range 3 {
+		for i := range CompactionThreshold {
+			db.Put(fmt.Appendf(nil, "key%d-%d", round, i), []byte("value"))
+			assert.NoError(t, db.Flush())
+		}
+
+		done := make(chan struct{})
+		errs := make(chan error, 1)
+		go func() {
+			defer close(done)
+			for i := range 200 {
+				key := fmt.Appendf(nil, "key%d-%d", round, i%CompactionThreshold)
+				if _, found := db.Get(key); !found {
+					errs <- fmt.Errorf("lost %s", key)
+					return
+				}
+			}
+		}()
+		assert.NoError(t, db.RunCompaction())
+		<-done
+		close(errs)
+		assert.NoError(t, <-errs)
+	}
+}
@@ -0,0 +1,278 @@
+package wal
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+
+	"github.com/thomazdavis/stratago/kind"
+)
+
+// walMagic opens every versioned log file. Files without it predate versioning.
+const walMagic = 0x4C574753 // "SGWL"
+
+// On-disk format versions
+const (
+	// formatLegacy has no file header. Records are
+	// [SeqNum (8B)] [Key Size (4B)] [Val Size (4B)] [Checksum (4B)] [Key Bytes] [Value Bytes]
+	// and a deletion is an empty value.
+	formatLegacy uint32 = 1
+
+	// formatKinds starts with [Magic (4B)] [Version (4B)]. Records are
+	// [SeqNum (8B)] [Type (1B)] [Key Size (4B)] [Val Size (4B)] [Checksum (4B)] [Key Bytes] [Value Bytes]
+	// where Type is the entry kind, recordBatch or recordFamilyBatch.
+	formatKinds uint32 = 2
+
+	currentFormat = formatKinds
+)
+
+const (
+	fileHeaderSize   = 8
+	legacyHeaderSize = 20
+	recordHeaderSize = 21
+)
+
+// batchMarker in the key size field marks a legacy batch record. The value size
+// field then holds the length of the encoded batch payload.
+const batchMarker = math.MaxUint32
+
+// recordBatch is the record type of a batch, whose value is the encoded batch payload
+const recordBatch byte = 0xFF
+
+// recordFamilyBatch is the record type of a batch with entries outside the default
+// column family. Each entry of its payload starts with [Family (4B)].
+const recordFamilyBatch byte = 0xFE
+
+// ErrCorrupt reports a record that is torn or fails its checksum
+var ErrCorrupt = errors.New("corrupt WAL record")
+
+// encodeFileHeader returns the header written at the start of every new log
+func encodeFileHeader() []byte {
+	buf := make([]byte, fileHeaderSize)
+	binary.LittleEndian.PutUint32(buf[0:4], walMagic)
+	binary.LittleEndian.PutUint32(buf[4:8], currentFormat)
+	return buf
+}
+
+// encodeEntries returns the record of entries logged under sequence numbers seq, seq+1...
+// A single entry of the default column family gets a record of its own kind.
+func encodeEntries(seq uint64, entries []Entry) ([]byte, error) {
+	families := false
+	for _, e := range entries {
+		families = families || e.Family != 0
+	}
+	if len(entries) == 1 && !families {
+		return encodeRecord(seq, byte(entries[0].Kind), entries[0].Key, entries[0].Value), nil
+	}
+
+	payload, err := encodeBatch(entries, families)
+	if err != nil {
+		return nil, err
+	}
+	if families {
+		return encodeRecord(seq, recordFamilyBatch, nil, payload), nil
+	}
+	return encodeRecord(seq, recordBatch, nil, payload), nil
+}
+
+// encodeRecord returns a single-entry record in the current format.
+// The checksum covers the type, key and value.
+func encodeRecord(seq uint64, recordType byte, key, value []byte) []byte {
+	buf := make([]byte, recordHeaderSize, recordHeaderSize+len(key)+len(value))
+	binary.LittleEndian.PutUint64(buf[0:8], seq)
+	buf[8] = recordType
+	binary.LittleEndian.PutUint32(buf[9:13], uint32(len(key)))
+	binary.LittleEndian.PutUint32(buf[13:17], uint32(len(value)))
+
+	h := crc32.NewIEEE()
+	h.Write([]byte{recordType})
+	h.Write(key)
+	h.Write(value)
+	binary.LittleEndian.PutUint32(buf[17:21], h.Sum32())
+
+	buf = append(buf, key...)
+	return append(buf, value...)
+}
+
+// encodeBatch returns the payload of a batch record, with the column family of each entry if families is set.
+// Payload: [Count (4B)] then per entry [Family (4B)]? [Kind (1B)] [Key Size (4B)] [Val Size (4B)] [Key Bytes] [Value Bytes]
+func encodeBatch(entries []Entry, families bool) ([]byte, error) {
+	size := 4
+	for _, e := range entries {
+		size += 9 + len(e.Key) + len(e.Value)
+		if families {
+			size += 4
+		}
+	}
+	if size > math.MaxUint32-1 {
+		return nil, fmt.Errorf("batch too large: %d bytes", size)
+	}
+
+	payload := make([]byte, 4, size)
+	binary.LittleEndian.PutUint32(payload[0:4], uint32(len(entries)))
+	for _, e := range entries {
+		if families {
+			payload = binary.LittleEndian.AppendUint32(payload, e.Family)
+		}
+		payload = append(payload, byte(e.Kind))
+		payload = binary.LittleEndian.AppendUint32(payload, uint32(len(e.Key)))
+		payload = binary.LittleEndian.AppendUint32(payload, uint32(len(e.Value)))
+		payload = append(payload, e.Key...)
+		payload = append(payload, e.Value...)
+	}
+	return payload, nil
+}
+
+// decodeBatch parses a batch payload, written with column families if families is set.
+// It fails on any truncated or trailing bytes.
+func decodeBatch(payload []byte, families bool) ([]Entry, error) {
+	if len(payload) < 4 {
+		return nil, fmt.Errorf("batch payload too short")
+	}
+	count := binary.LittleEndian.Uint32(payload[0:4])
+	pos := 4
+
+	entries := make([]Entry, 0, count)
+	for i := uint32(0); i < count; i++ {
+		var family uint32
+		if families {
+			if len(payload)-pos < 4 {
+				return nil, fmt.Errorf("batch entry %d truncated", i)
+			}
+			family = binary.LittleEndian.Uint32(payload[pos : pos+4])
+			pos += 4
+		}
+		if len(payload)-pos < 9 {
+			return nil, fmt.Errorf("batch entry %d truncated", i)
+		}
+		k := kind.Kind(payload[pos])
+		keySize := int(binary.LittleEndian.Uint32(payload[pos+1 : pos+5]))
+		valSize := int(binary.LittleEndian.Uint32(payload[pos+5 : pos+9]))
+		pos += 9
+
+		if !k.Valid() {
+			return nil, fmt.Errorf("batch entry %d has invalid kind %d", i, k)
+		}
+		if len(payload)-pos < keySize+valSize {
+			return nil, fmt.Errorf("batch entry %d truncated", i)
+		}
+		entry := Entry{Family: family, Kind: k, Key: payload[pos : pos+keySize]}
+		pos += keySize
+		if k != kind.Delete {
+			entry.Value = payload[pos : pos+valSize]
+		}
+		pos += valSize
+		entries = append(entries, entry)
+	}
+
+	if pos != len(payload) {
+		return nil, fmt.Errorf("batch has %d trailing bytes", len(payload)-pos)
+	}
+	return entries, nil
+}
+
+// recordHeader is the fixed-size prefix of a record
+type recordHeader struct {
+	seq        uint64
+	recordType byte
+	keySize    uint32
+	valSize    uint32
+	checksum   uint32
+}
+
+// recordHeaderLen returns the size of a record header in the given format
+func recordHeaderLen(version uint32) int {
+	if version == formatLegacy {
+		return legacyHeaderSize
+	}
+	return recordHeaderSize
+}
+
+// decodeRecordHeader parses a record header written in the given format
+func decodeRecordHeader(version uint32, buf []byte) recordHeader {
+	h := recordHeader{seq: binary.LittleEndian.Uint64(buf[0:8])}
+	if version == formatLegacy {
+		h.keySize = binary.LittleEndian.Uint32(buf[8:12])
+		h.valSize = binary.LittleEndian.Uint32(buf[12:16])
+		h.checksum = binary.LittleEndian.Uint32(buf[16:20])
+
+		h.recordType = byte(kind.Value)
+		if h.keySize == batchMarker {
+			h.recordType, h.keySize = recordBatch, 0
+		} else if h.valSize == 0 {
+			h.recordType = byte(kind.Delete)
+		}
+		return h
+	}
+	h.recordType = buf[8]
+	h.keySize = binary.LittleEndian.Uint32(buf[9:13])
+	h.valSize = binary.LittleEndian.Uint32(buf[13:17])
+	h.checksum = binary.LittleEndian.Uint32(buf[17:21])
+	return h
+}
+
+// recordSize returns the size of the record with header h, header included
+func recordSize(version uint32, h recordHeader) int64 {
+	return int64(recordHeaderLen(version)) + int64(h.keySize) + int64(h.valSize)
+}
+
+// readRecord reads the next record written in the given format and returns the
+// sequence number of its first entry and the size of the record. limit is the number
+// of bytes left in the log, so a damaged size field never causes a huge allocation.
+// It returns io.EOF at a clean end of log and ErrCorrupt for a torn or damaged record.
+func readRecord(r io.Reader, version uint32, limit int64) (uint64, []Entry, int64, error) {
+	header := make([]byte, recordHeaderLen(version))
+	if n, err := io.ReadFull(r, header); err != nil {
+		if n == 0 && err == io.EOF {
+			return 0, nil, 0, io.EOF
+		}
+		return 0, nil, 0, ErrCorrupt // Partial header
+	}
+
+	h := decodeRecordHeader(version, header)
+	size := recordSize(version, h)
+	if size > limit {
+		return 0, nil, 0, ErrCorrupt // Torn, or the sizes are damaged
+	}
+
+	key := make([]byte, h.keySize)
+	if _, err := io.ReadFull(r, key); err != nil {
+		return 0, nil, 0, ErrCorrupt
+	}
+
+	value := make([]byte, h.valSize)
+	if _, err := io.ReadFull(r, value); err != nil {
+		return 0, nil, 0, ErrCorrupt
+	}
+
+	checksum := crc32.NewIEEE()
+	if version != formatLegacy {
+		checksum.Write([]byte{h.recordType})
+	}
+	checksum.Write(key)
+	checksum.Write(value)
+	if checksum.Sum32() != h.checksum {
+		return 0, nil, 0, ErrCorrupt
+	}
+
+	if h.recordType == recordBatch || (h.recordType == recordFamilyBatch && version != formatLegacy) {
+		// Batch records are applied all-or-nothing
+		entries, err := decodeBatch(value, h.recordType == recordFamilyBatch)
+		if err != nil {
+			return 0, nil, 0, ErrCorrupt
+		}
+		return h.seq, entries, size, nil
+	}
+
+	k := kind.Kind(h.recordType)
+	if !k.Valid() {
+		return 0, nil, 0, ErrCorrupt
+	}
+	if k == kind.Delete {
+		value = nil
+	}
+	return h.seq, []Entry{{Kind: k, Key: key, Value: value}}, size, nil
+}
@@ -0,0 +1,281 @@
+package wal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/thomazdavis/stratago/kind"
+)
+
+// RecoveryMode selects how a replay treats damaged records
+type RecoveryMode int
+
+const (
+	// TolerateCorruptedTail discards damage that runs to the end of the log, which is
+	// what a crash in the middle of a write leaves behind, but fails on damage
+	// followed by intact records
+	TolerateCorruptedTail RecoveryMode = iota
+
+	// AbsoluteConsistency fails on any damaged record, a torn tail included
+	AbsoluteConsistency
+
+	// SkipCorruptedRecords discards every damaged record and replays the intact ones around it
+	SkipCorruptedRecords
+)
+
+// Valid reports whether m is a known recovery mode
+func (m RecoveryMode) Valid() bool {
+	return m >= TolerateCorruptedTail && m <= SkipCorruptedRecords
+}
+
+func (m RecoveryMode) String() string {
+	switch m {
+	case TolerateCorruptedTail:
+		return "tolerate-corrupted-tail"
+	case AbsoluteConsistency:
+		return "absolute-consistency"
+	case SkipCorruptedRecords:
+		return "skip-corrupted-records"
+	}
+	return fmt.Sprintf("RecoveryMode(%d)", int(m))
+}
+
+// Corruption is a damaged region of a log that a replay discarded
+type Corruption struct {
+	Path   string
+	Offset int64 // From the start of the file
+	Size   int64
+}
+
+// RecoveryReport describes what a replay discarded
+type RecoveryReport struct {
+	// RecordsDiscarded counts the damaged regions, each of which is one record
+	// unless its size fields were damaged too
+	RecordsDiscarded int
+	BytesDiscarded   int64
+	Corruptions      []Corruption
+}
+
+// Add folds the report of another replay into r
+func (r *RecoveryReport) Add(other RecoveryReport) {
+	r.RecordsDiscarded += other.RecordsDiscarded
+	r.BytesDiscarded += other.BytesDiscarded
+	r.Corruptions = append(r.Corruptions, other.Corruptions...)
+}
+
+// ReaderOptions controls how a Reader replays a log
+type ReaderOptions struct {
+	// Mode decides whether damaged records fail the replay or are skipped
+	Mode RecoveryMode
+}
+
+// Reader streams the entries of a log in file order without loading it into memory.
+// Batch records are expanded into their entries, entry i carrying the sequence number
+// of the batch plus i, and a batch is only yielded once its whole record passed the
+// checksum. Damaged records are handled as the RecoveryMode dictates.
+type Reader struct {
+	file    *os.File // Owned by the reader, nil when reading an open WAL
+	ra      io.ReaderAt
+	path    string
+	size    int64
+	version uint32
+	mode    RecoveryMode
+	br      *bufio.Reader
+	offset  int64   // Offset of the next record
+	pending []Entry // Entries of the current record not yet yielded
+	nextSeq uint64  // Sequence number of pending[0]
+	entry   Entry
+	seq     uint64
+	report  RecoveryReport
+	done    bool
+	err     error
+}
+
+// NewReader opens the log at path for replay, tolerating a corrupted tail
+func NewReader(path string) (*Reader, error) {
+	return NewReaderWithOptions(path, ReaderOptions{})
+}
+
+// NewReaderWithOptions opens the log at path for replay. The file is never
+// written to, so a log in the legacy format is read as it is.
+func NewReaderWithOptions(path string, opts ReaderOptions) (*Reader, error) {
+	if !opts.Mode.Valid() {
+		return nil, fmt.Errorf("unknown recovery mode %d", int(opts.Mode))
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	// Logs too short for a header, or written before logs had one, are legacy
+	header := make([]byte, fileHeaderSize)
+	n, _ := file.ReadAt(header, 0)
+	version, start := formatLegacy, int64(0)
+	if n == fileHeaderSize && binary.LittleEndian.Uint32(header[0:4]) == walMagic {
+		version, start = binary.LittleEndian.Uint32(header[4:8]), fileHeaderSize
+		if version != currentFormat {
+			file.Close()
+			return nil, fmt.Errorf("unsupported WAL format version %d", version)
+		}
+	}
+
+	r := newReader(file, path, start, stat.Size(), version, opts.Mode)
+	r.file = file
+	return r, nil
+}
+
+// newReader reads the records of the given format between start and size
+func newReader(ra io.ReaderAt, path string, start, size int64, version uint32, mode RecoveryMode) *Reader {
+	return &Reader{
+		ra:      ra,
+		path:    path,
+		size:    size,
+		version: version,
+		mode:    mode,
+		br:      bufio.NewReader(io.NewSectionReader(ra, start, size-start)),
+		offset:  start,
+	}
+}
+
+// Next moves to the next entry and reports whether there is one
+func (r *Reader) Next() bool {
+	for len(r.pending) == 0 {
+		if r.done {
+			return false
+		}
+		seq, entries, size, err := readRecord(r.br, r.version, r.size-r.offset)
+		switch err {
+		case nil:
+			r.offset += size
+			r.pending, r.nextSeq = entries, seq
+		case io.EOF:
+			r.done = true
+		default:
+			r.corrupted()
+		}
+	}
+
+	r.entry, r.seq = r.pending[0], r.nextSeq
+	r.pending = r.pending[1:]
+	r.nextSeq++
+	return true
+}
+
+// corrupted handles the damaged record at r.offset as the recovery mode dictates
+func (r *Reader) corrupted() {
+	next := int64(-1)
+	if r.mode != AbsoluteConsistency {
+		next = r.nextIntactRecord()
+	}
+	if r.mode == AbsoluteConsistency || (r.mode == TolerateCorruptedTail && next >= 0) {
+		r.err = fmt.Errorf("%w in %s at offset %d", ErrCorrupt, r.path, r.offset)
+		r.done = true
+		return
+	}
+
+	end := next
+	if next < 0 {
+		end = r.size
+	}
+	r.report.RecordsDiscarded++
+	r.report.BytesDiscarded += end - r.offset
+	r.report.Corruptions = append(r.report.Corruptions, Corruption{Path: r.path, Offset: r.offset, Size: end - r.offset})
+
+	if next < 0 {
+		r.offset, r.done = r.size, true
+		return
+	}
+	r.offset = next
+	r.br.Reset(io.NewSectionReader(r.ra, next, r.size-next))
+}
+
+// probeWindow bounds how much of the log the search for an intact record buffers at once
+const probeWindow = 64 * 1024
+
+// nextIntactRecord returns the offset of the first record after the damaged one at
+// r.offset that passes its checksum, or -1 if the damage runs to the end of the log.
+// Every offset is tried as a header, but a record is only read in full when the sizes
+// in its header fit in the rest of the log.
+func (r *Reader) nextIntactRecord() int64 {
+	headerLen := recordHeaderLen(r.version)
+	start := r.offset + 1
+	window := bufio.NewReaderSize(io.NewSectionReader(r.ra, start, r.size-start), probeWindow)
+	for pos := start; r.size-pos >= int64(headerLen); pos++ {
+		header, err := window.Peek(headerLen)
+		if err != nil {
+			return -1
+		}
+		left := r.size - pos
+		if size := recordSize(r.version, decodeRecordHeader(r.version, header)); size <= left {
+			var rec io.Reader = io.NewSectionReader(r.ra, pos, left)
+			if size <= probeWindow {
+				// Small records are checked straight from the window
+				buf, _ := window.Peek(int(size))
+				rec = bytes.NewReader(buf)
+			}
+			if _, _, _, err := readRecord(rec, r.version, left); err == nil {
+				return pos
+			}
+		}
+		window.Discard(1)
+	}
+	return -1
+}
+
+// Seq returns the sequence number of the current entry
+func (r *Reader) Seq() uint64 {
+	return r.seq
+}
+
+// Family returns the column family of the current entry
+func (r *Reader) Family() uint32 {
+	return r.entry.Family
+}
+
+// Kind returns the kind of the current entry
+func (r *Reader) Kind() kind.Kind {
+	return r.entry.Kind
+}
+
+// Key returns the key of the current entry. Every entry gets its own slice,
+// so it may be kept after Next.
+func (r *Reader) Key() []byte {
+	return r.entry.Key
+}
+
+// Value returns the value or merge operand of the current entry, nil for a tombstone.
+// An empty value is non-nil.
+func (r *Reader) Value() []byte {
+	if r.entry.Kind != kind.Delete && r.entry.Value == nil {
+		return []byte{}
+	}
+	return r.entry.Value
+}
+
+// Error returns the corruption or I/O error that stopped the replay, if any
+func (r *Reader) Error() error {
+	return r.err
+}
+
+// Report returns what the replay has discarded so far
+func (r *Reader) Report() RecoveryReport {
+	return r.report
+}
+
+// Close releases the file opened by NewReader
+func (r *Reader) Close() error {
+	if r.file == nil {
+		return nil
+	}
+	return r.file.Close()
+}
@@ -0,0 +1,207 @@
+package wal
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/thomazdavis/stratago/kind"
+)
+
+// readerRecord is one entry yielded by a Reader
+type readerRecord struct {
+	seq   uint64
+	kind  kind.Kind
+	key   string
+	value []byte
+}
+
+func readAll(t *testing.T, filename string) []readerRecord {
+	r, err := NewReader(filename)
+	assert.NoError(t, err)
+	defer r.Close()
+
+	var res []readerRecord
+	for r.Next() {
+		res = append(res, readerRecord{r.Seq(), r.Kind(), string(r.Key()), r.Value()})
+	}
+	assert.NoError(t, r.Error())
+	return res
+}
+
+func TestReader_YieldsRecordsInOrder(t *testing.T) {
+	filename := "test_reader.log"
+	defer os.Remove(filename)
+
+	w, err := NewWAL(filename)
+	assert.NoError(t, err)
+	assert.NoError(t, w.Append(10, []Entry{{Kind: kind.Value, Key: []byte("k"), Value: []byte("new")}}, true))
+	assert.NoError(t, w.Append(4, []Entry{{Kind: kind.Value, Key: []byte("k"), Value: []byte("old")}}, true))
+	assert.NoError(t, w.Append(11, []Entry{
+		{Kind: kind.Value, Key: []byte("a"), Value: []byte{}},
+		{Kind: kind.Delete, Key: []byte("k")},
+	}, true))
+	assert.NoError(t, w.Close())
+
+	// File order is kept, and every entry carries its own sequence number
+	assert.Equal(t, []readerRecord{
+		{10, kind.Value, "k", []byte("new")},
+		{4, kind.Value, "k", []byte("old")},
+		{11, kind.Value, "a", []byte{}},
+		{12, kind.Delete, "k", nil},
+	}, readAll(t, filename))
+
+	// A torn tail ends the log
+	info, _ := os.Stat(filename)
+	assert.NoError(t, os.Truncate(filename, info.Size()-3))
+	assert.Len(t, readAll(t, filename), 2)
+}
+
+func TestReader_ColumnFamilies(t *testing.T) {
+	filename := "test_reader_families.log"
+	defer os.Remove(filename)
+
+	w, err := NewWAL(filename)
+	assert.NoError(t, err)
+	assert.NoError(t, w.Append(1, []Entry{{Family: 2, Kind: kind.Value, Key: []byte("a"), Value: []byte("1")}}, true))
+	assert.NoError(t, w.Append(2, []Entry{
+		{Kind: kind.Value, Key: []byte("b"), Value: []byte("2")},
+		{Family: 5, Kind: kind.Delete, Key: []byte("c")},
+	}, true))
+	assert.NoError(t, w.Append(4, []Entry{{Kind: kind.Value, Key: []byte("d"), Value: []byte("3")}}, true))
+	assert.NoError(t, w.Close())
+
+	r, err := NewReader(filename)
+	assert.NoError(t, err)
+	defer r.Close()
+
+	type familyRecord struct {
+		family uint32
+		seq    uint64
+		key    string
+	}
+	var res []familyRecord
+	for r.Next() {
+		res = append(res, familyRecord{r.Family(), r.Seq(), string(r.Key())})
+	}
+	assert.NoError(t, r.Error())
+	assert.Equal(t, []familyRecord{{2, 1, "a"}, {0, 2, "b"}, {5, 3, "c"}, {0, 4, "d"}}, res)
+}
+
+func TestReader_LegacyAndEmptyLogs(t *testing.T) {
+	filename := "test_reader_legacy.log"
+	defer os.Remove(filename)
+
+	var data []byte
+	data = binary.LittleEndian.AppendUint64(data, 7)
+	data = binary.LittleEndian.AppendUint32(data, 1)
+	data = binary.LittleEndian.AppendUint32(data, 1)
+	data = binary.LittleEndian.AppendUint32(data, crc32.ChecksumIEEE([]byte("ab")))
+	data = append(data, "ab"...)
+	assert.NoError(t, os.WriteFile(filename, data, 0644))
+
+	// Legacy logs are read without being upgraded
+	assert.Equal(t, []readerRecord{{7, kind.Value, "a", []byte("b")}}, readAll(t, filename))
+	stored, _ := os.ReadFile(filename)
+	assert.Equal(t, data, stored)
+
+	// A log created but never written to holds nothing
+	assert.NoError(t, os.WriteFile(filename, nil, 0644))
+	assert.Empty(t, readAll(t, filename))
+
+	_, err := NewReader("missing.log")
+	assert.Error(t, err)
+}
+
+func TestReader_RecoveryModes(t *testing.T) {
+	filename := "test_reader_modes.log"
+	defer os.Remove(filename)
+
+	// Three records of 23 bytes each after the 8 byte file header
+	w, err := NewWAL(filename)
+	assert.NoError(t, err)
+	for i, key := range []string{"a", "b", "c"} {
+		assert.NoError(t, w.Append(uint64(i+1), []Entry{{Kind: kind.Value, Key: []byte(key), Value: []byte("v")}}, true))
+	}
+	assert.NoError(t, w.Close())
+	clean, _ := os.ReadFile(filename)
+	const recordSize = recordHeaderSize + 2
+
+	replay := func(mode RecoveryMode) ([]string, RecoveryReport, error) {
+		r, err := NewReaderWithOptions(filename, ReaderOptions{Mode: mode})
+		assert.NoError(t, err)
+		defer r.Close()
+		var keys []string
+		for r.Next() {
+			keys = append(keys, string(r.Key()))
+		}
+		return keys, r.Report(), r.Error()
+	}
+
+	// Damage the value of the middle record
+	damaged := append([]byte{}, clean...)
+	damaged[fileHeaderSize+recordSize+recordHeaderSize+1] ^= 0xFF
+	assert.NoError(t, os.WriteFile(filename, damaged, 0644))
+
+	for _, mode := range []RecoveryMode{TolerateCorruptedTail, AbsoluteConsistency} {
+		keys, _, err := replay(mode)
+		assert.ErrorIs(t, err, ErrCorrupt, mode.String())
+		assert.ErrorContains(t, err, "offset 31")
+		assert.Equal(t, []string{"a"}, keys)
+	}
+
+	keys, report, err := replay(SkipCorruptedRecords)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "c"}, keys)
+	assert.Equal(t, RecoveryReport{
+		RecordsDiscarded: 1,
+		BytesDiscarded:   recordSize,
+		Corruptions:      []Corruption{{Path: filename, Offset: fileHeaderSize + recordSize, Size: recordSize}},
+	}, report)
+
+	// A torn tail is only an error with AbsoluteConsistency
+	assert.NoError(t, os.WriteFile(filename, clean[:len(clean)-3], 0644))
+	for _, mode := range []RecoveryMode{TolerateCorruptedTail, SkipCorruptedRecords} {
+		keys, report, err := replay(mode)
+		assert.NoError(t, err, mode.String())
+		assert.Equal(t, []string{"a", "b"}, keys)
+		assert.Equal(t, 1, report.RecordsDiscarded)
+		assert.Equal(t, int64(recordSize-3), report.BytesDiscarded)
+	}
+	_, _, err = replay(AbsoluteConsistency)
+	assert.ErrorIs(t, err, ErrCorrupt)
+
+	_, err = NewReaderWithOptions(filename, ReaderOptions{Mode: RecoveryMode(7)})
+	assert.Error(t, err)
+}
+
+func TestReader_SkipsDamageLongerThanProbeWindow(t *testing.T) {
+	filename := "test_reader_probe.log"
+	defer os.Remove(filename)
+
+	// Records larger than the window on both sides of the damage
+	big := make([]byte, 3*probeWindow)
+	w, err := NewWAL(filename)
+	assert.NoError(t, err)
+	assert.NoError(t, w.Append(1, []Entry{{Kind: kind.Value, Key: []byte("a"), Value: big}}, true))
+	assert.NoError(t, w.Append(2, []Entry{{Kind: kind.Value, Key: []byte("b"), Value: big}}, true))
+	assert.NoError(t, w.Append(3, []Entry{{Kind: kind.Value, Key: []byte("c"), Value: []byte("v")}}, true))
+	assert.NoError(t, w.Close())
+
+	data, _ := os.ReadFile(filename)
+	data[fileHeaderSize+recordHeaderSize+1] ^= 0xFF
+	assert.NoError(t, os.WriteFile(filename, data, 0644))
+
+	r, err := NewReaderWithOptions(filename, ReaderOptions{Mode: SkipCorruptedRecords})
+	assert.NoError(t, err)
+	defer r.Close()
+	var keys []string
+	for r.Next() {
+		keys = append(keys, string(r.Key()))
+	}
+	assert.NoError(t, r.Error())
+	assert.Equal(t, []string{"b", "c"}, keys)
+	assert.Equal(t, int64(recordHeaderSize+1+len(big)), r.Report().BytesDiscarded)
+}
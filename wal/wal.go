@@ -2,120 +2,251 @@ package wal
 
 import (
 	"encoding/binary"
-	"hash/crc32"
-	"io"
+	"fmt"
 	"os"
 	"sync"
+
+	"github.com/thomazdavis/stratago/kind"
 )
 
+// Entry is a single versioned operation carried by a record
+type Entry struct {
+	Family uint32 // Column family, 0 for the default one
+	Kind   kind.Kind
+	Key    []byte
+	Value  []byte
+}
+
+// logFile is the part of *os.File a log appends through
+type logFile interface {
+	Write(b []byte) (int, error)
+	Sync() error
+	Close() error
+}
+
 type WAL struct {
-	file           *os.File
+	file           logFile
 	mu             sync.Mutex
 	path           string
 	sequenceNumber uint64
+	size           int64 // Bytes in the file, header included
+	closed         bool
+	err            error // First failed write, after which every append fails
 }
 
+// NewWAL opens the log at path, creating it if needed. A log written before
+// the format was versioned is upgraded in place to the current format.
 func NewWAL(path string) (*WAL, error) {
 	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0644)
 	if err != nil {
 		return nil, err
 	}
+
+	version, err := readFileHeader(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	if version == formatLegacy {
+		file.Close()
+		if err := upgradeLegacy(path); err != nil {
+			return nil, fmt.Errorf("failed to upgrade legacy WAL: %w", err)
+		}
+		if file, err = os.OpenFile(path, os.O_APPEND|os.O_RDWR, 0644); err != nil {
+			return nil, err
+		}
+	}
+
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
 	return &WAL{
-		file: file, path: path,
+		file: file, path: path, size: stat.Size(),
 	}, nil
 }
 
-// WriteEntry saves a Key-Value pair to the log.
-// Format: [SeqNum (8B)] [Key Size (4B)] [Val Size (4B)] [Checksum (4B)] [Key Bytes] [Value Bytes]
-func (w *WAL) WriteEntry(key, value []byte) error {
-	w.mu.Lock()
-	defer w.mu.Unlock()
+// readFileHeader returns the format version of an open log, writing the
+// header first if the file is brand new.
+func readFileHeader(file *os.File) (uint32, error) {
+	stat, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
 
-	w.sequenceNumber++
+	if stat.Size() == 0 {
+		if _, err := file.Write(encodeFileHeader()); err != nil {
+			return 0, err
+		}
+		return currentFormat, file.Sync()
+	}
 
-	// Calculate checksum over key+value
-	h := crc32.NewIEEE()
-	h.Write(key)
-	h.Write(value)
-	checksum := h.Sum32()
+	header := make([]byte, fileHeaderSize)
+	if n, err := file.ReadAt(header, 0); err != nil && n < fileHeaderSize {
+		return formatLegacy, nil // Too short for a header, must be a torn legacy log
+	}
+	if binary.LittleEndian.Uint32(header[0:4]) != walMagic {
+		return formatLegacy, nil
+	}
 
-	// Calculate sizes
-	var buf [20]byte
-	binary.LittleEndian.PutUint64(buf[0:8], w.sequenceNumber)
-	binary.LittleEndian.PutUint32(buf[8:12], uint32(len(key)))
-	binary.LittleEndian.PutUint32(buf[12:16], uint32(len(value)))
-	binary.LittleEndian.PutUint32(buf[16:20], checksum)
+	version := binary.LittleEndian.Uint32(header[4:8])
+	if version != currentFormat {
+		return 0, fmt.Errorf("unsupported WAL format version %d", version)
+	}
+	return version, nil
+}
 
-	// Write the Header
-	if _, err := w.file.Write(buf[:]); err != nil {
+// upgradeLegacy rewrites a legacy log in the current format. Records are copied
+// up to the first torn or corrupt one, which recovery would have stopped at anyway.
+func upgradeLegacy(path string) error {
+	old, err := os.Open(path)
+	if err != nil {
 		return err
 	}
+	defer old.Close()
 
-	// Write the Key
-	if _, err := w.file.Write(key); err != nil {
+	tmpPath := path + ".upgrade"
+	tmp, err := os.Create(tmpPath)
+	if err != nil {
 		return err
 	}
+	defer os.Remove(tmpPath) // No-op once renamed
 
-	// Write the Value
-	if _, err := w.file.Write(value); err != nil {
+	if _, err := tmp.Write(encodeFileHeader()); err != nil {
+		tmp.Close()
 		return err
 	}
 
-	// Sync to Disk: flush the buffer to the hard drive
-	return w.file.Sync()
+	info, err := old.Stat()
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	remaining := info.Size()
+	for {
+		seq, entries, size, err := readRecord(old, formatLegacy, remaining)
+		if err != nil {
+			break
+		}
+		remaining -= size
+
+		record, err := encodeEntries(seq, entries)
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+		if _, err := tmp.Write(record); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
 }
 
-// Close safely closes the file handle
-func (w *WAL) Close() error {
+// Append saves entries under sequence numbers seq, seq+1... as one record, a batch
+// record if there are several or any is outside the default column family. Without
+// sync the record is left to the OS to persist until the next synced append, Sync or Close.
+func (w *WAL) Append(seq uint64, entries []Entry, sync bool) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	record, err := encodeEntries(seq, entries)
+	if err != nil {
+		return err
+	}
+
 	w.mu.Lock()
 	defer w.mu.Unlock()
-	return w.file.Close()
+
+	lastSeq := seq + uint64(len(entries)) - 1
+	return w.appendLocked(lastSeq, record, sync)
 }
 
-func (w *WAL) Path() string {
-	return w.path
+// appendLocked writes one encoded record and optionally syncs it. Callers must hold w.mu.
+// A failed write may leave a torn record behind, and a record appended after it would be
+// read as damage followed by intact records, so the log refuses every later append.
+func (w *WAL) appendLocked(lastSeq uint64, record []byte, sync bool) error {
+	if w.err != nil {
+		return fmt.Errorf("WAL %s is unusable after a failed write: %w", w.path, w.err)
+	}
+	if lastSeq > w.sequenceNumber {
+		w.sequenceNumber = lastSeq
+	}
+
+	n, err := w.file.Write(record)
+	w.size += int64(n)
+	if err != nil {
+		w.err = err
+		return err
+	}
+	if !sync {
+		return nil
+	}
+
+	// Sync to Disk: flush the buffer to the hard drive
+	return w.file.Sync()
 }
 
-func (w *WAL) Recover() (map[string][]byte, error) {
+// Sync flushes every record written so far to stable storage. A closed log was
+// already synced by Close, so syncing it is a no-op.
+func (w *WAL) Sync() error {
 	w.mu.Lock()
 	defer w.mu.Unlock()
-
-	header := make([]byte, 20) // SeqNum(8) + KeySize(4) + ValSize(4) + Checksum(4)
-	data := make(map[string][]byte)
-
-	if _, err := w.file.Seek(0, 0); err != nil {
-		return nil, err
+	if w.closed {
+		return nil
 	}
+	return w.file.Sync()
+}
 
-	for {
-		if _, err := io.ReadFull(w.file, header); err != nil {
-			break // EOF or partial header
-		}
-
-		seqNum := binary.LittleEndian.Uint64(header[0:8])
-		keySize := binary.LittleEndian.Uint32(header[8:12])
-		valSize := binary.LittleEndian.Uint32(header[12:16])
-		expectedChecksum := binary.LittleEndian.Uint32(header[16:20])
+// Close syncs any unsynced records and closes the file handle
+func (w *WAL) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return nil
+	}
+	w.closed = true
 
-		key := make([]byte, keySize)
-		if _, err := io.ReadFull(w.file, key); err != nil {
-			break
-		}
+	syncErr := w.file.Sync()
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+	return syncErr
+}
 
-		value := make([]byte, valSize)
-		if _, err := io.ReadFull(w.file, value); err != nil {
-			break
-		}
+// Err returns the write failure that made the log refuse appends, or nil
+func (w *WAL) Err() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
 
-		if crc32.ChecksumIEEE(append(key, value...)) != expectedChecksum {
-			break
-		}
+// Size returns the size of the log file in bytes
+func (w *WAL) Size() int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.size
+}
 
-		if seqNum > w.sequenceNumber {
-			w.sequenceNumber = seqNum
-		}
+func (w *WAL) Path() string {
+	return w.path
+}
 
-		data[string(key)] = value
-	}
-	return data, nil
+// LastSequence returns the highest sequence number appended through this handle
+func (w *WAL) LastSequence() uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.sequenceNumber
 }
@@ -1,10 +1,14 @@
 package wal
 
 import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
 	"os"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/thomazdavis/stratago/kind"
 )
 
 func TestWAL_WriteAndRecover(t *testing.T) {
@@ -14,28 +18,21 @@ func TestWAL_WriteAndRecover(t *testing.T) {
 	w, err := NewWAL(filename)
 	assert.NoError(t, err)
 
-	err = w.WriteEntry([]byte("user:101"), []byte("Thomas"))
+	err = w.Append(1, []Entry{{Kind: kind.Value, Key: []byte("user:101"), Value: []byte("Thomas")}}, true)
 	assert.NoError(t, err)
 
-	err = w.WriteEntry([]byte("user:102"), []byte("Davis"))
+	err = w.Append(2, []Entry{{Kind: kind.Value, Key: []byte("user:102"), Value: []byte("Davis")}}, true)
 	assert.NoError(t, err)
 
 	// Close the WAL (Simulate Shutdown)
 	err = w.Close()
 	assert.NoError(t, err)
 
-	// Re-open and Recover (Simulate Restart)
-	w2, err := NewWAL(filename)
-	assert.NoError(t, err)
-	defer w2.Close()
-
-	restoredData, err := w2.Recover()
-	assert.NoError(t, err)
-
-	// Verify Data Integrity
-	assert.Equal(t, 2, len(restoredData))
-	assert.Equal(t, []byte("Thomas"), restoredData["user:101"])
-	assert.Equal(t, []byte("Davis"), restoredData["user:102"])
+	// Replay (Simulate Restart) and verify Data Integrity
+	assert.Equal(t, []readerRecord{
+		{1, kind.Value, "user:101", []byte("Thomas")},
+		{2, kind.Value, "user:102", []byte("Davis")},
+	}, readAll(t, filename))
 }
 
 func TestWAL_Recovery_Corruption(t *testing.T) {
@@ -43,7 +40,7 @@ func TestWAL_Recovery_Corruption(t *testing.T) {
 	defer os.Remove(filename)
 
 	w, _ := NewWAL(filename)
-	w.WriteEntry([]byte("valid_key"), []byte("value"))
+	w.Append(1, []Entry{{Kind: kind.Value, Key: []byte("valid_key"), Value: []byte("value")}}, true)
 	w.Close()
 
 	// Manually corrupt the file by appending junk
@@ -51,11 +48,181 @@ func TestWAL_Recovery_Corruption(t *testing.T) {
 	f.Write([]byte{0xFF, 0x00, 0x11}) // Partial/Garbage header
 	f.Close()
 
-	w2, _ := NewWAL(filename)
-	data, err := w2.Recover()
-
 	// Should not error, but should stop at the point of corruption
+	assert.Equal(t, []readerRecord{{1, kind.Value, "valid_key", []byte("value")}}, readAll(t, filename))
+}
+
+func TestWAL_LastSequence(t *testing.T) {
+	filename := "seq_wal.log"
+	defer os.Remove(filename)
+
+	w, err := NewWAL(filename)
 	assert.NoError(t, err)
-	assert.Equal(t, 1, len(data))
-	assert.Equal(t, []byte("value"), data["valid_key"])
+
+	// A newer write lands in the file before an older one
+	assert.NoError(t, w.Append(10, []Entry{{Kind: kind.Value, Key: []byte("k"), Value: []byte("new")}}, true))
+	assert.NoError(t, w.Append(4, []Entry{{Kind: kind.Value, Key: []byte("k"), Value: []byte("old")}}, true))
+	assert.NoError(t, w.Append(7, []Entry{{Kind: kind.Value, Key: []byte("j"), Value: []byte("v")}}, true))
+	assert.Equal(t, uint64(10), w.LastSequence())
+	assert.NoError(t, w.Append(11, []Entry{
+		{Kind: kind.Value, Key: []byte("a"), Value: []byte("1")},
+		{Kind: kind.Value, Key: []byte("b"), Value: []byte("2")},
+	}, true))
+	assert.Equal(t, uint64(12), w.LastSequence(), "A batch ends at the sequence number of its last entry")
+	w.Close()
+}
+
+func TestWAL_BatchAllOrNothing(t *testing.T) {
+	filename := "batch_wal.log"
+	defer os.Remove(filename)
+
+	w, err := NewWAL(filename)
+	assert.NoError(t, err)
+
+	assert.NoError(t, w.Append(1, []Entry{{Kind: kind.Value, Key: []byte("single"), Value: []byte("v")}}, true))
+	assert.NoError(t, w.Append(2, []Entry{
+		{Kind: kind.Value, Key: []byte("a"), Value: []byte("1")},
+		{Kind: kind.Delete, Key: []byte("single")},
+	}, true))
+	assert.NoError(t, w.Append(4, []Entry{
+		{Kind: kind.Value, Key: []byte("b"), Value: []byte("2")},
+		{Kind: kind.Value, Key: []byte("c"), Value: []byte("3")},
+	}, true))
+	w.Close()
+
+	applied := []readerRecord{
+		{1, kind.Value, "single", []byte("v")},
+		{2, kind.Value, "a", []byte("1")},
+		{3, kind.Delete, "single", nil},
+	}
+	assert.Equal(t, append(applied,
+		readerRecord{4, kind.Value, "b", []byte("2")},
+		readerRecord{5, kind.Value, "c", []byte("3")},
+	), readAll(t, filename))
+
+	// Tear the last batch record, none of its entries may survive
+	info, _ := os.Stat(filename)
+	assert.NoError(t, os.Truncate(filename, info.Size()-3))
+	assert.Equal(t, applied, readAll(t, filename))
+}
+
+func TestWAL_UpgradesLegacyLog(t *testing.T) {
+	filename := "legacy_wal.log"
+	defer os.Remove(filename)
+
+	// Legacy records: [Seq][KeySize][ValSize][CRC][Key][Value], deletes are empty values
+	var data []byte
+	record := func(seq uint64, key, val string) {
+		data = binary.LittleEndian.AppendUint64(data, seq)
+		data = binary.LittleEndian.AppendUint32(data, uint32(len(key)))
+		data = binary.LittleEndian.AppendUint32(data, uint32(len(val)))
+		data = binary.LittleEndian.AppendUint32(data, crc32.ChecksumIEEE([]byte(key+val)))
+		data = append(data, key...)
+		data = append(data, val...)
+	}
+	record(1, "a", "1")
+	record(2, "b", "2")
+	record(3, "b", "")
+	assert.NoError(t, os.WriteFile(filename, data, 0644))
+
+	w, err := NewWAL(filename)
+	assert.NoError(t, err)
+
+	upgraded := []readerRecord{
+		{1, kind.Value, "a", []byte("1")},
+		{2, kind.Value, "b", []byte("2")},
+		{3, kind.Delete, "b", nil}, // Legacy empty values are deletions
+	}
+	assert.Equal(t, upgraded, readAll(t, filename))
+
+	// New writes use the current format and can hold empty values
+	assert.NoError(t, w.Append(4, []Entry{{Kind: kind.Value, Key: []byte("c"), Value: []byte{}}}, true))
+	w.Close()
+	assert.Equal(t, append(upgraded, readerRecord{4, kind.Value, "c", []byte{}}), readAll(t, filename))
+}
+
+func TestWAL_AppendWithoutSync(t *testing.T) {
+	filename := "test_wal_nosync.log"
+	defer os.Remove(filename)
+
+	w, err := NewWAL(filename)
+	assert.NoError(t, err)
+
+	assert.NoError(t, w.Append(1, []Entry{{Kind: kind.Value, Key: []byte("a"), Value: []byte("1")}}, false))
+	assert.NoError(t, w.Append(2, []Entry{
+		{Kind: kind.Value, Key: []byte("b"), Value: []byte("2")},
+		{Kind: kind.Delete, Key: []byte("a")},
+	}, false))
+	assert.NoError(t, w.Sync())
+	assert.Equal(t, uint64(3), w.LastSequence())
+
+	// Close syncs, closing twice and syncing a closed log are no-ops
+	assert.NoError(t, w.Close())
+	assert.NoError(t, w.Close())
+	assert.NoError(t, w.Sync())
+
+	assert.Equal(t, []readerRecord{
+		{1, kind.Value, "a", []byte("1")},
+		{2, kind.Value, "b", []byte("2")},
+		{3, kind.Delete, "a", nil},
+	}, readAll(t, filename))
+}
+
+func TestWAL_Size(t *testing.T) {
+	filename := "test_wal_size.log"
+	defer os.Remove(filename)
+
+	w, err := NewWAL(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(fileHeaderSize), w.Size())
+
+	assert.NoError(t, w.Append(1, []Entry{{Kind: kind.Value, Key: []byte("key"), Value: []byte("value")}}, true))
+	assert.NoError(t, w.Close())
+
+	stat, err := os.Stat(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, stat.Size(), w.Size())
+
+	// Reopening picks up the size of the existing file
+	w2, err := NewWAL(filename)
+	assert.NoError(t, err)
+	defer w2.Close()
+	assert.Equal(t, stat.Size(), w2.Size())
+}
+
+// failingFile writes half of the next record to the log file and then fails
+type failingFile struct {
+	*os.File
+}
+
+func (f failingFile) Write(b []byte) (int, error) {
+	n, _ := f.File.Write(b[:len(b)/2])
+	return n, errors.New("disk full")
+}
+
+func TestWAL_RefusesAppendsAfterFailedWrite(t *testing.T) {
+	filename := "test_wal_failed_write.log"
+	defer os.Remove(filename)
+
+	w, err := NewWAL(filename)
+	assert.NoError(t, err)
+	assert.NoError(t, w.Append(1, []Entry{{Kind: kind.Value, Key: []byte("a"), Value: []byte("1")}}, true))
+	assert.NoError(t, w.Err())
+
+	file := w.file.(*os.File)
+	w.file = failingFile{file}
+	assert.Error(t, w.Append(2, []Entry{{Kind: kind.Value, Key: []byte("b"), Value: []byte("2")}}, true))
+	assert.Error(t, w.Err())
+
+	// Nothing lands after the torn record, even once the file accepts writes again
+	w.file = file
+	stat, _ := os.Stat(filename)
+	err = w.Append(3, []Entry{{Kind: kind.Value, Key: []byte("c"), Value: []byte("3")}}, true)
+	assert.ErrorContains(t, err, "disk full")
+	after, _ := os.Stat(filename)
+	assert.Equal(t, stat.Size(), after.Size())
+	assert.NoError(t, w.Close())
+
+	// The torn record is a tail that recovery discards
+	assert.Equal(t, []readerRecord{{1, kind.Value, "a", []byte("1")}}, readAll(t, filename))
 }